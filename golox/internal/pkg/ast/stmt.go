@@ -13,12 +13,16 @@ type StmtVisitor interface {
 	VisitBlockStmt(stmt *Block) (interface{}, error)
 	VisitClassStmt(stmt *Class) (interface{}, error)
 	VisitExpressionStmt(stmt *Expression) (interface{}, error)
+	VisitForInStmt(stmt *ForIn) (interface{}, error)
 	VisitFunctionStmt(stmt *Function) (interface{}, error)
 	VisitIfStmt(stmt *If) (interface{}, error)
 	VisitPrintStmt(stmt *Print) (interface{}, error)
 	VisitReturnStmt(stmt *Return) (interface{}, error)
+	VisitSpawnStmt(stmt *Spawn) (interface{}, error)
+	VisitTestStmt(stmt *Test) (interface{}, error)
 	VisitVarStmt(stmt *Var) (interface{}, error)
 	VisitWhileStmt(stmt *While) (interface{}, error)
+	VisitYieldStmt(stmt *Yield) (interface{}, error)
 }
 
 type StmtAcceptor interface {
@@ -51,10 +55,22 @@ func (x *Expression) Accept(v StmtVisitor) (interface{}, error) {
 	return v.VisitExpressionStmt(x)
 }
 
+type ForIn struct {
+	Keyword *token.Token
+	Name *token.Token
+	Iterable Expr
+	Body Stmt
+}
+
+func (x *ForIn) Accept(v StmtVisitor) (interface{}, error) {
+	return v.VisitForInStmt(x)
+}
+
 type Function struct {
 	Name *token.Token
 	Params []*token.Token
 	Body []Stmt
+	Generator bool
 }
 
 func (x *Function) Accept(v StmtVisitor) (interface{}, error) {
@@ -72,6 +88,7 @@ func (x *If) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type Print struct {
+	Keyword *token.Token
 	Expression Expr
 }
 
@@ -88,6 +105,25 @@ func (x *Return) Accept(v StmtVisitor) (interface{}, error) {
 	return v.VisitReturnStmt(x)
 }
 
+type Spawn struct {
+	Keyword *token.Token
+	Call *Call
+}
+
+func (x *Spawn) Accept(v StmtVisitor) (interface{}, error) {
+	return v.VisitSpawnStmt(x)
+}
+
+type Test struct {
+	Keyword *token.Token
+	Name *token.Token
+	Body []Stmt
+}
+
+func (x *Test) Accept(v StmtVisitor) (interface{}, error) {
+	return v.VisitTestStmt(x)
+}
+
 type Var struct {
 	Name *token.Token
 	Initializer Expr
@@ -106,3 +142,12 @@ func (x *While) Accept(v StmtVisitor) (interface{}, error) {
 	return v.VisitWhileStmt(x)
 }
 
+type Yield struct {
+	Keyword *token.Token
+	Value Expr
+}
+
+func (x *Yield) Accept(v StmtVisitor) (interface{}, error) {
+	return v.VisitYieldStmt(x)
+}
+
@@ -1,20 +1,75 @@
 package common
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
-var DEBUGLOX = 0
+// Debug is a set of debug categories, each printing the workings of a stage
+// as it runs. Every interpreter has its own.
+type Debug int
 
 const (
-	SCANNING = 1 << iota
+	SCANNING Debug = 1 << iota
+	BYTECODE
+	TRACE
 )
 
-func SetDebug(settings string) {
-	settingsSlice := strings.Split(settings, ",")
+// DebugCategories names the debug categories in the order of their flags.
+var DebugCategories = []string{"scanning", "bytecode", "trace"}
+
+// ParseDebug returns the categories in a comma separated list, such as the
+// value of DEBUGLOX. Unknown categories are ignored.
+func ParseDebug(settings string) Debug {
+	var d Debug
+	for _, set := range strings.Split(settings, ",") {
+		if flag, ok := debugFlag(set); ok {
+			d |= flag
+		}
+	}
+
+	return d
+}
 
-	for _, set := range settingsSlice {
-		switch strings.ToLower(set) {
-		case "scanning":
-			DEBUGLOX |= SCANNING
+// Toggle turns each of a comma separated list of categories on if it is off
+// and off if it is on. Nothing changes if a category is unknown.
+func (d *Debug) Toggle(settings string) error {
+	var flags Debug
+	for _, set := range strings.Split(settings, ",") {
+		flag, ok := debugFlag(set)
+		if !ok {
+			return fmt.Errorf("unknown debug category %q", strings.TrimSpace(set))
 		}
+		flags |= flag
 	}
+
+	*d ^= flags
+	return nil
+}
+
+// String lists the enabled categories, separated by commas.
+func (d Debug) String() string {
+	var enabled []string
+	for n, name := range DebugCategories {
+		if d&(1<<n) != 0 {
+			enabled = append(enabled, name)
+		}
+	}
+
+	if len(enabled) == 0 {
+		return "none"
+	}
+
+	return strings.Join(enabled, ",")
+}
+
+func debugFlag(name string) (Debug, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for n, category := range DebugCategories {
+		if name == category {
+			return 1 << n, true
+		}
+	}
+
+	return 0, false
 }
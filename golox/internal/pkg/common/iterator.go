@@ -0,0 +1,168 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Iterator produces the values of a list, string or range one at a time. Like
+// the iterators Lox classes define, it has hasNext and next methods, and an
+// iterator method returning itself so it is iterable too.
+type Iterator struct {
+	next func() (interface{}, bool)
+
+	// value is the next value when ready is set
+	value interface{}
+	ready bool
+	done  bool
+}
+
+// NewIterator returns an Iterator over the values next returns until it
+// reports there are none left.
+func NewIterator(next func() (interface{}, bool)) *Iterator {
+	return &Iterator{next: next}
+}
+
+// Iterate returns an iterator over the values of a list, the characters of a
+// string or the numbers of a range, or reports that value is none of those.
+func Iterate(value interface{}) (*Iterator, bool) {
+	switch v := value.(type) {
+	case *Iterator:
+		return v, true
+	case *List:
+		return v.iterator(), true
+	case *Range:
+		return v.iterator(), true
+	case string:
+		return NewIterator(func() (interface{}, bool) {
+			if len(v) == 0 {
+				return nil, false
+			}
+
+			_, size := utf8.DecodeRuneInString(v)
+			character := v[:size]
+			v = v[size:]
+			return character, true
+		}), true
+	}
+
+	return nil, false
+}
+
+func (it *Iterator) String() string {
+	return "<iterator>"
+}
+
+func (it *Iterator) Method(name string) (*NativeMethod, bool) {
+	switch name {
+	case "hasNext":
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return it.HasNext(), nil
+		}}, true
+	case "next":
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return it.Next()
+		}}, true
+	case "iterator":
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return it, nil
+		}}, true
+	}
+
+	return nil, false
+}
+
+// HasNext reports whether the iterator has a value left.
+func (it *Iterator) HasNext() bool {
+	if !it.ready && !it.done {
+		it.value, it.ready = it.next()
+		it.done = !it.ready
+	}
+
+	return it.ready
+}
+
+// Next returns the next value, or an error if there are none left.
+func (it *Iterator) Next() (interface{}, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("iterator has no values left")
+	}
+
+	it.ready = false
+	return it.value, nil
+}
+
+// Range is a sequence of numbers from Start up to, but not including, End,
+// counting by Step. A negative Step counts down.
+type Range struct {
+	Start, End, Step float64
+}
+
+// NewRange returns the range described by the arguments of the range native:
+// an end, a start and an end, or a start, an end and a step.
+func NewRange(arguments []interface{}) (*Range, error) {
+	if len(arguments) == 0 || len(arguments) > 3 {
+		return nil, fmt.Errorf("expected 1 to 3 arguments but got %d",
+			len(arguments))
+	}
+
+	bounds := make([]float64, len(arguments))
+	for n, argument := range arguments {
+		number, ok := argument.(float64)
+		if !ok {
+			return nil, fmt.Errorf("range bounds must be numbers")
+		} else if math.IsNaN(number) || math.IsInf(number, 0) {
+			// Infinite bounds would make the range endless, and NaN
+			// compares false with everything
+			return nil, fmt.Errorf("range bounds must be finite")
+		}
+		bounds[n] = number
+	}
+
+	r := &Range{Step: 1}
+	switch len(bounds) {
+	case 1:
+		r.End = bounds[0]
+	case 2:
+		r.Start, r.End = bounds[0], bounds[1]
+	case 3:
+		r.Start, r.End, r.Step = bounds[0], bounds[1], bounds[2]
+	}
+
+	if r.Step == 0 {
+		return nil, fmt.Errorf("range step can't be zero")
+	}
+
+	return r, nil
+}
+
+func (r *Range) String() string {
+	return fmt.Sprintf("range(%s, %s, %s)", Stringfy(r.Start), Stringfy(r.End),
+		Stringfy(r.Step))
+}
+
+func (r *Range) Method(name string) (*NativeMethod, bool) {
+	if name == "iterator" {
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return r.iterator(), nil
+		}}, true
+	}
+
+	return nil, false
+}
+
+func (r *Range) iterator() *Iterator {
+	// Multiplying rather than adding up steps keeps rounding errors in
+	// fractional steps from piling up
+	steps := 0
+	return NewIterator(func() (interface{}, bool) {
+		n := r.Start + float64(steps)*r.Step
+		if r.Step > 0 && n >= r.End || r.Step < 0 && n <= r.End {
+			return nil, false
+		}
+
+		steps++
+		return n, true
+	})
+}
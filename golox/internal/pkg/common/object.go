@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NativeMethod is a method of a value implemented in Go. Both engines call it
+// like a native function, turning an error it returns into a runtime error at
+// the call.
+type NativeMethod struct {
+	Name  string
+	Arity int
+	Call  func(arguments []interface{}) (interface{}, error)
+}
+
+func (m *NativeMethod) String() string {
+	return "<native fn>"
+}
+
+// Object is a value implemented in Go whose methods Lox code can call, as in
+// "list.length()". Objects have no fields.
+type Object interface {
+	// Method returns the method called name bound to the object.
+	Method(name string) (*NativeMethod, bool)
+}
+
+// List is an ordered sequence of values, such as the arguments a script is
+// run with.
+type List struct {
+	Elements []interface{}
+}
+
+// NewStringList returns a List holding strs.
+func NewStringList(strs []string) *List {
+	elements := make([]interface{}, len(strs))
+	for n, s := range strs {
+		elements[n] = s
+	}
+
+	return &List{Elements: elements}
+}
+
+func (l *List) String() string {
+	elements := make([]string, len(l.Elements))
+	for n, element := range l.Elements {
+		elements[n] = Stringfy(element)
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (l *List) Method(name string) (*NativeMethod, bool) {
+	switch name {
+	case "length":
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return float64(len(l.Elements)), nil
+		}}, true
+	case "get":
+		return &NativeMethod{Name: name, Arity: 1, Call: func(arguments []interface{}) (interface{}, error) {
+			index, err := l.index(arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			return l.Elements[index], nil
+		}}, true
+	case "iterator":
+		return &NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return l.iterator(), nil
+		}}, true
+	}
+
+	return nil, false
+}
+
+// index checks that value is an index of the list.
+func (l *List) index(value interface{}) (int, error) {
+	n, ok := value.(float64)
+	if !ok || n != float64(int(n)) {
+		return 0, fmt.Errorf("list index must be an integer")
+	}
+
+	if n < 0 || int(n) >= len(l.Elements) {
+		return 0, fmt.Errorf("list index %s out of range", Stringfy(n))
+	}
+
+	return int(n), nil
+}
+
+func (l *List) iterator() *Iterator {
+	n := 0
+	return NewIterator(func() (interface{}, bool) {
+		if n >= len(l.Elements) {
+			return nil, false
+		}
+
+		n++
+		return l.Elements[n-1], true
+	})
+}
@@ -0,0 +1,406 @@
+// Package golden runs Lox scripts in process and checks them against the
+// expectations written in their comments, in the same style as the official
+// craftinginterpreters test suite:
+//
+//	print a; // expect: value
+//	a.b;     // expect runtime error: Undefined variable 'a'.
+//	(a) = 1; // Error at '=': Invalid assignment target.
+//	// [line 3] Error: Unterminated string.
+//
+// A "[java line N]" or "[c line N]" prefix limits an error to one of the
+// reference implementations. Both golox engines share a parser modeled on the
+// Java one, so only the former applies. Messages are compared after
+// normalizing case, quotes and trailing periods since golox words its errors
+// in lower case.
+package golden
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/lox"
+)
+
+var (
+	expectedOutputPattern  = regexp.MustCompile(`// expect: ?(.*)`)
+	expectedErrorPattern   = regexp.MustCompile(`// (Error.*)`)
+	errorLinePattern       = regexp.MustCompile(`// \[((java|c) )?line (\d+)\] (Error.*)`)
+	expectedRuntimePattern = regexp.MustCompile(`// expect runtime error: (.+)`)
+
+	// Output produced by golox
+	syntaxErrorPattern  = regexp.MustCompile(`^\[line (\d+)\] (error.*)$`)
+	runtimeErrorPattern = regexp.MustCompile(`^\[line (\d+)\] RuntimeError: (.*)$`)
+)
+
+// Directories and scripts, relative to the suite, that are not run. The
+// scanning and expressions tests exercise the token and syntax tree dumps of
+// earlier chapters rather than programs. The tree-walk interpreter has none of
+// the bytecode limits other than the call depth. The VM has 16-bit constant
+// operands, and the syntax tree keeps no token for the closing brace clox
+// reports a long loop at. method/assign_variable.lox prints a result it has no
+// expectation for, which only its Go test checks. Goroutines and generators
+// only exist in the tree-walk interpreter.
+var skip = map[lox.Engine][]string{
+	lox.ENGINE_TREE: {"scanning", "expressions", "method/assign_variable.lox",
+		"limit/loop_too_large.lox", "limit/no_reuse_constants.lox",
+		"limit/too_many_constants.lox", "limit/too_many_locals.lox",
+		"limit/too_many_upvalues.lox"},
+	lox.ENGINE_VM: {"scanning", "expressions", "method/assign_variable.lox",
+		"limit/loop_too_large.lox", "limit/no_reuse_constants.lox",
+		"limit/too_many_constants.lox", "generator", "spawn"},
+}
+
+// Test holds the expectations parsed from a single script.
+type Test struct {
+	Path string
+
+	Output       []Line
+	Errors       []Line
+	RuntimeError string
+	RuntimeLine  int
+	ExpectedExit int
+}
+
+// Line is an expected line of output and the source line that produced it.
+type Line struct {
+	Line int
+	Text string
+}
+
+// Parse reads the expectations from the script at path.
+func Parse(path string) (*Test, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Test{Path: path}
+	lines := strings.Split(string(data), "\n")
+
+	for i, line := range lines {
+		lineNum := i + 1
+
+		// A statement that has been commented out keeps the expectation
+		// written after it, which no longer applies
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "//") &&
+			strings.Contains(trimmed[2:], "//") {
+			continue
+		}
+
+		if m := expectedOutputPattern.FindStringSubmatch(line); m != nil {
+			t.Output = append(t.Output, Line{lineNum, m[1]})
+			continue
+		}
+
+		if m := errorLinePattern.FindStringSubmatch(line); m != nil {
+			if m[2] != "c" {
+				n, _ := strconv.Atoi(m[3])
+				t.Errors = append(t.Errors, Line{n, m[4]})
+				t.ExpectedExit = 65
+			}
+			continue
+		}
+
+		if m := expectedErrorPattern.FindStringSubmatch(line); m != nil {
+			t.Errors = append(t.Errors, Line{lineNum, m[1]})
+			t.ExpectedExit = 65
+			continue
+		}
+
+		if m := expectedRuntimePattern.FindStringSubmatch(line); m != nil {
+			t.RuntimeError = m[1]
+			t.RuntimeLine = lineNum
+			t.ExpectedExit = 70
+		}
+	}
+
+	if len(t.Errors) > 0 && t.RuntimeError != "" {
+		return nil, fmt.Errorf("%s: cannot expect both compile and runtime "+
+			"errors", path)
+	}
+
+	return t, nil
+}
+
+// DefaultTimeout is the time a script is allowed to run, the same as the
+// suite harness allows by default.
+const DefaultTimeout = 10 * time.Second
+
+// Run executes the script in process and returns a description of every way
+// its behavior differed from the expectations. A script still running once
+// timeout has passed is interrupted and fails, unless timeout isn't positive.
+func (t *Test) Run(engine lox.Engine, timeout time.Duration) (failures []string) {
+	source, err := os.ReadFile(t.Path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var stdout, stderr bytes.Buffer
+	l := lox.New()
+	l.Engine = engine
+	l.SetOutput(&stdout)
+	l.SetErrorOutput(&stderr)
+
+	if timeout > 0 {
+		var target interface{ Interrupt(err error) } = l.Interpreter
+		if engine == lox.ENGINE_VM {
+			target = l.VM
+		}
+
+		fired := make(chan struct{})
+		timer := time.AfterFunc(timeout, func() {
+			defer close(fired)
+			target.Interrupt(fmt.Errorf("timed out after %s", timeout))
+		})
+
+		// What a script printed before it was interrupted isn't compared
+		defer func() {
+			if !timer.Stop() {
+				<-fired
+				failures = []string{fmt.Sprintf("interrupted after running "+
+					"for %s", timeout)}
+			}
+		}()
+	}
+
+	exit := func() (exit int) {
+		defer func() {
+			if r := recover(); r != nil {
+				failures = append(failures, fmt.Sprintf("panic: %v", r))
+				exit = -1
+			}
+		}()
+
+		l.Run(string(source))
+		if l.HadError {
+			return 65
+		} else if l.HadRuntimeError {
+			return 70
+		}
+		return 0
+	}()
+
+	failures = append(failures, t.checkErrors(splitLines(stderr.String()))...)
+	failures = append(failures, t.checkOutput(splitLines(stdout.String()))...)
+
+	if exit != t.ExpectedExit && exit >= 0 {
+		failures = append(failures, fmt.Sprintf("expected exit code %d and "+
+			"got %d", t.ExpectedExit, exit))
+	}
+
+	return failures
+}
+
+func (t *Test) checkErrors(lines []string) []string {
+	var failures []string
+
+	if t.RuntimeError != "" {
+		if len(lines) == 0 {
+			return []string{fmt.Sprintf("expected runtime error %q and got "+
+				"none", t.RuntimeError)}
+		}
+
+		m := runtimeErrorPattern.FindStringSubmatch(lines[0])
+		if m == nil {
+			return []string{fmt.Sprintf("expected runtime error %q and got:\n"+
+				"%s", t.RuntimeError, lines[0])}
+		}
+
+		if normalize(m[2]) != normalize(t.RuntimeError) {
+			failures = append(failures, fmt.Sprintf("expected runtime error "+
+				"%q and got %q", t.RuntimeError, m[2]))
+		}
+
+		if line, _ := strconv.Atoi(m[1]); line != t.RuntimeLine {
+			failures = append(failures, fmt.Sprintf("expected runtime error "+
+				"on line %d but was on line %d", t.RuntimeLine, line))
+		}
+
+		return failures
+	}
+
+	expected := make(map[string]bool)
+	for _, e := range t.Errors {
+		expected[fmt.Sprintf("[line %d] %s", e.Line, normalize(e.Text))] = true
+	}
+
+	// The golox parser does not synchronize until the next statement, so a
+	// syntax error is often followed by errors the reference implementations
+	// never report. Those are allowed once an expected error has been seen.
+	found := make(map[string]bool)
+	for _, line := range lines {
+		m := syntaxErrorPattern.FindStringSubmatch(line)
+		if m == nil {
+			failures = append(failures, "unexpected output on stderr:\n"+line)
+			continue
+		}
+
+		actual := fmt.Sprintf("[line %s] %s", m[1], normalize(m[2]))
+		if expected[actual] {
+			found[actual] = true
+		} else if len(found) == 0 {
+			failures = append(failures, "unexpected error:\n"+line)
+		}
+	}
+
+	var missing []string
+	for e := range expected {
+		if !found[e] {
+			missing = append(missing, "missing expected error: "+e)
+		}
+	}
+	sort.Strings(missing)
+
+	return append(failures, missing...)
+}
+
+func (t *Test) checkOutput(lines []string) []string {
+	var failures []string
+
+	for i, line := range lines {
+		if i >= len(t.Output) {
+			failures = append(failures, fmt.Sprintf("got output %q when none "+
+				"was expected", line))
+			continue
+		}
+
+		expected := t.Output[i]
+		if expected.Text != line {
+			failures = append(failures, fmt.Sprintf("expected output %q on "+
+				"line %d and got %q", expected.Text, expected.Line, line))
+		}
+	}
+
+	for _, expected := range t.Output[min(len(lines), len(t.Output)):] {
+		failures = append(failures, fmt.Sprintf("missing expected output %q "+
+			"on line %d", expected.Text, expected.Line))
+	}
+
+	return failures
+}
+
+// normalize removes the differences in wording between golox and the
+// reference implementations: golox messages are lower case, use double
+// quotes, say "expected" rather than "expect" and have no trailing period.
+func normalize(message string) string {
+	message = strings.ToLower(message)
+	message = strings.ReplaceAll(message, "expected ", "expect ")
+	message = strings.ReplaceAll(message, "'", `"`)
+	return strings.TrimSuffix(message, ".")
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, "\n")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Discover returns every script under dir in lexical order, leaving out the
+// ones the engine skips.
+func Discover(dir string, engine lox.Engine) ([]string, error) {
+	var paths []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range skip[engine] {
+			if filepath.ToSlash(rel) != name {
+				continue
+			}
+
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() && filepath.Ext(path) == ".lox" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths, err
+}
+
+// Result is the outcome of running a single script.
+type Result struct {
+	Path     string
+	Failures []string
+	Elapsed  time.Duration
+}
+
+// RunSuite runs every script under dir, each for up to timeout, and returns
+// one result per script.
+func RunSuite(dir string, engine lox.Engine, timeout time.Duration) ([]Result, error) {
+	paths, err := Discover(dir, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(paths))
+	for _, path := range paths {
+		start := time.Now()
+
+		t, err := Parse(path)
+		if err != nil {
+			results = append(results, Result{path, []string{err.Error()}, 0})
+			continue
+		}
+
+		failures := t.Run(engine, timeout)
+		results = append(results, Result{path, failures, time.Since(start)})
+	}
+
+	return results, nil
+}
+
+// Report writes the failures in results to w followed by a summary line. It
+// returns the number of failed scripts.
+func Report(w io.Writer, results []Result) int {
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	failed := 0
+	for _, result := range results {
+		if len(result.Failures) == 0 {
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(b, "FAIL %s\n", result.Path)
+		for _, failure := range result.Failures {
+			fmt.Fprintf(b, "     %s\n", strings.ReplaceAll(failure, "\n",
+				"\n     "))
+		}
+	}
+
+	fmt.Fprintf(b, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed
+}
@@ -0,0 +1,95 @@
+package golden
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/lox"
+)
+
+// The suite shared with clox lives at the root of the repository.
+const suite = "../../../../test"
+
+func TestSuite(t *testing.T) {
+	engines := map[string]lox.Engine{
+		"tree": lox.ENGINE_TREE,
+		"vm":   lox.ENGINE_VM,
+	}
+
+	for name, engine := range engines {
+		engine := engine
+		t.Run(name, func(t *testing.T) {
+			paths, err := Discover(suite, engine)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range paths {
+				path := path
+				rel, _ := filepath.Rel(suite, path)
+
+				t.Run(filepath.ToSlash(rel), func(t *testing.T) {
+					test, err := Parse(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if failures := test.Run(engine, DefaultTimeout); len(failures) > 0 {
+						t.Error(strings.Join(failures, "\n"))
+					}
+				})
+			}
+		})
+	}
+}
+
+// Interpreters share no state, so the whole suite can run at once on both
+// engines. Run with -race to check that they don't.
+func TestSuiteConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping concurrent run of the suite in short mode")
+	}
+
+	type run struct {
+		path   string
+		engine lox.Engine
+	}
+
+	var runs []run
+	for _, engine := range []lox.Engine{lox.ENGINE_TREE, lox.ENGINE_VM} {
+		paths, err := Discover(suite, engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, path := range paths {
+			runs = append(runs, run{path, engine})
+		}
+	}
+
+	failures := make([][]string, len(runs))
+	var wg sync.WaitGroup
+	for n, r := range runs {
+		wg.Add(1)
+		go func(n int, r run) {
+			defer wg.Done()
+
+			test, err := Parse(r.path)
+			if err != nil {
+				failures[n] = []string{err.Error()}
+				return
+			}
+			failures[n] = test.Run(r.engine, DefaultTimeout)
+		}(n, r)
+	}
+	wg.Wait()
+
+	for n, r := range runs {
+		if len(failures[n]) > 0 {
+			t.Errorf("%s (engine %d): %s", r.path, r.engine,
+				strings.Join(failures[n], "\n"))
+		}
+	}
+}
@@ -0,0 +1,406 @@
+// Package lineedit reads lines from a terminal with Emacs style editing and
+// history, like a small readline. The supported keys are:
+//
+//	left, right, ctrl-b, ctrl-f   move by a character
+//	home, end, ctrl-a, ctrl-e     move to the start or end of the line
+//	up, down, ctrl-p, ctrl-n      recall earlier or later history entries
+//	backspace, delete, ctrl-d     delete the character before or under the cursor
+//	ctrl-k, ctrl-u, ctrl-w        delete to the end, to the start, or a word
+//	ctrl-l                        clear the screen
+//	tab                           complete the word before the cursor
+//	ctrl-c                        abandon the line
+//	ctrl-d on an empty line       end of input
+//
+// When input is not a terminal, such as a pipe, lines are read as they are
+// without editing or echo.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupted is returned by ReadLine when the user presses ctrl-c.
+var ErrInterrupted = errors.New("interrupted")
+
+// maxHistory is the number of entries kept in the history file.
+const maxHistory = 1000
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// Editor reads lines from its input, editing them in place when the input is
+// a terminal.
+type Editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       uintptr
+	terminal bool
+
+	history []string
+
+	// Complete, if set, returns the candidates for completing line at the
+	// cursor, a rune index. Each candidate replaces the runes from start to
+	// the cursor. Without it tab inserts a space.
+	Complete func(line string, cursor int) (start int, candidates []string)
+
+	// Highlight, if set, returns line as it is shown, such as with ANSI
+	// colors added. It must not change the width of the line.
+	Highlight func(line string) string
+}
+
+// New returns an Editor reading from in and echoing to out.
+func New(in io.Reader, out io.Writer) *Editor {
+	e := &Editor{in: bufio.NewReader(in), out: out}
+
+	if f, ok := in.(*os.File); ok && isTerminal(f.Fd()) {
+		e.fd = f.Fd()
+		e.terminal = true
+	}
+
+	return e
+}
+
+// IsTerminal reports whether lines are read from a terminal.
+func (e *Editor) IsTerminal() bool {
+	return e.terminal
+}
+
+// History returns the entries added so far, oldest first.
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// AddHistory appends an entry to the history, unless it is empty or repeats
+// the last entry.
+func (e *Editor) AddHistory(entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return
+	}
+
+	if n := len(e.history); n > 0 && e.history[n-1] == entry {
+		return
+	}
+
+	e.history = append(e.history, entry)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// LoadHistory reads history entries from a file, one per line. A missing
+// file is not an error.
+func (e *Editor) LoadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		e.AddHistory(line)
+	}
+
+	return nil
+}
+
+// SaveHistory writes the history to a file, one entry per line.
+func (e *Editor) SaveHistory(path string) error {
+	var sb strings.Builder
+	for _, entry := range e.history {
+		sb.WriteString(entry + "\n")
+	}
+
+	return os.WriteFile(path, []byte(sb.String()), 0600)
+}
+
+// ReadLine prints prompt and returns the line entered, without its newline.
+// It returns io.EOF at the end of input and ErrInterrupted if the line was
+// abandoned with ctrl-c.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+
+	if !e.terminal {
+		return e.readPlain()
+	}
+
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return e.readPlain()
+	}
+	defer restore(e.fd, state)
+
+	l := &line{editor: e, prompt: prompt, historyIndex: len(e.history)}
+	return l.edit()
+}
+
+func (e *Editor) readPlain() (string, error) {
+	text, err := e.in.ReadString('\n')
+	if err == io.EOF && text != "" {
+		// The last line need not end with a newline
+		return text, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r"), nil
+}
+
+// line is a line being edited.
+type line struct {
+	editor *Editor
+	prompt string
+	buffer []rune
+	cursor int
+
+	// historyIndex is the history entry shown, len(history) for the line
+	// being written, which is kept in draft while browsing.
+	historyIndex int
+	draft        []rune
+}
+
+func (l *line) edit() (string, error) {
+	for {
+		r, _, err := l.editor.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			l.cursor = len(l.buffer)
+			l.refresh()
+			fmt.Fprint(l.editor.out, "\n")
+			return string(l.buffer), nil
+		case keyCtrlC:
+			fmt.Fprint(l.editor.out, "^C\n")
+			return "", ErrInterrupted
+		case keyCtrlD:
+			if len(l.buffer) == 0 {
+				fmt.Fprint(l.editor.out, "\n")
+				return "", io.EOF
+			}
+			l.deleteAt(l.cursor)
+		case keyCtrlA:
+			l.cursor = 0
+		case keyCtrlE:
+			l.cursor = len(l.buffer)
+		case keyCtrlB:
+			l.move(-1)
+		case keyCtrlF:
+			l.move(1)
+		case keyCtrlP:
+			l.recall(-1)
+		case keyCtrlN:
+			l.recall(1)
+		case keyBackspace, keyDelete:
+			if l.cursor > 0 {
+				l.cursor--
+				l.deleteAt(l.cursor)
+			}
+		case keyCtrlK:
+			l.buffer = l.buffer[:l.cursor]
+		case keyCtrlU:
+			l.buffer = append([]rune(nil), l.buffer[l.cursor:]...)
+			l.cursor = 0
+		case keyCtrlW:
+			l.deleteWord()
+		case keyCtrlL:
+			fmt.Fprint(l.editor.out, "\x1b[H\x1b[2J")
+		case keyEscape:
+			l.escape()
+		case keyTab:
+			l.complete()
+		default:
+			if unicode.IsPrint(r) {
+				l.insert(r)
+			}
+		}
+
+		l.refresh()
+	}
+}
+
+// escape handles the escape sequences sent by arrow and editing keys.
+func (l *line) escape() {
+	in := l.editor.in
+
+	r, _, err := in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+
+	r, _, err = in.ReadRune()
+	if err != nil {
+		return
+	}
+
+	switch r {
+	case 'A':
+		l.recall(-1)
+	case 'B':
+		l.recall(1)
+	case 'C':
+		l.move(1)
+	case 'D':
+		l.move(-1)
+	case 'H':
+		l.cursor = 0
+	case 'F':
+		l.cursor = len(l.buffer)
+	case '1', '3', '4', '7', '8':
+		// Sequences such as ESC [ 3 ~ for delete
+		if next, _, err := in.ReadRune(); err != nil || next != '~' {
+			return
+		}
+
+		switch r {
+		case '1', '7':
+			l.cursor = 0
+		case '4', '8':
+			l.cursor = len(l.buffer)
+		case '3':
+			l.deleteAt(l.cursor)
+		}
+	}
+}
+
+func (l *line) insert(r rune) {
+	l.buffer = append(l.buffer, 0)
+	copy(l.buffer[l.cursor+1:], l.buffer[l.cursor:])
+	l.buffer[l.cursor] = r
+	l.cursor++
+}
+
+func (l *line) deleteAt(i int) {
+	if i < len(l.buffer) {
+		l.buffer = append(l.buffer[:i], l.buffer[i+1:]...)
+	}
+}
+
+func (l *line) deleteWord() {
+	start := l.cursor
+	for start > 0 && unicode.IsSpace(l.buffer[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(l.buffer[start-1]) {
+		start--
+	}
+
+	l.buffer = append(l.buffer[:start], l.buffer[l.cursor:]...)
+	l.cursor = start
+}
+
+func (l *line) move(n int) {
+	l.cursor += n
+	if l.cursor < 0 {
+		l.cursor = 0
+	} else if l.cursor > len(l.buffer) {
+		l.cursor = len(l.buffer)
+	}
+}
+
+// recall replaces the line with an earlier (-1) or later (1) history entry.
+func (l *line) recall(direction int) {
+	history := l.editor.history
+
+	index := l.historyIndex + direction
+	if index < 0 || index > len(history) {
+		return
+	}
+
+	if l.historyIndex == len(history) {
+		l.draft = l.buffer
+	}
+	l.historyIndex = index
+
+	if index == len(history) {
+		l.buffer = l.draft
+	} else {
+		l.buffer = []rune(history[index])
+	}
+	l.cursor = len(l.buffer)
+}
+
+// complete extends the word before the cursor as far as the candidates
+// agree, listing them if that doesn't get any further.
+func (l *line) complete() {
+	if l.editor.Complete == nil {
+		l.insert(' ')
+		return
+	}
+
+	start, candidates := l.editor.Complete(string(l.buffer), l.cursor)
+	if len(candidates) == 0 || start < 0 || start > l.cursor {
+		return
+	}
+
+	word := string(l.buffer[start:l.cursor])
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 || len(prefix) > len(word) {
+		if len(candidates) == 1 {
+			prefix = candidates[0]
+		}
+
+		rest := append([]rune(prefix), l.buffer[l.cursor:]...)
+		l.buffer = append(l.buffer[:start:start], rest...)
+		l.cursor = start + len([]rune(prefix))
+		return
+	}
+
+	fmt.Fprintf(l.editor.out, "\n%s\n", strings.Join(candidates, "  "))
+}
+
+// commonPrefix returns the longest prefix shared by every string.
+func commonPrefix(strs []string) string {
+	prefix := []rune(strs[0])
+	for _, s := range strs[1:] {
+		r := []rune(s)
+
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+
+	return string(prefix)
+}
+
+// refresh redraws the line and places the cursor.
+func (l *line) refresh() {
+	text := string(l.buffer)
+	if l.editor.Highlight != nil {
+		text = l.editor.Highlight(text)
+	}
+
+	fmt.Fprintf(l.editor.out, "\r%s%s\x1b[K\r", l.prompt, text)
+
+	if column := len([]rune(l.prompt)) + l.cursor; column > 0 {
+		fmt.Fprintf(l.editor.out, "\x1b[%dC", column)
+	}
+}
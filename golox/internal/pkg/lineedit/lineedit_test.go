@@ -0,0 +1,137 @@
+package lineedit
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// edit runs the line editor over keys as if they were typed on a terminal.
+func edit(e *Editor, keys string) (string, error) {
+	e.in = bufio.NewReader(strings.NewReader(keys))
+	l := &line{editor: e, historyIndex: len(e.history)}
+	return l.edit()
+}
+
+func TestEdit(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"print 1;\r", "print 1;"},
+		{"prnt\x1b[D\x1b[Di\r", "print"},
+		{"abc\x7f\x7fd\r", "ad"},
+		{"world\x01hello \r", "hello world"},
+		{"one two three\x17\x17four\r", "one four"},
+		{"abcdef\x02\x02\x0b\r", "abcd"},
+		{"abcdef\x02\x02\x15\r", "ef"},
+		{"abc\x01\x1b[3~\r", "bc"},
+		{"ab\x1b[H\x04x\r", "xb"},
+	}
+
+	for _, test := range tests {
+		got, err := edit(New(strings.NewReader(""), io.Discard), test.keys)
+		if err != nil || got != test.want {
+			t.Errorf("keys %q: got %q, %v, want %q", test.keys, got, err, test.want)
+		}
+	}
+}
+
+func TestEditEnds(t *testing.T) {
+	e := New(strings.NewReader(""), io.Discard)
+
+	if _, err := edit(e, "\x04"); err != io.EOF {
+		t.Errorf("ctrl-d on an empty line: got %v, want EOF", err)
+	}
+
+	if _, err := edit(e, "abc\x03"); err != ErrInterrupted {
+		t.Errorf("ctrl-c: got %v, want ErrInterrupted", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	e := New(strings.NewReader(""), io.Discard)
+	e.AddHistory("first")
+	e.AddHistory("second")
+	e.AddHistory("second")
+	e.AddHistory("  ")
+
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"\x1b[A\r", "second"},
+		{"\x1b[A\x1b[A\x1b[A\r", "first"},
+		{"draft\x1b[A\x1b[B\r", "draft"},
+		{"\x10\x10\x0e\r", "second"},
+	}
+
+	for _, test := range tests {
+		if got, _ := edit(e, test.keys); got != test.want {
+			t.Errorf("keys %q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "history")
+	if err := e.SaveHistory(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(strings.NewReader(""), io.Discard)
+	if err := loaded.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"first", "second"}; !reflect.DeepEqual(loaded.History(), want) {
+		t.Errorf("loaded history %q, want %q", loaded.History(), want)
+	}
+}
+
+func TestReadLinePlain(t *testing.T) {
+	e := New(strings.NewReader("one\r\ntwo"), io.Discard)
+
+	for _, want := range []string{"one", "two"} {
+		if got, err := e.ReadLine("> "); err != nil || got != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	}
+
+	if _, err := e.ReadLine("> "); err != io.EOF {
+		t.Errorf("got %v at the end of input, want EOF", err)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	e := New(strings.NewReader(""), io.Discard)
+	e.Complete = func(line string, cursor int) (int, []string) {
+		start := strings.LastIndex(line[:cursor], " ") + 1
+
+		var candidates []string
+		for _, word := range []string{"print", "private", "return"} {
+			if strings.HasPrefix(word, line[start:cursor]) {
+				candidates = append(candidates, word)
+			}
+		}
+		return start, candidates
+	}
+
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"re\t x\r", "return x"},
+		{"p\t\r", "pri"},
+		{"pri\tn\t\r", "print"},
+		{"x\t\r", "x"},
+		{"r;\x02\t\r", "return;"},
+	}
+
+	for _, test := range tests {
+		if got, _ := edit(e, test.keys); got != test.want {
+			t.Errorf("keys %q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+}
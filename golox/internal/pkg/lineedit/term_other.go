@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package lineedit
+
+import "errors"
+
+// Line editing needs termios, so other systems read input a line at a time.
+type terminalState struct{}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*terminalState, error) {
+	return nil, errors.New("line editing is not supported on this system")
+}
+
+func restore(fd uintptr, state *terminalState) error {
+	return nil
+}
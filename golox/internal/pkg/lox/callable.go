@@ -1,7 +1,11 @@
 package lox
 
 import (
+	"fmt"
 	"time"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/errors"
 )
 
 type Callable interface {
@@ -27,3 +31,106 @@ func (n nativeFunctionClock) Call(interpreter *Interpreter, arguments []interfac
 func (n nativeFunctionClock) String() string {
 	return "<native fn>"
 }
+
+// nativeFunctionAssert fails with msg unless cond is truthy.
+type nativeFunctionAssert struct{}
+
+func (n nativeFunctionAssert) Arity() int {
+	return 2
+}
+
+func (n nativeFunctionAssert) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	if common.IsTruthy(arguments[0]) {
+		return nil, nil
+	}
+
+	return nil, errors.RuntimeError.New(nil, "assertion failed: "+
+		common.Stringfy(arguments[1]))
+}
+
+func (n nativeFunctionAssert) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionAssertEqual fails unless its actual and expected arguments
+// are equal.
+type nativeFunctionAssertEqual struct{}
+
+func (n nativeFunctionAssertEqual) Arity() int {
+	return 2
+}
+
+func (n nativeFunctionAssertEqual) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	actual, expected := arguments[0], arguments[1]
+	if common.IsEqual(actual, expected) {
+		return nil, nil
+	}
+
+	return nil, errors.RuntimeError.New(nil, fmt.Sprintf("expected %s but got %s",
+		common.Stringfy(expected), common.Stringfy(actual)))
+}
+
+func (n nativeFunctionAssertEqual) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionArgs returns the arguments the script was run with as a list.
+type nativeFunctionArgs struct{}
+
+func (n nativeFunctionArgs) Arity() int {
+	return 0
+}
+
+func (n nativeFunctionArgs) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	return common.NewStringList(interpreter.runtime.Args), nil
+}
+
+func (n nativeFunctionArgs) String() string {
+	return "<native fn>"
+}
+
+// nativeMethod calls a method of a value implemented in Go, such as a list.
+type nativeMethod struct {
+	method *common.NativeMethod
+}
+
+func (n nativeMethod) Arity() int {
+	return n.method.Arity
+}
+
+func (n nativeMethod) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	value, err := n.method.Call(arguments)
+	if _, isRuntime := err.(*errors.CustomErr); isRuntime || err == errStopped {
+		// Such as an error raised by the body of a generator
+		return nil, err
+	} else if err != nil {
+		return nil, errors.RuntimeError.New(nil, err.Error())
+	}
+
+	return value, nil
+}
+
+func (n nativeMethod) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionRange returns the range of numbers up to an end, from a start
+// to an end, or from a start to an end by a step.
+type nativeFunctionRange struct{}
+
+func (n nativeFunctionRange) Arity() int {
+	return variadic
+}
+
+func (n nativeFunctionRange) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	r, err := common.NewRange(arguments)
+	if err != nil {
+		return nil, errors.RuntimeError.New(nil, err.Error())
+	}
+
+	return r, nil
+}
+
+func (n nativeFunctionRange) String() string {
+	return "<native fn>"
+}
@@ -4,32 +4,45 @@ type Class struct {
 	runtime    *Lox
 	Name       string
 	superclass *Class
+	line       int // where the class is declared
 
 	// Class is responsible for storing behavior.
 	Methods map[string]*Function
+
+	// methodTable flattens Methods together with every method inherited from
+	// the superclass chain. Classes can't change once they are declared, so
+	// the table is built once and a lookup never has to walk the chain.
+	methodTable map[string]*Function
+	initializer *Function
 }
 
 func NewClass(l *Lox, name string, superclass *Class, methods map[string]*Function) *Class {
-	return &Class{
-		runtime:    l,
-		superclass: superclass,
-		Name:       name,
-		Methods:    methods,
+	c := &Class{
+		runtime:     l,
+		superclass:  superclass,
+		Name:        name,
+		Methods:     methods,
+		methodTable: make(map[string]*Function),
 	}
-}
 
-func (c *Class) FindMethod(name string) *Function {
-	if f, ok := c.Methods[name]; ok {
-		return f
+	// Copy inherited methods down first so the class's own methods override
+	// them.
+	if superclass != nil {
+		for name, method := range superclass.methodTable {
+			c.methodTable[name] = method
+		}
 	}
 
-	// If we don't find the method for the specific instance's class, we will
-	// recurse up through the superclass chain and check therea.
-	if c.superclass != nil {
-		return c.superclass.FindMethod(name)
+	for name, method := range methods {
+		c.methodTable[name] = method
 	}
 
-	return nil
+	c.initializer = c.methodTable["init"]
+	return c
+}
+
+func (c *Class) FindMethod(name string) *Function {
+	return c.methodTable[name]
 }
 
 func (c *Class) String() string {
@@ -37,23 +50,37 @@ func (c *Class) String() string {
 }
 
 func (c *Class) Call(i *Interpreter, arguments []interface{}) (interface{}, error) {
+	if t, ok := i.hooks.(tool); ok {
+		frame := &callFrame{
+			key:       c,
+			name:      c.Name,
+			class:     c.Name,
+			line:      c.line,
+			arguments: arguments,
+		}
+
+		t.enter(frame)
+		defer t.leave(frame)
+	}
+
 	instance := NewInstance(c.runtime, c)
 
-	initializer := c.FindMethod("init")
-	if initializer != nil {
-		initializer.Bind(instance).Call(i, arguments)
+	if c.initializer != nil {
+		_, err := c.initializer.callMethod(i, instance, arguments)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return instance, nil
 }
 
 func (c *Class) Arity() int {
-	initializer := c.FindMethod("init")
-	if initializer == nil {
+	if c.initializer == nil {
 		return 0
 	}
 
-	return initializer.Arity()
+	return c.initializer.Arity()
 }
 
 func IsClass(object interface{}) bool {
@@ -0,0 +1,151 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		source []string
+		out    string
+	}{
+		{
+			name: "overridden in a subclass",
+			source: []string{
+				`class A { m() { return "A"; } n() { return "A.n"; } }`,
+				`class B < A { m() { return "B"; } }`,
+				`class C < B {}`,
+				`print C().m();`,
+				`print C().n();`,
+				`print A().m();`,
+			},
+			out: "B\nA.n\nA\n",
+		},
+		{
+			name: "superclass redeclared after the subclass",
+			source: []string{
+				`class A { m() { return "old"; } }`,
+				`class B < A {}`,
+				`class A { m() { return "new"; } }`,
+				`print B().m();`,
+				`print A().m();`,
+				`class C < A {}`,
+				`print C().m();`,
+			},
+			out: "old\nnew\nnew\n",
+		},
+		{
+			name: "field shadows a method",
+			source: []string{
+				`class A { m() { return "method"; } }`,
+				`class B < A {}`,
+				`var b = B();`,
+				`fun f() { return "field"; }`,
+				`b.m = f;`,
+				`print b.m();`,
+				`print B().m();`,
+			},
+			out: "field\nmethod\n",
+		},
+		{
+			name: "super",
+			source: []string{
+				`class A { m() { return "A"; } }`,
+				`class B < A { m() { return "B>" + super.m(); } }`,
+				`class C < B { m() { return "C>" + super.m(); } }`,
+				`class D < C {}`,
+				`print D().m();`,
+				`var m = D().m;`,
+				`print m();`,
+			},
+			out: "C>B>A\nC>B>A\n",
+		},
+		{
+			name: "super in an inherited initializer",
+			source: []string{
+				`class A { init(n) { this.n = n; } }`,
+				`class B < A { init(n) { super.init(n * 2); } }`,
+				`class C < B {}`,
+				`print C(2).n;`,
+			},
+			out: "4\n",
+		},
+		{
+			name: "bound method stays bound",
+			source: []string{
+				`class A { init(n) { this.n = n; } get() { return this.n; } }`,
+				`var a = A(1);`,
+				`var b = A(2);`,
+				`var get = a.get;`,
+				`print b.get();`,
+				`print get();`,
+				`a.n = 3;`,
+				`print get();`,
+				`b.get = get;`,
+				`print b.get();`,
+			},
+			out: "2\n1\n3\n3\n",
+		},
+		{
+			name: "closures capture this",
+			source: []string{
+				`class A {`,
+				`  init(n) { this.n = n; }`,
+				`  getter() { fun get() { return this.n; } return get; }`,
+				`}`,
+				`var a = A("a").getter();`,
+				`var b = A("b").getter();`,
+				`print a() + b();`,
+			},
+			out: "ab\n",
+		},
+	}
+
+	for _, test := range tests {
+		l := New()
+
+		var out, errOut strings.Builder
+		l.SetOutput(&out)
+		l.SetErrorOutput(&errOut)
+		l.Run(strings.Join(test.source, "\n"))
+
+		if out.String() != test.out || errOut.Len() > 0 {
+			t.Errorf("%s: got output %q and errors %q, want %q", test.name,
+				out.String(), errOut.String(), test.out)
+		}
+	}
+}
+
+// benchmarkMethods times b.N calls of a method defined depth classes up the
+// superclass chain of the receiver.
+func benchmarkMethods(b *testing.B, depth int) {
+	var sb strings.Builder
+	sb.WriteString("class C0 { init() { this.n = 0; } m() { this.n = this.n + 1; } }\n")
+	for i := 1; i < depth; i++ {
+		fmt.Fprintf(&sb, "class C%d < C%d {}\n", i, i-1)
+	}
+	fmt.Fprintf(&sb, "var c = C%d();\n", depth-1)
+	sb.WriteString("fun run(n) { for (var i = 0; i < n; i = i + 1) c.m(); }\n")
+
+	l := New()
+	l.SetOutput(io.Discard)
+	l.Run(sb.String())
+
+	b.ResetTimer()
+	l.Run(fmt.Sprintf("run(%d);", b.N))
+	if l.HadError || l.HadRuntimeError {
+		b.Fatal("benchmark failed")
+	}
+}
+
+func BenchmarkMethodCall(b *testing.B) {
+	benchmarkMethods(b, 1)
+}
+
+func BenchmarkInheritedMethodCall(b *testing.B) {
+	benchmarkMethods(b, 8)
+}
@@ -0,0 +1,289 @@
+package lox
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/vm"
+)
+
+// evaluatedName is the global that holds the value of an expression
+// evaluated by the REPL. Lox identifiers can't contain parentheses, so it
+// never clashes with a variable of the session.
+const evaluatedName = "(value)"
+
+// session is the state of a REPL session.
+type session struct {
+	l *Lox
+
+	// inputs holds the entries that ran without errors, for :save
+	inputs []string
+
+	// served is set for the sessions of a server, and shared for those
+	// sharing its interpreter
+	served bool
+	shared bool
+}
+
+// replCommand is a REPL command such as ":help".
+type replCommand struct {
+	name string
+	args string // shown by :help, required unless in brackets
+	help string
+	run  func(s *session, arg string)
+}
+
+var replCommands []replCommand
+
+// The commands are set up by init since :help refers to the list.
+func init() {
+	replCommands = []replCommand{
+		{":help", "", "show this help", (*session).help},
+		{":env", "", "list the global variables and their values", (*session).env},
+		{":type", "<expr>", "show the type of the value of an expression", (*session).typeOf},
+		{":ast", "<source>", "show the syntax tree of source", (*session).syntaxTree},
+		{":tokens", "<source>", "show the tokens of source", (*session).tokens},
+		{":load", "<file>", "run a script in this session", (*session).load},
+		{":reset", "", "start again with a new interpreter", (*session).reset},
+		{":save", "<file>", "write the entries that ran without errors to a file", (*session).save},
+		{":time", "<source>", "run source and show how long it took", (*session).timed},
+		{":debug", "[categories]", "toggle debug output: " + strings.Join(common.DebugCategories, ", "), (*session).debug},
+	}
+}
+
+// command runs a line starting with a colon.
+func (s *session) command(line string) {
+	defer func() {
+		s.l.HadError = false
+		s.l.HadRuntimeError = false
+	}()
+
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	for _, c := range replCommands {
+		if c.name != name {
+			continue
+		}
+
+		if reason := s.unavailable(c); reason != "" {
+			s.fail("%s is not available %s", c.name, reason)
+			return
+		}
+
+		if arg == "" && strings.HasPrefix(c.args, "<") {
+			s.fail("usage: %s %s", c.name, c.args)
+			return
+		}
+
+		c.run(s, arg)
+		return
+	}
+
+	s.fail("unknown command %q, see :help", name)
+}
+
+// unavailable returns why the session can't run c, or "" if it can. A
+// server runs whatever its clients send, so they get no access to its files,
+// and sessions sharing an interpreter can't replace it for the others.
+func (s *session) unavailable(c replCommand) string {
+	switch {
+	case s.served && (c.name == ":load" || c.name == ":save"):
+		return "in served sessions"
+	case s.shared && c.name == ":reset":
+		return "in shared sessions"
+	}
+
+	return ""
+}
+
+// fail reports a command that could not be carried out.
+func (s *session) fail(format string, args ...interface{}) {
+	s.l.Flush()
+	fmt.Fprintf(s.l.errOut, format+"\n", args...)
+}
+
+func (s *session) help(string) {
+	t := tabwriter.NewWriter(s.l.out, 0, 0, 2, ' ', 0)
+	for _, c := range replCommands {
+		if s.unavailable(c) == "" {
+			fmt.Fprintf(t, "%s %s\t%s\n", c.name, c.args, c.help)
+		}
+	}
+	fmt.Fprintf(t, "exit, ^D\tend the session\n")
+	t.Flush()
+}
+
+func (s *session) env(string) {
+	globals := s.l.globals()
+
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(s.l.out, "%s = %s\n", name, summarize(globals[name]))
+	}
+}
+
+func (s *session) typeOf(source string) {
+	if value, ok := s.evaluate(source); ok {
+		fmt.Fprintln(s.l.out, typeName(value))
+	}
+}
+
+func (s *session) syntaxTree(source string) {
+	tokens := NewScanner(s.l, terminate(source)).ScanTokens()
+	statements := NewParser(s.l, tokens).Parse()
+	if s.l.HadError {
+		return
+	}
+
+	fmt.Fprint(s.l.out, AstPrinter{}.Print(statements))
+}
+
+func (s *session) tokens(source string) {
+	for _, t := range NewScanner(s.l, source).ScanTokens() {
+		fmt.Fprintln(s.l.out, t)
+	}
+}
+
+func (s *session) load(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.fail("%s", err)
+		return
+	}
+
+	s.l.run(string(data))
+	if !s.l.HadError && !s.l.HadRuntimeError {
+		s.inputs = append(s.inputs, strings.TrimRight(string(data), "\n"))
+	}
+}
+
+// reset discards every variable, function and class defined so far. Hooks
+// stay installed.
+func (s *session) reset(string) {
+	hooks := s.l.Interpreter.hooks
+
+	s.l.Interpreter = NewInterpreter(s.l)
+	s.l.Interpreter.hooks = hooks
+	s.l.VM = s.l.newVM()
+	s.inputs = nil
+}
+
+func (s *session) save(path string) {
+	var sb strings.Builder
+	for _, input := range s.inputs {
+		sb.WriteString(input + "\n")
+	}
+
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		s.fail("%s", err)
+	}
+}
+
+func (s *session) timed(source string) {
+	start := time.Now()
+	ok := s.l.runEntry(source)
+	elapsed := time.Since(start)
+
+	if ok {
+		s.inputs = append(s.inputs, terminate(source))
+	}
+
+	s.l.Flush()
+	fmt.Fprintf(s.l.out, "took %s\n", elapsed.Round(time.Microsecond))
+}
+
+func (s *session) debug(categories string) {
+	if categories != "" {
+		if err := s.l.Debug.Toggle(categories); err != nil {
+			s.fail("%s", err)
+			return
+		}
+	}
+
+	fmt.Fprintf(s.l.out, "debug: %s\n", s.l.Debug)
+}
+
+// evaluate returns the value of an expression.
+func (s *session) evaluate(source string) (interface{}, bool) {
+	l := s.l
+
+	statements := l.parse(terminate(source))
+	if l.HadError {
+		return nil, false
+	}
+
+	var stmt *ast.Expression
+	if len(statements) == 1 {
+		stmt, _ = statements[0].(*ast.Expression)
+	}
+	if stmt == nil {
+		s.fail("expected an expression")
+		return nil, false
+	}
+
+	value := l.executeForValue(nil, stmt.Expression)
+	return value, !l.HadRuntimeError
+}
+
+// globals returns the global variables of the selected engine.
+func (l *Lox) globals() map[string]interface{} {
+	if l.Engine == ENGINE_VM {
+		return l.VM.Globals()
+	}
+
+	return l.Interpreter.globals.Values
+}
+
+// typeName describes the type of a value of either engine.
+func typeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Class, *vm.Class:
+		return "class"
+	case *Instance:
+		return "instance of " + v.Klass.Name
+	case *vm.Instance:
+		return "instance of " + v.Klass.Name
+	case *common.List:
+		return "list"
+	case *common.Range:
+		return "range"
+	case *common.Iterator:
+		return "iterator"
+	case *Generator:
+		return "generator"
+	case *Channel:
+		return "channel"
+	case *WaitGroup:
+		return "wait group"
+	case *Mutex:
+		return "mutex"
+	case *Function, *vm.Closure, *vm.Function, *vm.BoundMethod:
+		return "function"
+	case Callable, *vm.Native:
+		return "native function"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
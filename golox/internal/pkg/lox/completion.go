@@ -0,0 +1,112 @@
+package lox
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/token"
+	"github.com/mz1290/golox/internal/pkg/vm"
+)
+
+// complete returns the completions of the word before the cursor in a REPL
+// line: keywords and globals, or after "obj." the fields and methods of the
+// instance obj names. The object is found by looking up a global and its
+// fields, as in "a.b.", so completion never runs any code.
+func (l *Lox) complete(line string, cursor int) (int, []string) {
+	runes := []rune(line)[:cursor]
+
+	start := cursor
+	for start > 0 && isNameRune(runes[start-1]) {
+		start--
+	}
+	word := string(runes[start:cursor])
+
+	var names []string
+	if start > 0 && runes[start-1] == '.' {
+		path := start - 1
+		for path > 0 && (isNameRune(runes[path-1]) || runes[path-1] == '.') {
+			path--
+		}
+
+		names = l.members(string(runes[path : start-1]))
+	} else {
+		for keyword := range token.Keywords {
+			names = append(names, keyword)
+		}
+
+		for name := range l.globals() {
+			names = append(names, name)
+		}
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+
+	return start, candidates
+}
+
+// members returns the names of the fields and methods of the instance a
+// dotted path of a global and its fields leads to.
+func (l *Lox) members(path string) []string {
+	parts := strings.Split(path, ".")
+
+	value, ok := l.globals()[parts[0]]
+	if !ok {
+		return nil
+	}
+
+	for _, field := range parts[1:] {
+		switch instance := value.(type) {
+		case *Instance:
+			value, ok = instance.Fields[field]
+		case *vm.Instance:
+			value, ok = instance.Fields[field]
+		default:
+			ok = false
+		}
+
+		if !ok {
+			return nil
+		}
+	}
+
+	var names []string
+	switch instance := value.(type) {
+	case *Instance:
+		for name := range instance.Fields {
+			names = append(names, name)
+		}
+
+		// Methods are inherited from every class up the chain
+		for c := instance.Klass; c != nil; c = c.superclass {
+			for name := range c.Methods {
+				if instance.Klass.FindMethod(name) != nil {
+					names = append(names, name)
+				}
+			}
+		}
+	case *vm.Instance:
+		for name := range instance.Fields {
+			names = append(names, name)
+		}
+
+		// Inherited methods are copied down into Methods
+		for name := range instance.Klass.Methods {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func isNameRune(r rune) bool {
+	return r < 0x80 && common.IsAlphaNumeric(byte(r))
+}
@@ -0,0 +1,600 @@
+package lox
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/errors"
+)
+
+// Goroutines started with "spawn" share the program's globals and whatever
+// their closures and arguments refer to. Their memory model is simple: they
+// take turns, so only one runs Lox code at a time and each statement runs
+// without interruption. A goroutine gives up its turn when it blocks on a
+// channel, wait group or mutex, and otherwise every yieldInterval statements,
+// after one of them or before checking the condition of a loop. A loop starts
+// its body on the turn it checked its condition on, so the g.next() of
+// while (g.hasNext()) g.next() gets the value g.hasNext() found. Code updating
+// shared state over several statements uses a Mutex.
+//
+// A program ends when its script does, stopping any goroutines still
+// running, as in Go. A runtime error in a goroutine is reported and ends the
+// program too. When every goroutine is blocked the last one to block fails
+// with a deadlock error.
+//
+// A served program is different: it keeps running while the entries of its
+// sessions take turns with its goroutines, so neither the end of its script
+// nor the end of an entry stops them. A runtime error only ends the goroutine
+// it happens in, and goroutines that are all blocked aren't deadlocked, since
+// a later entry may unblock them.
+
+// yieldInterval is the number of statements a goroutine runs before letting
+// the others take a turn.
+const yieldInterval = 1000
+
+// errStopped unwinds the goroutines still running when a program ends. It
+// is never reported.
+var errStopped = fmt.Errorf("program stopped")
+
+// scheduler lets the goroutines of a program take turns. Its fields, and the
+// channels, wait groups and mutexes of the program, are only used by the
+// goroutine holding mu.
+type scheduler struct {
+	mu sync.Mutex
+
+	spawned  int            // goroutines running besides the script
+	runnable int            // goroutines running or waiting for mu
+	parked   []*waiter      // blocked goroutines, in the order they blocked
+	stopped  bool           // the program has ended
+	failure  error          // the runtime error that ended it, if any
+	done     sync.WaitGroup // finishes when the spawned goroutines have
+	running  *Interpreter   // the goroutine holding mu
+
+	// serving is set for a served program, whose goroutines write to
+	// program unless they run an entry of a session
+	serving bool
+	program *outputs
+
+	// Generators suspended at a yield, and those abandoned since the last
+	// iteration of a loop, which are stopped at the next one or when the
+	// program ends. pending is set while abandoned isn't empty.
+	coroutines  map[*coroutine]bool
+	abandonedMu sync.Mutex
+	abandoned   []*coroutine
+	pending     int32
+}
+
+// A waiter is a goroutine blocked until another wakes it.
+type waiter struct {
+	wake  chan error
+	value interface{} // the value received, or to be sent
+	woken bool
+	owner *Interpreter // the goroutine blocked
+
+	// A select waits on several channels, and learns which one it received
+	// from
+	channels []*Channel
+	index    int
+}
+
+func newWaiter() *waiter {
+	return &waiter{wake: make(chan error, 1)}
+}
+
+// begin starts running a program's script on i on the calling goroutine.
+func (s *scheduler) begin(i *Interpreter) {
+	s.mu.Lock()
+	s.stopped = false
+	s.failure = nil
+	s.runnable = 1
+	s.running = i
+}
+
+// end stops the goroutines still running once the script has finished and
+// waits for them to unwind.
+func (s *scheduler) end() {
+	s.stop()
+	s.reclaim()
+	s.stopCoroutines()
+	s.mu.Unlock()
+	s.done.Wait()
+}
+
+// serve makes the program a served one, whose goroutines write to program.
+// Whatever ran before has ended.
+func (s *scheduler) serve(program *outputs) {
+	s.serving = true
+	s.program = program
+	s.stopped = false
+	s.failure = nil
+}
+
+// stop ends the program, waking the blocked goroutines so they unwind.
+func (s *scheduler) stop() {
+	s.stopped = true
+	for len(s.parked) > 0 {
+		s.wake(s.parked[0], errStopped)
+	}
+}
+
+// spawn runs call on a new goroutine, running on goroutine, once the caller
+// gives up its turn.
+func (s *scheduler) spawn(goroutine *Interpreter, call func() error) {
+	s.spawned++
+	s.runnable++
+	s.done.Add(1)
+
+	go func() {
+		defer s.done.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.resume(goroutine)
+
+		if !s.stopped {
+			if err := call(); err != nil && err != errStopped {
+				if s.serving {
+					goroutine.runtime.RuntimeError(err)
+				} else {
+					s.failure = err
+					s.stop()
+				}
+			}
+		}
+
+		s.spawned--
+		s.runnable--
+		s.deadlocked()
+		s.pause()
+	}()
+}
+
+// yield lets the other goroutines take a turn.
+func (s *scheduler) yield() {
+	running := s.pause()
+	s.mu.Unlock()
+	runtime.Gosched()
+	s.mu.Lock()
+	s.resume(running)
+}
+
+// concurrent reports whether other goroutines may be waiting for a turn.
+// The sessions of a served program can start entries at any time.
+func (s *scheduler) concurrent() bool {
+	return s.spawned > 0 || s.serving
+}
+
+// enter takes a turn to run an entry of a served program, or its script, on
+// i.
+func (s *scheduler) enter(i *Interpreter) {
+	s.mu.Lock()
+	s.runnable++
+	s.resume(i)
+}
+
+// leave gives up the turn taken by enter, leaving the goroutines of the
+// program running.
+func (s *scheduler) leave() {
+	s.runnable--
+	s.pause()
+	s.mu.Unlock()
+}
+
+// resume gives the turn to the goroutine running on i. The Lox of a served
+// program is switched to where that goroutine writes.
+func (s *scheduler) resume(i *Interpreter) {
+	s.running = i
+	if s.serving {
+		s.outputs(i).restore()
+	}
+}
+
+// pause records what the goroutine holding the turn leaves in the Lox of a
+// served program before it gives up the turn, and returns the goroutine.
+func (s *scheduler) pause() *Interpreter {
+	if s.serving {
+		s.outputs(s.running).save()
+	}
+
+	return s.running
+}
+
+// park blocks the calling goroutine until w is woken, letting the others
+// run meanwhile. It returns the error w is woken with.
+func (s *scheduler) park(w *waiter) error {
+	if s.stopped {
+		w.woken = true
+		return errStopped
+	}
+
+	w.owner = s.running
+	s.parked = append(s.parked, w)
+	s.runnable--
+	s.deadlocked()
+
+	running := s.pause()
+	s.mu.Unlock()
+	err := <-w.wake
+	s.mu.Lock()
+	s.resume(running)
+	return err
+}
+
+// wake unblocks w, which fails with err unless it is nil.
+func (s *scheduler) wake(w *waiter, err error) {
+	s.parked = remove(s.parked, w)
+	w.woken = true
+	s.runnable++
+	w.wake <- err
+}
+
+// deadlocked fails the goroutine that blocked last when none can run.
+func (s *scheduler) deadlocked() {
+	if s.runnable == 0 && len(s.parked) > 0 && !s.serving {
+		s.wake(s.parked[len(s.parked)-1],
+			fmt.Errorf("all goroutines are asleep - deadlock"))
+	}
+}
+
+// Channel passes values between goroutines, holding up to its capacity of
+// values that have been sent but not received.
+type Channel struct {
+	sched    *scheduler
+	capacity int
+	buffer   []interface{}
+	closed   bool
+
+	// Goroutines blocked sending and receiving. Those woken by an error are
+	// skipped.
+	senders   []*waiter
+	receivers []*waiter
+}
+
+func (c *Channel) String() string {
+	return "<channel>"
+}
+
+func (c *Channel) Method(name string) (*common.NativeMethod, bool) {
+	switch name {
+	case "send":
+		return &common.NativeMethod{Name: name, Arity: 1, Call: func(arguments []interface{}) (interface{}, error) {
+			return nil, c.send(arguments[0])
+		}}, true
+	case "receive":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return c.receive()
+		}}, true
+	case "close":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return nil, c.close()
+		}}, true
+	}
+
+	return nil, false
+}
+
+// send blocks until a goroutine receives value or there is room for it.
+func (c *Channel) send(value interface{}) error {
+	if c.closed {
+		return fmt.Errorf("send on closed channel")
+	}
+
+	if receiver := next(&c.receivers); receiver != nil {
+		c.deliver(receiver, value)
+		return nil
+	}
+
+	if len(c.buffer) < c.capacity {
+		c.buffer = append(c.buffer, value)
+		return nil
+	}
+
+	w := newWaiter()
+	w.value = value
+	c.senders = append(c.senders, w)
+	return c.sched.park(w)
+}
+
+// receive blocks until a value is sent, or returns nil once the channel is
+// closed and every value sent has been received.
+func (c *Channel) receive() (interface{}, error) {
+	if value, ok := c.tryReceive(); ok {
+		return value, nil
+	}
+
+	w := newWaiter()
+	c.receivers = append(c.receivers, w)
+	if err := c.sched.park(w); err != nil {
+		return nil, err
+	}
+
+	return w.value, nil
+}
+
+// tryReceive receives a value if one can be without blocking.
+func (c *Channel) tryReceive() (interface{}, bool) {
+	if len(c.buffer) > 0 {
+		value := c.buffer[0]
+		c.buffer = c.buffer[1:]
+
+		// Make room for the first blocked sender
+		if sender := next(&c.senders); sender != nil {
+			c.buffer = append(c.buffer, sender.value)
+			c.sched.wake(sender, nil)
+		}
+		return value, true
+	}
+
+	if sender := next(&c.senders); sender != nil {
+		c.sched.wake(sender, nil)
+		return sender.value, true
+	}
+
+	return nil, c.closed
+}
+
+func (c *Channel) close() error {
+	if c.closed {
+		return fmt.Errorf("close of closed channel")
+	}
+	c.closed = true
+
+	for receiver := next(&c.receivers); receiver != nil; receiver = next(&c.receivers) {
+		c.deliver(receiver, nil)
+	}
+
+	for sender := next(&c.senders); sender != nil; sender = next(&c.senders) {
+		c.sched.wake(sender, fmt.Errorf("send on closed channel"))
+	}
+
+	return nil
+}
+
+// deliver wakes a receiver with value, taking it off the queues of the other
+// channels it waits on in a select.
+func (c *Channel) deliver(receiver *waiter, value interface{}) {
+	receiver.value = value
+	for n, other := range receiver.channels {
+		if other == c {
+			receiver.index = n
+		} else {
+			other.receivers = remove(other.receivers, receiver)
+		}
+	}
+
+	c.sched.wake(receiver, nil)
+}
+
+// remove returns queue without w.
+func remove(queue []*waiter, w *waiter) []*waiter {
+	for n, queued := range queue {
+		if queued == w {
+			return append(queue[:n:n], queue[n+1:]...)
+		}
+	}
+
+	return queue
+}
+
+// next removes and returns the first waiter in queue that hasn't been woken.
+func next(queue *[]*waiter) *waiter {
+	for len(*queue) > 0 {
+		w := (*queue)[0]
+		*queue = (*queue)[1:]
+		if !w.woken {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// selectReceive receives from the first of channels with a value ready, or
+// blocks until one has, returning its index and the value.
+func selectReceive(sched *scheduler, channels []*Channel) (int, interface{}, error) {
+	for n, c := range channels {
+		if value, ok := c.tryReceive(); ok {
+			return n, value, nil
+		}
+	}
+
+	w := newWaiter()
+	w.channels = channels
+	for _, c := range channels {
+		c.receivers = append(c.receivers, w)
+	}
+
+	if err := sched.park(w); err != nil {
+		return 0, nil, err
+	}
+
+	return w.index, w.value, nil
+}
+
+// WaitGroup waits for a number of tasks to finish.
+type WaitGroup struct {
+	sched   *scheduler
+	count   int
+	waiters []*waiter
+}
+
+func (wg *WaitGroup) String() string {
+	return "<wait group>"
+}
+
+func (wg *WaitGroup) Method(name string) (*common.NativeMethod, bool) {
+	switch name {
+	case "add":
+		return &common.NativeMethod{Name: name, Arity: 1, Call: func(arguments []interface{}) (interface{}, error) {
+			n, ok := arguments[0].(float64)
+			if !ok || n != float64(int(n)) {
+				return nil, fmt.Errorf("wait group count must be an integer")
+			}
+			return nil, wg.add(int(n))
+		}}, true
+	case "done":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return nil, wg.add(-1)
+		}}, true
+	case "wait":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			if wg.count == 0 {
+				return nil, nil
+			}
+
+			w := newWaiter()
+			wg.waiters = append(wg.waiters, w)
+			return nil, wg.sched.park(w)
+		}}, true
+	}
+
+	return nil, false
+}
+
+func (wg *WaitGroup) add(n int) error {
+	if wg.count+n < 0 {
+		return fmt.Errorf("negative wait group count")
+	}
+
+	wg.count += n
+	if wg.count == 0 {
+		for w := next(&wg.waiters); w != nil; w = next(&wg.waiters) {
+			wg.sched.wake(w, nil)
+		}
+	}
+
+	return nil
+}
+
+// Mutex is a lock held by one goroutine at a time.
+type Mutex struct {
+	sched   *scheduler
+	locked  bool
+	waiters []*waiter
+}
+
+func (m *Mutex) String() string {
+	return "<mutex>"
+}
+
+func (m *Mutex) Method(name string) (*common.NativeMethod, bool) {
+	switch name {
+	case "lock":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			if !m.locked {
+				m.locked = true
+				return nil, nil
+			}
+
+			w := newWaiter()
+			m.waiters = append(m.waiters, w)
+			return nil, m.sched.park(w)
+		}}, true
+	case "unlock":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			if !m.locked {
+				return nil, fmt.Errorf("unlock of unlocked mutex")
+			}
+
+			// Hand the lock straight to the first goroutine waiting for it
+			if w := next(&m.waiters); w != nil {
+				m.sched.wake(w, nil)
+			} else {
+				m.locked = false
+			}
+			return nil, nil
+		}}, true
+	}
+
+	return nil, false
+}
+
+// nativeFunctionChannel returns a channel holding up to its argument of
+// values sent but not received.
+type nativeFunctionChannel struct{}
+
+func (n nativeFunctionChannel) Arity() int {
+	return 1
+}
+
+func (n nativeFunctionChannel) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	capacity, ok := arguments[0].(float64)
+	if !ok || capacity < 0 || capacity != float64(int(capacity)) {
+		return nil, errors.RuntimeError.New(nil, "channel capacity must be "+
+			"a non-negative integer")
+	}
+
+	return &Channel{sched: interpreter.sched, capacity: int(capacity)}, nil
+}
+
+func (n nativeFunctionChannel) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionWaitGroup returns a wait group counting no tasks.
+type nativeFunctionWaitGroup struct{}
+
+func (n nativeFunctionWaitGroup) Arity() int {
+	return 0
+}
+
+func (n nativeFunctionWaitGroup) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	return &WaitGroup{sched: interpreter.sched}, nil
+}
+
+func (n nativeFunctionWaitGroup) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionMutex returns an unlocked mutex.
+type nativeFunctionMutex struct{}
+
+func (n nativeFunctionMutex) Arity() int {
+	return 0
+}
+
+func (n nativeFunctionMutex) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	return &Mutex{sched: interpreter.sched}, nil
+}
+
+func (n nativeFunctionMutex) String() string {
+	return "<native fn>"
+}
+
+// nativeFunctionSelect receives from whichever of its channel arguments has
+// a value first, returning a list of the channel's position among them and
+// the value.
+type nativeFunctionSelect struct{}
+
+func (n nativeFunctionSelect) Arity() int {
+	return variadic
+}
+
+func (n nativeFunctionSelect) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	if len(arguments) == 0 {
+		return nil, errors.RuntimeError.New(nil, "select needs a channel")
+	}
+
+	channels := make([]*Channel, len(arguments))
+	for n, argument := range arguments {
+		c, ok := argument.(*Channel)
+		if !ok {
+			return nil, errors.RuntimeError.New(nil, "can only select channels")
+		}
+		channels[n] = c
+	}
+
+	index, value, err := selectReceive(interpreter.sched, channels)
+	if err == errStopped {
+		return nil, err
+	} else if err != nil {
+		return nil, errors.RuntimeError.New(nil, err.Error())
+	}
+
+	return &common.List{Elements: []interface{}{float64(index), value}}, nil
+}
+
+func (n nativeFunctionSelect) String() string {
+	return "<native fn>"
+}
@@ -0,0 +1,21 @@
+package lox
+
+import (
+	"strings"
+	"testing"
+)
+
+// The scripts under test/spawn cover goroutines on the tree engine.
+
+func TestSpawnRequiresTreeEngine(t *testing.T) {
+	l := New()
+	l.Engine = ENGINE_VM
+
+	var errOut strings.Builder
+	l.SetErrorOutput(&errOut)
+	l.Run("fun f() {}\nspawn f();\n")
+
+	if !l.HadError || !strings.Contains(errOut.String(), "spawn requires the tree engine") {
+		t.Errorf("got errors %q", errOut.String())
+	}
+}
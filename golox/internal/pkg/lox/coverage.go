@@ -0,0 +1,385 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// Coverage counts how many times each line of a program runs and which way
+// each of its branches goes. Every statement is attributed to the line it
+// starts on. The branch points are:
+//   - an "if", which takes its then or else branch
+//   - a "while", whose condition enters the body or exits the loop
+//   - a "for-in", which runs its body for a value or exits the loop
+//   - an "and" or "or", which short-circuits or evaluates its right operand
+//
+// Counts are kept by file and line rather than by syntax tree node, so the
+// runs of several programs, such as the tests of "golox test", which parse
+// their file once per test, add up. The report is written in lcov format.
+type Coverage struct {
+	noTool
+
+	out   string
+	files map[string]*fileCoverage
+
+	// Counters of the nodes registered so far
+	statements map[ast.Stmt]*int
+	branches   map[interface{}]*branchCoverage
+}
+
+type fileCoverage struct {
+	path     string
+	lines    map[int]*int
+	branches map[branchID]*branchCoverage
+	order    []*branchCoverage
+}
+
+type branchID struct {
+	line  int
+	block int // the branch point's position among those on its line
+}
+
+type branchCoverage struct {
+	branchID
+
+	// taken counts the times the first and second way were taken
+	taken [2]int
+}
+
+// NewCoverage returns a Coverage that writes its report to out.
+func NewCoverage(out string) *Coverage {
+	return &Coverage{
+		out:        out,
+		files:      make(map[string]*fileCoverage),
+		statements: make(map[ast.Stmt]*int),
+		branches:   make(map[interface{}]*branchCoverage),
+	}
+}
+
+// OnStatement counts a run of stmt.
+func (c *Coverage) OnStatement(stmt ast.Stmt) {
+	if count, ok := c.statements[stmt]; ok {
+		*count++
+	}
+}
+
+// branch counts node going the first way when first is true, or the second.
+func (c *Coverage) branch(node interface{}, first bool) {
+	b, ok := c.branches[node]
+	if !ok {
+		return
+	} else if first {
+		b.taken[0]++
+	} else {
+		b.taken[1]++
+	}
+}
+
+// register adds the statements and branch points of a program read from
+// path, so that those that never run are reported too.
+func (c *Coverage) register(path string, statements []ast.Stmt) {
+	file, ok := c.files[path]
+	if !ok {
+		file = &fileCoverage{
+			path:     path,
+			lines:    make(map[int]*int),
+			branches: make(map[branchID]*branchCoverage),
+		}
+		c.files[path] = file
+	}
+
+	r := &coverageRegistrar{coverage: c, file: file, blocks: make(map[int]int)}
+	r.statements(statements)
+}
+
+// coverageRegistrar walks a program registering its nodes with a file.
+type coverageRegistrar struct {
+	coverage *Coverage
+	file     *fileCoverage
+	blocks   map[int]int // branch points seen on each line
+}
+
+func (r *coverageRegistrar) statements(statements []ast.Stmt) {
+	for _, stmt := range statements {
+		r.statement(stmt)
+	}
+}
+
+func (r *coverageRegistrar) statement(stmt ast.Stmt) {
+	if stmt == nil {
+		return
+	}
+
+	// Blocks only group statements, and a statement made of a bare literal
+	// has no line to attribute it to.
+	if line := statementLine(stmt); line > 0 {
+		count, ok := r.file.lines[line]
+		if !ok {
+			count = new(int)
+			r.file.lines[line] = count
+		}
+		r.coverage.statements[stmt] = count
+	}
+
+	switch s := stmt.(type) {
+	case *ast.Block:
+		r.statements(s.Statements)
+	case *ast.Class:
+		// Methods are bound when the class is, not run as statements
+		for _, method := range s.Methods {
+			r.statements(method.Body)
+		}
+	case *ast.Expression:
+		r.expression(s.Expression)
+	case *ast.ForIn:
+		r.branch(stmt, s.Keyword.Line)
+		r.expression(s.Iterable)
+		r.statement(s.Body)
+	case *ast.Function:
+		r.statements(s.Body)
+	case *ast.If:
+		r.branch(stmt, expressionLine(s.Condition))
+		r.expression(s.Condition)
+		r.statement(s.ThenBranch)
+		r.statement(s.ElseBranch)
+	case *ast.Print:
+		r.expression(s.Expression)
+	case *ast.Return:
+		r.expression(s.Value)
+	case *ast.Spawn:
+		r.expression(s.Call)
+	case *ast.Test:
+		r.statements(s.Body)
+	case *ast.Var:
+		r.expression(s.Initializer)
+	case *ast.While:
+		r.branch(stmt, expressionLine(s.Condition))
+		r.expression(s.Condition)
+		r.statement(s.Body)
+	case *ast.Yield:
+		r.expression(s.Value)
+	}
+}
+
+func (r *coverageRegistrar) expression(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.Assign:
+		r.expression(e.Value)
+	case *ast.Binary:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case *ast.Call:
+		r.expression(e.Callee)
+		for _, argument := range e.Arguments {
+			r.expression(argument)
+		}
+	case *ast.Get:
+		r.expression(e.Object)
+	case *ast.Grouping:
+		r.expression(e.Expression)
+	case *ast.Logical:
+		r.expression(e.Left)
+		r.branch(expr, e.Operator.Line)
+		r.expression(e.Right)
+	case *ast.Set:
+		r.expression(e.Object)
+		r.expression(e.Value)
+	case *ast.Unary:
+		r.expression(e.Right)
+	}
+}
+
+func (r *coverageRegistrar) branch(node interface{}, line int) {
+	if line == 0 {
+		return
+	}
+
+	id := branchID{line, r.blocks[line]}
+	r.blocks[line]++
+
+	b, ok := r.file.branches[id]
+	if !ok {
+		b = &branchCoverage{branchID: id}
+		r.file.branches[id] = b
+		r.file.order = append(r.file.order, b)
+	}
+	r.coverage.branches[node] = b
+}
+
+// statementLine returns the line a statement starts on, or 0 if it has none.
+func statementLine(stmt ast.Stmt) int {
+	switch s := stmt.(type) {
+	case *ast.Class:
+		return s.Name.Line
+	case *ast.Expression:
+		return expressionLine(s.Expression)
+	case *ast.ForIn:
+		return s.Keyword.Line
+	case *ast.Function:
+		return s.Name.Line
+	case *ast.If:
+		return expressionLine(s.Condition)
+	case *ast.Print:
+		return s.Keyword.Line
+	case *ast.Return:
+		return s.Keyword.Line
+	case *ast.Spawn:
+		return s.Keyword.Line
+	case *ast.Test:
+		return s.Keyword.Line
+	case *ast.Var:
+		return s.Name.Line
+	case *ast.While:
+		return expressionLine(s.Condition)
+	case *ast.Yield:
+		return s.Keyword.Line
+	}
+
+	return 0
+}
+
+// expressionLine returns the line of the leftmost token of an expression, or
+// 0 if it is a literal.
+func expressionLine(expr ast.Expr) int {
+	switch e := expr.(type) {
+	case *ast.Assign:
+		return e.Name.Line
+	case *ast.Binary:
+		return expressionLine(e.Left)
+	case *ast.Call:
+		return expressionLine(e.Callee)
+	case *ast.Get:
+		return expressionLine(e.Object)
+	case *ast.Grouping:
+		return expressionLine(e.Expression)
+	case *ast.Logical:
+		return expressionLine(e.Left)
+	case *ast.Set:
+		return expressionLine(e.Object)
+	case *ast.Super:
+		return e.Keyword.Line
+	case *ast.This:
+		return e.Keyword.Line
+	case *ast.Unary:
+		return e.Operator.Line
+	case *ast.Variable:
+		return e.Name.Line
+	}
+
+	return 0
+}
+
+// Write writes the lcov report and prints a summary of each file to summary.
+func (c *Coverage) Write(summary io.Writer) error {
+	f, err := os.Create(c.out)
+	if err != nil {
+		return err
+	}
+
+	files := c.sortedFiles()
+	for _, file := range files {
+		file.writeLcov(f)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(summary, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "file\tlines\tbranches\t")
+	for _, file := range files {
+		lines, linesHit := file.lineTotals()
+		branches, branchesHit := file.branchTotals()
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", file.path, percent(linesHit, lines),
+			percent(branchesHit, branches))
+	}
+
+	return w.Flush()
+}
+
+func (c *Coverage) sortedFiles() []*fileCoverage {
+	var files []*fileCoverage
+	for _, file := range c.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	return files
+}
+
+func (f *fileCoverage) writeLcov(w io.Writer) {
+	// lcov tools resolve relative paths against their own directory
+	path, err := filepath.Abs(f.path)
+	if err != nil {
+		path = f.path
+	}
+
+	fmt.Fprintln(w, "TN:")
+	fmt.Fprintf(w, "SF:%s\n", path)
+
+	for _, b := range f.order {
+		for n, taken := range b.taken {
+			// "-" marks a branch point that was never reached
+			count := "-"
+			if b.taken[0]+b.taken[1] > 0 {
+				count = fmt.Sprint(taken)
+			}
+			fmt.Fprintf(w, "BRDA:%d,%d,%d,%s\n", b.line, b.block, n, count)
+		}
+	}
+	branches, branchesHit := f.branchTotals()
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branches, branchesHit)
+
+	var lines []int
+	for line := range f.lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	for _, line := range lines {
+		fmt.Fprintf(w, "DA:%d,%d\n", line, *f.lines[line])
+	}
+	total, hit := f.lineTotals()
+	fmt.Fprintf(w, "LF:%d\nLH:%d\n", total, hit)
+
+	fmt.Fprintln(w, "end_of_record")
+}
+
+func (f *fileCoverage) lineTotals() (total, hit int) {
+	for _, count := range f.lines {
+		total++
+		if *count > 0 {
+			hit++
+		}
+	}
+
+	return total, hit
+}
+
+func (f *fileCoverage) branchTotals() (total, hit int) {
+	for _, b := range f.order {
+		for _, taken := range b.taken {
+			total++
+			if taken > 0 {
+				hit++
+			}
+		}
+	}
+
+	return total, hit
+}
+
+func percent(hit, total int) string {
+	if total == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d/%d (%.1f%%)", hit, total,
+		float64(hit)/float64(total)*100)
+}
@@ -0,0 +1,81 @@
+package lox
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const coverageScript = `fun sign(n) {
+  if (n < 0) {
+    return -1;
+  }
+  return 1;
+}
+
+var i = 0;
+while (i < 2 and true) {
+  sign(i);
+  i = i + 1;
+}
+`
+
+func TestCoverage(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.lcov")
+
+	l := New()
+	l.SetOutput(io.Discard)
+	l.Coverage = NewCoverage(out)
+	l.path = "sign.lox"
+	l.Run(coverageScript)
+
+	if l.HadError || l.HadRuntimeError {
+		t.Fatal("unexpected error")
+	}
+
+	var summary bytes.Buffer
+	if err := l.Coverage.Write(&summary); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abs, _ := filepath.Abs("sign.lox")
+	expected := strings.Join([]string{
+		"TN:",
+		"SF:" + abs,
+		"BRDA:2,0,0,0", // the if is never taken
+		"BRDA:2,0,1,2",
+		"BRDA:9,0,0,2", // the loop runs twice
+		"BRDA:9,0,1,1",
+		"BRDA:9,1,0,1", // the "and" short-circuits once
+		"BRDA:9,1,1,2",
+		"BRF:6",
+		"BRH:5",
+		"DA:1,1",
+		"DA:2,2",
+		"DA:3,0",
+		"DA:5,2",
+		"DA:8,1",
+		"DA:9,1",
+		"DA:10,2",
+		"DA:11,2",
+		"LF:8",
+		"LH:7",
+		"end_of_record",
+	}, "\n") + "\n"
+
+	if string(data) != expected {
+		t.Errorf("unexpected report:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	if !strings.Contains(summary.String(), "7/8 (87.5%)  5/6 (83.3%)") {
+		t.Errorf("unexpected summary:\n%s", summary.String())
+	}
+}
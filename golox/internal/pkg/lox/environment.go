@@ -21,45 +21,57 @@ func NewEnvironment(l *Lox) *Environment {
 	}
 }
 
+// NewLocalEnvironment creates a nested scope. Values is allocated on the first
+// Define since many scopes, such as the body of a method without parameters,
+// never declare anything.
 func NewLocalEnvironment(enclosing *Environment) *Environment {
 	return &Environment{
 		runtime:   enclosing.runtime,
 		Enclosing: enclosing,
-		Values:    make(map[string]interface{}),
 	}
 }
 
-func (e Environment) Get(name *token.Token) interface{} {
+func (e Environment) Get(name *token.Token) (interface{}, error) {
 	if val, ok := e.Values[name.Lexeme]; ok {
-		return val
+		return val, nil
 	}
 
 	if e.Enclosing != nil {
 		return e.Enclosing.Get(name)
 	}
 
-	e.runtime.RuntimeError(errors.RuntimeError.New(name,
-		fmt.Sprintf("undefined variable %q", name.Lexeme)))
-	return nil
+	return nil, errors.RuntimeError.New(name,
+		fmt.Sprintf("undefined variable %q", name.Lexeme))
 }
 
-func (e *Environment) Assign(name *token.Token, value interface{}) {
+func (e *Environment) Assign(name *token.Token, value interface{}) error {
 	if _, ok := e.Values[name.Lexeme]; ok {
 		e.Values[name.Lexeme] = value
-		return
+
+		if h := e.hooks(); h != nil {
+			h.OnAssign(name.Lexeme, value)
+		}
+		return nil
 	}
 
 	if e.Enclosing != nil {
-		e.Enclosing.Assign(name, value)
-		return
+		return e.Enclosing.Assign(name, value)
 	}
 
-	e.runtime.RuntimeError(errors.RuntimeError.New(name,
-		fmt.Sprintf("undefined variable %q", name.Lexeme)))
+	return errors.RuntimeError.New(name,
+		fmt.Sprintf("undefined variable %q", name.Lexeme))
 }
 
 func (e *Environment) Define(name string, value interface{}) {
+	if e.Values == nil {
+		e.Values = make(map[string]interface{})
+	}
+
 	e.Values[name] = value
+
+	if h := e.hooks(); h != nil {
+		h.OnDefine(name, value)
+	}
 }
 
 func (e *Environment) GetAt(distance int, name string) interface{} {
@@ -68,6 +80,10 @@ func (e *Environment) GetAt(distance int, name string) interface{} {
 
 func (e *Environment) AssignAt(distance int, name *token.Token, value interface{}) {
 	e.ancestor(distance).Values[name.Lexeme] = value
+
+	if h := e.hooks(); h != nil {
+		h.OnAssign(name.Lexeme, value)
+	}
 }
 
 func (e *Environment) ancestor(distance int) *Environment {
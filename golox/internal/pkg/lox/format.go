@@ -0,0 +1,166 @@
+package lox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// indent is the indentation of each level of nesting.
+const indent = "  "
+
+// Format returns source laid out in the standard style. Tokens stay on the
+// lines they are on, so the layout of a program is kept, but every line is
+// indented by two spaces for each brace or parenthesis it is nested in, and
+// tokens are spaced consistently: binary operators and braces are surrounded
+// by spaces, calls and groupings are not. Comments are kept, and runs of
+// blank lines shortened to one. Source that doesn't parse is an error.
+func Format(source string) (string, error) {
+	var errOut strings.Builder
+	quiet := &Lox{out: bufio.NewWriter(io.Discard), errOut: &errOut}
+	NewParser(quiet, NewScanner(quiet, source).ScanTokens()).Parse()
+	if quiet.HadError {
+		return "", fmt.Errorf("%s", strings.TrimRight(errOut.String(), "\n"))
+	}
+
+	scanner := NewScanner(quiet, source)
+	scanner.comments = true
+	tokens := scanner.ScanTokens()
+
+	var sb strings.Builder
+	if strings.HasPrefix(source, "#!") {
+		sb.WriteString(strings.SplitN(source, "\n", 2)[0] + "\n")
+	}
+
+	var (
+		depth    int          // braces and parentheses open
+		line     int          // the source line being written
+		previous *token.Token // the token before on the same line
+		last     *token.Token // the last token written, other than comments
+		unary    bool         // whether previous is a unary operator
+	)
+
+	for _, t := range tokens {
+		if t.Type == token.EOF {
+			break
+		}
+
+		start := t.Line - strings.Count(t.Lexeme, "\n")
+		if start > line {
+			if line > 0 {
+				sb.WriteString("\n")
+				if start > line+1 {
+					sb.WriteString("\n")
+				}
+			}
+
+			// Closing brackets at the start of a line are outdented, and
+			// lines continuing a statement indented one more level
+			level := depth
+			if closes(t) {
+				level--
+			} else if last != nil && continues(last) {
+				level++
+			}
+			if level > 0 {
+				sb.WriteString(strings.Repeat(indent, level))
+			}
+			previous = nil
+		}
+
+		if previous != nil && spaced(previous, unary, t) {
+			sb.WriteString(" ")
+		}
+
+		lexeme := t.Lexeme
+		if t.Type == token.COMMENT {
+			lexeme = strings.TrimRight(lexeme, " \t\r")
+		}
+		sb.WriteString(lexeme)
+
+		switch t.Type {
+		case token.LEFT_BRACE, token.LEFT_PAREN:
+			depth++
+		case token.RIGHT_BRACE, token.RIGHT_PAREN:
+			depth--
+		}
+
+		// The star marking a generator method hugs its name like a unary
+		// operator, while fun* is spaced like a keyword
+		unary = t.Type == token.BANG ||
+			t.Type == token.MINUS && (last == nil || !endsOperand(last)) ||
+			t.Type == token.STAR && last != nil && last.Type != token.FUN &&
+				!endsOperand(last)
+		previous = t
+		if t.Type != token.COMMENT {
+			last = t
+		}
+		line = t.Line
+	}
+
+	if line > 0 {
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+// spaced reports whether a space separates t from the token before it on the
+// same line.
+func spaced(previous *token.Token, unary bool, t *token.Token) bool {
+	switch t.Type {
+	case token.RIGHT_PAREN, token.COMMA, token.SEMICOLON, token.DOT:
+		return false
+	case token.RIGHT_BRACE:
+		// Empty blocks are written {}
+		return previous.Type != token.LEFT_BRACE
+	case token.LEFT_PAREN:
+		// Calls, but not keywords such as if and while, hug their arguments
+		if endsOperand(previous) {
+			return false
+		}
+	case token.STAR:
+		// fun*
+		if previous.Type == token.FUN {
+			return false
+		}
+	}
+
+	switch previous.Type {
+	case token.LEFT_PAREN, token.DOT:
+		return false
+	}
+
+	return !unary
+}
+
+// endsOperand reports whether t can be the last token of an operand, so that
+// a minus after it is a binary operator and a parenthesis starts a call.
+func endsOperand(t *token.Token) bool {
+	switch t.Type {
+	case token.IDENTIFIER, token.STRING, token.NUMBER, token.RIGHT_PAREN,
+		token.TRUE, token.FALSE, token.NIL, token.THIS:
+		return true
+	}
+
+	return false
+}
+
+// continues reports whether the line after one ending with t continues the
+// same statement.
+func continues(t *token.Token) bool {
+	switch t.Type {
+	case token.SEMICOLON, token.LEFT_BRACE, token.RIGHT_BRACE, token.LEFT_PAREN,
+		token.COMMA:
+		return false
+	}
+
+	return true
+}
+
+func closes(t *token.Token) bool {
+	return t.Type == token.RIGHT_BRACE || t.Type == token.RIGHT_PAREN
+}
@@ -0,0 +1,61 @@
+package lox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := map[string]string{
+		"print  1+2 ;":                          "print 1 + 2;\n",
+		"print -1 - -x;":                        "print -1 - -x;\n",
+		"print !(a==b);":                        "print !(a == b);\n",
+		"fun f(a,b){return a(b).c;}":            "fun f(a, b) { return a(b).c; }\n",
+		"class A < B{init(){}}":                 "class A < B { init() {} }\n",
+		"fun *g(){yield 1;}":                    "fun* g() { yield 1; }\n",
+		"class A{* g(){yield -1;}}":             "class A { *g() { yield -1; } }\n",
+		"if (x)\nprint 1;":                      "if (x)\n  print 1;\n",
+		"var a = 1\n+ 2;":                       "var a = 1\n  + 2;\n",
+		"{\nprint 1; // one\n\n\n\nprint 2;\n}": "{\n  print 1; // one\n\n  print 2;\n}\n",
+		"#!/usr/bin/env golox\nprint 1;":        "#!/usr/bin/env golox\nprint 1;\n",
+		"fun f() {\n    return \"a\nb\";\n}":    "fun f() {\n  return \"a\nb\";\n}\n",
+	}
+
+	for source, want := range tests {
+		got, err := Format(source)
+		if err != nil {
+			t.Errorf("%q: %s", source, err)
+		} else if got != want {
+			t.Errorf("%q: got %q, want %q", source, got, want)
+		}
+	}
+
+	if _, err := Format("print (1;"); err == nil {
+		t.Error("formatted source that doesn't parse")
+	}
+}
+
+// Formatting is idempotent over the test suite
+func TestFormatIdempotent(t *testing.T) {
+	paths, err := filepath.Glob("../../../../test/*/*.lox")
+	if err != nil || len(paths) == 0 {
+		t.Skip("test suite not found")
+	}
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		once, err := Format(string(source))
+		if err != nil {
+			continue
+		}
+
+		if twice, _ := Format(once); twice != once {
+			t.Errorf("%s: formatting again changed it to %q", path, twice)
+		}
+	}
+}
@@ -14,6 +14,9 @@ type Function struct {
 	Closure       *Environment
 	Declaration   *ast.Function
 	isInitializer bool
+
+	// class declares the function when it is a method
+	class *Class
 }
 
 func NewFunction(declaration *ast.Function, closure *Environment, isInitializer bool) *Function {
@@ -25,40 +28,76 @@ func NewFunction(declaration *ast.Function, closure *Environment, isInitializer
 }
 
 func (f *Function) Bind(instance *Instance) *Function {
-	// Add new environment within method's original closure
-	environment := NewLocalEnvironment(f.Closure)
-
-	// Declare "this" in new env and bind to the instance that this method is
-	// being accessed from.
-	environment.Define("this", instance)
+	// Get the environment within method's original closure that declares
+	// "this" bound to the instance that this method is being accessed from.
+	environment := instance.thisEnvironment(f.Closure)
 
 	// Return function that contains instance is bound as "this"
-	return NewFunction(f.Declaration, environment, f.isInitializer)
+	bound := NewFunction(f.Declaration, environment, f.isInitializer)
+	bound.class = f.class
+	return bound
 }
 
 func (f *Function) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
-	environment := NewLocalEnvironment(f.Closure)
+	return f.call(interpreter, f.Closure, arguments)
+}
+
+// callMethod invokes the method with "this" bound to instance. It behaves like
+// f.Bind(instance).Call() without allocating a bound Function first.
+func (f *Function) callMethod(interpreter *Interpreter, instance *Instance, arguments []interface{}) (interface{}, error) {
+	return f.call(interpreter, instance.thisEnvironment(f.Closure), arguments)
+}
+
+func (f *Function) call(interpreter *Interpreter, closure *Environment, arguments []interface{}) (interface{}, error) {
+	if t, ok := interpreter.hooks.(tool); ok {
+		frame := &callFrame{
+			key:       f.Declaration,
+			name:      f.name(),
+			line:      f.Declaration.Name.Line,
+			arguments: arguments,
+		}
+		if f.class != nil {
+			frame.class = f.class.Name
+		}
+
+		t.enter(frame)
+		defer t.leave(frame)
+	}
+
+	environment := NewLocalEnvironment(closure)
 
 	for i := 0; i < len(f.Declaration.Params); i++ {
 		environment.Define(f.Declaration.Params[i].Lexeme, arguments[i])
 	}
 
-	_, err := interpreter.executeBlock(f.Declaration.Body, environment)
-	if err != nil {
-		if IsReturnable(err) {
-			if f.isInitializer {
-				return f.Closure.GetAt(0, "this"), nil
-			}
+	// The body of a generator runs as values are asked for
+	if f.Declaration.Generator {
+		return newGenerator(interpreter, f, environment), nil
+	}
 
-			return err.(*Return).Value, nil
-		}
+	_, err := interpreter.executeBlock(f.Declaration.Body, environment)
+	if err != nil && !IsReturnable(err) {
+		return nil, err
 	}
 
 	if f.isInitializer {
-		return f.Closure.GetAt(0, "this"), nil
+		return closure.GetAt(0, "this"), nil
+	}
+
+	if err != nil {
+		return err.(*Return).Value, nil
+	}
+
+	return nil, nil
+}
+
+// name returns the function's name, qualified by its class for methods.
+func (f *Function) name() string {
+	if f.class != nil {
+		return f.class.Name + "." + f.Declaration.Name.Lexeme
 	}
 
-	return nil, err
+	return f.Declaration.Name.Lexeme
 }
 
 func (f *Function) Arity() int {
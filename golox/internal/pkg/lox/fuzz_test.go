@@ -0,0 +1,103 @@
+package lox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// The suite shared with clox seeds every fuzz target.
+const corpus = "../../../../test"
+
+// fuzzStepLimit bounds how many statements an interpreted input may execute
+// so inputs with infinite loops still finish.
+const fuzzStepLimit = 10000
+
+func addCorpus(f *testing.F) {
+	err := filepath.Walk(corpus, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".lox" {
+			return err
+		}
+
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		f.Add(string(source))
+		return nil
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+}
+
+// newFuzzLox returns a runtime that discards everything it writes.
+func newFuzzLox() *Lox {
+	l := New()
+	l.SetOutput(io.Discard)
+	l.SetErrorOutput(io.Discard)
+	return l
+}
+
+func parseFuzz(l *Lox, source string) []ast.Stmt {
+	tokens := NewScanner(l, source).ScanTokens()
+	return NewParser(l, tokens).Parse()
+}
+
+func FuzzScanner(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		l := newFuzzLox()
+		tokens := NewScanner(l, source).ScanTokens()
+
+		if len(tokens) == 0 {
+			t.Fatal("no tokens returned, expected at least EOF")
+		}
+	})
+}
+
+func FuzzParser(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		l := newFuzzLox()
+		statements := parseFuzz(l, source)
+
+		if !l.HadError {
+			for _, stmt := range statements {
+				if stmt == nil {
+					t.Fatal("parser returned a nil statement without an error")
+				}
+			}
+		}
+	})
+}
+
+func FuzzResolver(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		l := newFuzzLox()
+		statements := parseFuzz(l, source)
+		if l.HadError {
+			return
+		}
+
+		NewResolver(l, l.Interpreter).Resolve(statements)
+	})
+}
+
+func FuzzInterpreter(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		l := newFuzzLox()
+		l.Interpreter.stepLimit = fuzzStepLimit
+		l.Run(source)
+	})
+}
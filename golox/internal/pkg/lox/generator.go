@@ -0,0 +1,254 @@
+package lox
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+)
+
+// Calling a generator function runs none of its body. It returns a Generator
+// that runs the body on a goroutine of its own, up to its next yield, when a
+// value is asked for. The caller waits meanwhile, so the body runs on the
+// caller's turn like the rest of its statements, and other goroutines asking
+// for a value wait until the caller has its own. A generator that is
+// abandoned while suspended at a yield is stopped once it has been garbage
+// collected, or when its program ends.
+
+// Generator is an iterator over the values a generator function yields.
+type Generator struct {
+	name string
+	co   *coroutine
+
+	started bool
+	running bool
+	done    bool
+
+	// caller is the goroutine running the body, and waiting the other
+	// goroutines asking for a value meanwhile
+	caller  *Interpreter
+	waiting []*waiter
+
+	// value is the next value when ready is set
+	value interface{}
+	ready bool
+}
+
+// coroutine runs the body of a generator. It doesn't refer back to its
+// Generator, so the Generator can be collected while the body is suspended.
+type coroutine struct {
+	interpreter *Interpreter
+	body        []ast.Stmt
+	environment *Environment
+
+	resume  chan bool // true to run to the next yield, false to stop
+	yields  chan yielded
+	stopped bool
+}
+
+// yielded is what a coroutine passes back to its caller when it yields or
+// finishes.
+type yielded struct {
+	value interface{}
+	done  bool
+	err   error
+}
+
+// newGenerator returns a generator that runs the body of function in
+// environment, which binds its parameters.
+func newGenerator(interpreter *Interpreter, function *Function, environment *Environment) *Generator {
+	i := interpreter.fork()
+	i.depth = interpreter.depth
+
+	co := &coroutine{
+		interpreter: i,
+		body:        function.Declaration.Body,
+		environment: environment,
+		resume:      make(chan bool, 1),
+		yields:      make(chan yielded, 1),
+	}
+	i.coroutine = co
+
+	g := &Generator{name: function.Declaration.Name.Lexeme, co: co}
+	runtime.SetFinalizer(g, func(g *Generator) {
+		g.co.interpreter.sched.abandon(g.co)
+	})
+
+	return g
+}
+
+func (g *Generator) String() string {
+	return fmt.Sprintf("<generator %s>", g.name)
+}
+
+func (g *Generator) Method(name string) (*common.NativeMethod, bool) {
+	switch name {
+	case "hasNext":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return g.hasNext()
+		}}, true
+	case "next":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return g.next()
+		}}, true
+	case "iterator":
+		return &common.NativeMethod{Name: name, Arity: 0, Call: func([]interface{}) (interface{}, error) {
+			return g, nil
+		}}, true
+	}
+
+	return nil, false
+}
+
+func (g *Generator) hasNext() (bool, error) {
+	if err := g.advance(); err != nil {
+		return false, err
+	}
+
+	return g.ready, nil
+}
+
+func (g *Generator) next() (interface{}, error) {
+	if err := g.advance(); err != nil {
+		return nil, err
+	}
+
+	if !g.ready {
+		return nil, fmt.Errorf("generator %s has no values left", g.name)
+	}
+
+	g.ready = false
+	return g.value, nil
+}
+
+// advance runs the body to its next yield unless the next value is already
+// known or the body has finished.
+func (g *Generator) advance() error {
+	sched := g.co.interpreter.sched
+	for g.running && sched.running != g.caller {
+		w := newWaiter()
+		g.waiting = append(g.waiting, w)
+		if err := sched.park(w); err != nil {
+			return err
+		}
+	}
+
+	if g.ready || g.done {
+		return nil
+	} else if g.co.stopped {
+		g.done = true
+		return nil
+	} else if g.running {
+		return fmt.Errorf("generator %s is already running", g.name)
+	}
+
+	g.running = true
+	g.caller = sched.running
+	if !g.started {
+		g.started = true
+		go g.co.run()
+	} else {
+		sched.resumed(g.co)
+		g.co.resume <- true
+	}
+
+	y := <-g.co.yields
+	g.running = false
+	g.caller = nil
+	for w := next(&g.waiting); w != nil; w = next(&g.waiting) {
+		sched.wake(w, nil)
+	}
+
+	if y.done {
+		g.done = true
+		return y.err
+	}
+
+	sched.suspended(g.co)
+	g.value, g.ready = y.value, true
+	return nil
+}
+
+func (c *coroutine) run() {
+	_, err := c.interpreter.executeBlock(c.body, c.environment)
+	if IsReturnable(err) {
+		err = nil
+	}
+
+	c.yields <- yielded{done: true, err: err}
+}
+
+// yield passes value to the caller and waits until the generator is resumed.
+// It returns errStopped if the generator is stopped instead.
+func (c *coroutine) yield(value interface{}) error {
+	c.yields <- yielded{value: value}
+	if !<-c.resume {
+		return errStopped
+	}
+
+	return nil
+}
+
+// stop unwinds a coroutine suspended at a yield.
+func (c *coroutine) stop() {
+	c.stopped = true
+	c.resume <- false
+	<-c.yields
+}
+
+// The scheduler keeps the coroutines suspended at a yield so those still
+// suspended when the program ends can be stopped. Abandoned ones are handed
+// over by the finalizers of their generators, which run on a goroutine of
+// their own. They are stopped at the next iteration of a loop, which is where
+// a program creating generators without end spends its time, or when the
+// program ends.
+
+func (s *scheduler) suspended(co *coroutine) {
+	if s.coroutines == nil {
+		s.coroutines = make(map[*coroutine]bool)
+	}
+	s.coroutines[co] = true
+}
+
+func (s *scheduler) resumed(co *coroutine) {
+	delete(s.coroutines, co)
+}
+
+// abandon queues co to be stopped. Unlike the other methods of scheduler it
+// may be called by any goroutine.
+func (s *scheduler) abandon(co *coroutine) {
+	s.abandonedMu.Lock()
+	s.abandoned = append(s.abandoned, co)
+	atomic.StoreInt32(&s.pending, 1)
+	s.abandonedMu.Unlock()
+}
+
+// reclaim stops the coroutines that have been abandoned.
+func (s *scheduler) reclaim() {
+	if atomic.LoadInt32(&s.pending) == 0 {
+		return
+	}
+
+	s.abandonedMu.Lock()
+	abandoned := s.abandoned
+	s.abandoned = nil
+	atomic.StoreInt32(&s.pending, 0)
+	s.abandonedMu.Unlock()
+
+	for _, co := range abandoned {
+		if s.coroutines[co] {
+			delete(s.coroutines, co)
+			co.stop()
+		}
+	}
+}
+
+// stopCoroutines stops every coroutine still suspended.
+func (s *scheduler) stopCoroutines() {
+	for co := range s.coroutines {
+		delete(s.coroutines, co)
+		co.stop()
+	}
+}
@@ -0,0 +1,77 @@
+package lox
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The scripts under test/generator cover generators on the tree engine.
+
+func TestGeneratorsRequireTreeEngine(t *testing.T) {
+	l := New()
+	l.Engine = ENGINE_VM
+
+	var errOut strings.Builder
+	l.SetErrorOutput(&errOut)
+	l.Run("fun g() { yield 1; }")
+
+	if !l.HadError || !strings.Contains(errOut.String(), "generators require the tree engine") {
+		t.Errorf("got errors %q", errOut.String())
+	}
+}
+
+// nativeFunctionCollect collects garbage until the goroutines of abandoned
+// generators have been stopped, returning how many are still suspended.
+type nativeFunctionCollect struct{}
+
+func (n nativeFunctionCollect) Arity() int {
+	return 0
+}
+
+func (n nativeFunctionCollect) Call(interpreter *Interpreter, arguments []interface{}) (interface{}, error) {
+	for tries := 0; tries < 100 && len(interpreter.sched.coroutines) > 0; tries++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+		interpreter.sched.reclaim()
+	}
+
+	return float64(len(interpreter.sched.coroutines)), nil
+}
+
+func TestAbandonedGenerators(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	source := strings.Join([]string{
+		`fun* naturals() { var n = 0; while (true) { yield n; n = n + 1; } }`,
+		`fun first() { var it = naturals(); return it.next(); }`,
+		`for (var i = 0; i < 100; i = i + 1) first();`,
+		`print collect();`,
+		`var kept = naturals();`,
+		`kept.next();`,
+	}, "\n")
+
+	l := New()
+	l.Interpreter.globals.Define("collect", nativeFunctionCollect{})
+
+	var out, errOut strings.Builder
+	l.SetOutput(&out)
+	l.SetErrorOutput(&errOut)
+	l.Run(source)
+
+	if out.String() != "0\n" || errOut.Len() > 0 {
+		t.Errorf("got output %q and errors %q", out.String(), errOut.String())
+	}
+
+	// The generator kept in a global is stopped when the program ends. Its
+	// goroutine exits just after.
+	after := runtime.NumGoroutine()
+	for tries := 0; tries < 100 && after > before; tries++ {
+		time.Sleep(time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		t.Errorf("%d goroutines left running", after-before)
+	}
+}
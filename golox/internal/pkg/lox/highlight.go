@@ -0,0 +1,85 @@
+package lox
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// ANSI colors of the lexical classes highlighted in the REPL.
+const (
+	colorKeyword = "\x1b[35m" // magenta
+	colorLiteral = "\x1b[36m" // cyan: true, false, nil and numbers
+	colorString  = "\x1b[32m" // green
+	colorComment = "\x1b[90m" // grey
+	colorError   = "\x1b[31m" // red: characters the scanner rejects
+	colorReset   = "\x1b[0m"
+)
+
+// highlight colors a REPL line by the class of each token the scanner finds
+// in it. Colors are escape sequences, so the line keeps its width.
+func highlight(line string) string {
+	var sb strings.Builder
+
+	paint := func(color, text string) {
+		if color == "" || text == "" {
+			sb.WriteString(text)
+			return
+		}
+		sb.WriteString(color + text + colorReset)
+	}
+
+	pos := 0
+	for _, t := range scanQuietly(line) {
+		// Tokens are separated by whitespace, comments and characters the
+		// scanner rejects. An unterminated string runs to the end.
+		for pos < len(line) {
+			c := line[pos]
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+				sb.WriteByte(c)
+				pos++
+			} else if strings.HasPrefix(line[pos:], "//") {
+				end := strings.IndexByte(line[pos:], '\n')
+				if end < 0 {
+					end = len(line) - pos
+				}
+				paint(colorComment, line[pos:pos+end])
+				pos += end
+			} else if t.Type != token.EOF && strings.HasPrefix(line[pos:], t.Lexeme) {
+				break
+			} else if c == '"' {
+				paint(colorString, line[pos:])
+				pos = len(line)
+			} else {
+				_, size := utf8.DecodeRuneInString(line[pos:])
+				paint(colorError, line[pos:pos+size])
+				pos += size
+			}
+		}
+
+		if t.Type == token.EOF {
+			break
+		}
+
+		paint(tokenColor(t), t.Lexeme)
+		pos += len(t.Lexeme)
+	}
+
+	return sb.String()
+}
+
+func tokenColor(t *token.Token) string {
+	switch t.Type {
+	case token.TRUE, token.FALSE, token.NIL, token.NUMBER:
+		return colorLiteral
+	case token.STRING:
+		return colorString
+	}
+
+	if _, ok := token.Keywords[t.Lexeme]; ok {
+		return colorKeyword
+	}
+
+	return ""
+}
@@ -0,0 +1,217 @@
+package lox
+
+import (
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// Hooks observe the tree-walk interpreter as it runs a program, for tools
+// such as debuggers and custom tracers. Install them with
+// Interpreter.AddHooks. Embed NoHooks to implement only some of the
+// callbacks.
+//
+// Hooks run synchronously on the interpreter, so a slow hook slows down the
+// program. When no hooks are installed the interpreter only pays for a nil
+// check at each callback site.
+type Hooks interface {
+	// OnStatement is called before each statement runs.
+	OnStatement(stmt ast.Stmt)
+
+	// OnCall is called when a function, method or class is called, once its
+	// arguments have been evaluated and checked.
+	OnCall(call *ast.Call, callee interface{}, arguments []interface{})
+
+	// OnReturn is called when a call reported to OnCall returns, or fails, in
+	// which case value is nil and OnError is called as well.
+	OnReturn(call *ast.Call, callee interface{}, value interface{})
+
+	// OnError is called once for each runtime error, by the statement that
+	// raised it.
+	OnError(err error)
+
+	// OnDefine is called when a variable is declared, including the
+	// parameters of a call and the names of functions and classes.
+	OnDefine(name string, value interface{})
+
+	// OnAssign is called when a variable is assigned a new value.
+	OnAssign(name string, value interface{})
+}
+
+// NoHooks implements every callback of Hooks by doing nothing.
+type NoHooks struct{}
+
+func (NoHooks) OnStatement(ast.Stmt)                         {}
+func (NoHooks) OnCall(*ast.Call, interface{}, []interface{}) {}
+func (NoHooks) OnReturn(*ast.Call, interface{}, interface{}) {}
+func (NoHooks) OnError(error)                                {}
+func (NoHooks) OnDefine(string, interface{})                 {}
+func (NoHooks) OnAssign(string, interface{})                 {}
+
+// ComposeHooks returns Hooks that pass every callback to each of hooks in
+// turn.
+func ComposeHooks(hooks ...Hooks) Hooks {
+	var list hookList
+	for _, h := range hooks {
+		if nested, ok := h.(hookList); ok {
+			list = append(list, nested...)
+		} else if h != nil {
+			list = append(list, h)
+		}
+	}
+
+	return list
+}
+
+type hookList []Hooks
+
+func (l hookList) OnStatement(stmt ast.Stmt) {
+	for _, h := range l {
+		h.OnStatement(stmt)
+	}
+}
+
+func (l hookList) OnCall(call *ast.Call, callee interface{}, arguments []interface{}) {
+	for _, h := range l {
+		h.OnCall(call, callee, arguments)
+	}
+}
+
+func (l hookList) OnReturn(call *ast.Call, callee interface{}, value interface{}) {
+	for _, h := range l {
+		h.OnReturn(call, callee, value)
+	}
+}
+
+func (l hookList) OnError(err error) {
+	for _, h := range l {
+		h.OnError(err)
+	}
+}
+
+func (l hookList) OnDefine(name string, value interface{}) {
+	for _, h := range l {
+		h.OnDefine(name, value)
+	}
+}
+
+func (l hookList) OnAssign(name string, value interface{}) {
+	for _, h := range l {
+		h.OnAssign(name, value)
+	}
+}
+
+// tool is implemented by the hooks of the coverage report, the profiler and
+// the tracer, which follow more of a program than Hooks show. A hookList
+// passes these callbacks on to those of its hooks that are tools.
+type tool interface {
+	Hooks
+
+	// statementDone is called when a statement reported to OnStatement has
+	// finished, even if it failed.
+	statementDone(stmt ast.Stmt)
+
+	// branch is called when a branch point goes the first way or the
+	// second, as listed by Coverage.
+	branch(node interface{}, first bool)
+
+	// enter and leave are called around every call of a Lox function,
+	// method or class, including the initializer a class calls.
+	enter(frame *callFrame)
+	leave(frame *callFrame)
+}
+
+// noTool implements the callbacks of tool by doing nothing.
+type noTool struct{ NoHooks }
+
+func (noTool) statementDone(ast.Stmt)   {}
+func (noTool) branch(interface{}, bool) {}
+func (noTool) enter(*callFrame)         {}
+func (noTool) leave(*callFrame)         {}
+
+func (l hookList) statementDone(stmt ast.Stmt) {
+	for _, h := range l {
+		if t, ok := h.(tool); ok {
+			t.statementDone(stmt)
+		}
+	}
+}
+
+func (l hookList) branch(node interface{}, first bool) {
+	for _, h := range l {
+		if t, ok := h.(tool); ok {
+			t.branch(node, first)
+		}
+	}
+}
+
+func (l hookList) enter(frame *callFrame) {
+	for _, h := range l {
+		if t, ok := h.(tool); ok {
+			t.enter(frame)
+		}
+	}
+}
+
+// leave runs in reverse so that tools leave calls in the opposite order they
+// entered them.
+func (l hookList) leave(frame *callFrame) {
+	for n := len(l) - 1; n >= 0; n-- {
+		if t, ok := l[n].(tool); ok {
+			t.leave(frame)
+		}
+	}
+}
+
+// goroutineHooks returns the hooks goroutines run with. The profiler and the
+// tracer only follow the script, as goroutines interleave their calls with
+// it.
+func goroutineHooks(h Hooks) Hooks {
+	switch h := h.(type) {
+	case *Profile, *Trace:
+		return nil
+	case hookList:
+		var list hookList
+		for _, nested := range h {
+			if nested := goroutineHooks(nested); nested != nil {
+				list = append(list, nested)
+			}
+		}
+
+		if len(list) == 0 {
+			return nil
+		} else if len(list) == 1 {
+			return list[0]
+		}
+		return list
+	}
+
+	return h
+}
+
+// AddHooks installs h after any hooks already installed.
+func (i *Interpreter) AddHooks(h Hooks) {
+	if i.hooks == nil {
+		i.hooks = h
+	} else {
+		i.hooks = ComposeHooks(i.hooks, h)
+	}
+}
+
+// SetHooks replaces the installed hooks with h, or removes them if h is nil.
+func (i *Interpreter) SetHooks(h Hooks) {
+	i.hooks = h
+}
+
+// Hooks returns the installed hooks, nil if there are none.
+func (i *Interpreter) Hooks() Hooks {
+	return i.hooks
+}
+
+// hooks returns the hooks of the interpreter an environment belongs to.
+// Globals are defined before the interpreter is set up.
+func (e *Environment) hooks() Hooks {
+	if i := e.runtime.Interpreter; i != nil {
+		return i.hooks
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// recorder is Hooks that logs the callbacks it cares about.
+type recorder struct {
+	NoHooks
+	events []string
+}
+
+func (r *recorder) OnCall(call *ast.Call, callee interface{}, arguments []interface{}) {
+	r.events = append(r.events, fmt.Sprintf("call %v %v", callee, arguments))
+}
+
+func (r *recorder) OnReturn(call *ast.Call, callee interface{}, value interface{}) {
+	r.events = append(r.events, fmt.Sprintf("return %v %v", callee, value))
+}
+
+func (r *recorder) OnError(err error) {
+	r.events = append(r.events, "error "+RuntimeErrorMessage(err))
+}
+
+func (r *recorder) OnDefine(name string, value interface{}) {
+	r.events = append(r.events, fmt.Sprintf("define %s %v", name, value))
+}
+
+func (r *recorder) OnAssign(name string, value interface{}) {
+	r.events = append(r.events, fmt.Sprintf("assign %s %v", name, value))
+}
+
+// counter counts statements.
+type counter struct {
+	NoHooks
+	statements int
+}
+
+func (c *counter) OnStatement(ast.Stmt) {
+	c.statements++
+}
+
+func TestHooks(t *testing.T) {
+	l := New()
+	l.SetOutput(io.Discard)
+	l.SetErrorOutput(io.Discard)
+
+	r := &recorder{}
+	c := &counter{}
+	l.Interpreter.AddHooks(r)
+	l.Interpreter.AddHooks(c)
+
+	l.Run(`
+fun double(n) { return n * 2; }
+var a = 1;
+{
+  var b = double(a);
+  a = b;
+}
+a.field;
+`)
+
+	expected := []string{
+		"define double <fn double>",
+		"define a 1",
+		"call <fn double> [1]",
+		"define n 1",
+		"return <fn double> 2",
+		"define b 2",
+		"assign a 2",
+		"error [line 8] RuntimeError: only instances have properties",
+	}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("got events\n%q\nexpected\n%q", r.events, expected)
+	}
+
+	// fun, var, block, var, return, assignment and the failing expression
+	if c.statements != 7 {
+		t.Errorf("counted %d statements, expected 7", c.statements)
+	}
+
+	l.Interpreter.SetHooks(nil)
+	if l.Interpreter.Hooks() != nil {
+		t.Error("hooks still installed")
+	}
+}
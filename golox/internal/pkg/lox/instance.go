@@ -16,6 +16,17 @@ type Instance struct {
 
 	// Instance is responsible for storing state.
 	Fields map[string]interface{}
+
+	// bindings caches the environment binding "this" to the instance for each
+	// method closure, so calling a method doesn't allocate a new one. Methods
+	// of a class share a closure, so this rarely holds more than a couple of
+	// entries.
+	bindings []binding
+}
+
+type binding struct {
+	closure     *Environment
+	environment *Environment
 }
 
 func NewInstance(l *Lox, klass *Class) *Instance {
@@ -30,21 +41,35 @@ func (i *Instance) String() string {
 	return fmt.Sprintf("%s instance", i.Klass)
 }
 
-func (i *Instance) Get(name *token.Token) interface{} {
+func (i *Instance) Get(name *token.Token) (interface{}, error) {
 	if val, ok := i.Fields[name.Lexeme]; ok {
-		return val
+		return val, nil
 	}
 
 	// If we did not find a matching field, check the Class's methods
 	method := i.Klass.FindMethod(name.Lexeme)
 	if method != nil {
-		return method.Bind(i)
+		return method.Bind(i), nil
 	}
 
-	i.runtime.RuntimeError(errors.RuntimeError.New(name,
-		fmt.Sprintf("undefined property %q", name.Lexeme)))
+	return nil, errors.RuntimeError.New(name,
+		fmt.Sprintf("undefined property %q", name.Lexeme))
+}
+
+// thisEnvironment returns an environment enclosed by closure that binds "this"
+// to the instance. Nothing can assign to "this", so the environment is shared
+// by every call of a method with that closure.
+func (i *Instance) thisEnvironment(closure *Environment) *Environment {
+	for _, b := range i.bindings {
+		if b.closure == closure {
+			return b.environment
+		}
+	}
 
-	return nil
+	environment := NewLocalEnvironment(closure)
+	environment.Define("this", i)
+	i.bindings = append(i.bindings, binding{closure, environment})
+	return environment
 }
 
 func (i *Instance) Set(name *token.Token, value interface{}) {
@@ -2,11 +2,13 @@ package lox
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/mz1290/golox/internal/pkg/ast"
 	"github.com/mz1290/golox/internal/pkg/common"
 	"github.com/mz1290/golox/internal/pkg/errors"
 	"github.com/mz1290/golox/internal/pkg/token"
+	"github.com/mz1290/golox/internal/pkg/vm"
 )
 
 type Interpreter struct {
@@ -14,29 +16,111 @@ type Interpreter struct {
 	globals     *Environment
 	environment *Environment
 	locals      map[ast.Expr]int
+
+	// depth counts the calls in progress so runaway recursion is reported
+	// instead of exhausting the Go stack.
+	depth int
+
+	// When stepLimit is positive execution stops with errStepLimit once that
+	// many statements have run.
+	steps     int
+	stepLimit int
+
+	// hooks observe execution when installed. hookedError is the last error
+	// passed to OnError so statements it propagates through don't report it
+	// again.
+	hooks       Hooks
+	hookedError error
+
+	// sched lets the goroutines started with "spawn" take turns. Each runs
+	// on an interpreter forked from the script's, counting ticks to know
+	// when to yield.
+	sched     *scheduler
+	ticks     int
+	goroutine bool
+
+	// coroutine runs the body of a generator on i, if any
+	coroutine *coroutine
+
+	// outputs are where an entry of a served session writes, nil for the
+	// goroutines of the program
+	outputs *outputs
+
+	// interruption holds the error the code running on i fails with at the
+	// next iteration of a loop, if any
+	interruption atomic.Value
+}
+
+// interruption is stored in Interpreter.interruption, which can't hold nil.
+type interruption struct {
+	err error
 }
 
+// maxCallDepth matches the number of call frames the VM allows.
+const maxCallDepth = vm.FramesMax
+
+var errStepLimit = fmt.Errorf("step limit exceeded")
+
 func NewInterpreter(runtime *Lox) *Interpreter {
 
 	i := &Interpreter{
 		runtime: runtime,
 		globals: NewEnvironment(runtime),
 		locals:  make(map[ast.Expr]int),
+		sched:   &scheduler{},
 	}
 	i.environment = i.globals
 
 	i.globals.Define("clock", nativeFunctionClock{})
+	i.globals.Define("assert", nativeFunctionAssert{})
+	i.globals.Define("assertEqual", nativeFunctionAssertEqual{})
+	i.globals.Define("args", nativeFunctionArgs{})
+	i.globals.Define("range", nativeFunctionRange{})
+	i.globals.Define("Channel", nativeFunctionChannel{})
+	i.globals.Define("WaitGroup", nativeFunctionWaitGroup{})
+	i.globals.Define("Mutex", nativeFunctionMutex{})
+	i.globals.Define("select", nativeFunctionSelect{})
 	return i
 }
 
 func (i *Interpreter) Interpret(statements []ast.Stmt) {
+	i.hookedError = nil
+
+	// The entries of a served program have already taken their turn, and
+	// leave its goroutines running
+	if !i.sched.serving {
+		i.sched.begin(i)
+		defer i.sched.end()
+	}
+
 	for _, stmt := range statements {
 		_, err := i.execute(stmt)
-		if err != nil {
+		if err == errStopped {
+			break
+		} else if err != nil {
 			i.runtime.RuntimeError(err)
 			return
 		}
 	}
+
+	// A goroutine failed, stopping the program
+	if err := i.sched.failure; err != nil {
+		i.runtime.RuntimeError(err)
+	}
+}
+
+// fork returns an interpreter for a goroutine, sharing the globals of i.
+func (i *Interpreter) fork() *Interpreter {
+	return &Interpreter{
+		runtime:     i.runtime,
+		globals:     i.globals,
+		environment: i.globals,
+		locals:      i.locals,
+		stepLimit:   i.stepLimit,
+		hooks:       goroutineHooks(i.hooks),
+		sched:       i.sched,
+		goroutine:   true,
+	}
 }
 
 func (i *Interpreter) VisitLiteralExpr(expr *ast.Literal) (interface{}, error) {
@@ -49,14 +133,17 @@ func (i *Interpreter) VisitLogicalExpr(expr *ast.Logical) (interface{}, error) {
 		return nil, err
 	}
 
-	if expr.Operator.Type == token.OR {
-		if common.IsTruthy(left) {
-			return left, nil
-		}
-	} else {
-		if !common.IsTruthy(left) {
-			return left, nil
-		}
+	shortCircuit := common.IsTruthy(left)
+	if expr.Operator.Type != token.OR {
+		shortCircuit = !shortCircuit
+	}
+
+	if t, ok := i.hooks.(tool); ok {
+		t.branch(expr, shortCircuit)
+	}
+
+	if shortCircuit {
+		return left, nil
 	}
 
 	return i.evaluate(expr.Right)
@@ -78,8 +165,7 @@ func (i *Interpreter) VisitSetExpr(expr *ast.Set) (interface{}, error) {
 	// Evaluate the value being set
 	value, err := i.evaluate(expr.Value)
 	if err != nil {
-		return nil, errors.RuntimeError.New(expr.Name,
-			fmt.Sprintf("invalid assignment on %s instance", object.(*Instance)))
+		return nil, err
 	}
 
 	// Store evaluated value in instance
@@ -90,15 +176,10 @@ func (i *Interpreter) VisitSetExpr(expr *ast.Set) (interface{}, error) {
 }
 
 func (i *Interpreter) VisitSuperExpr(expr *ast.Super) (interface{}, error) {
-	// Get number of hops to superclass env
-	distance := i.locals[expr]
-
-	// Get the superclass
-	superclass := i.environment.GetAt(distance, "super").(*Class)
-
-	// A hacky way of arriving at the proper "this" for the superclass. The env
-	// where "this" is bound is always +1 from the env that stores "super".
-	object := i.environment.GetAt(distance-1, "this").(*Instance)
+	superclass, object, err := i.lookUpSuper(expr)
+	if err != nil {
+		return nil, err
+	}
 
 	// Look up method
 	method := superclass.FindMethod(expr.Method.Lexeme)
@@ -110,8 +191,30 @@ func (i *Interpreter) VisitSuperExpr(expr *ast.Super) (interface{}, error) {
 	return method.Bind(object), nil
 }
 
+func (i *Interpreter) lookUpSuper(expr *ast.Super) (*Class, *Instance, error) {
+	// Get number of hops to superclass env
+	distance := i.locals[expr]
+
+	// Get the superclass
+	superclass, ok := i.environment.GetAt(distance, "super").(*Class)
+	if !ok {
+		return nil, nil, errors.RuntimeError.New(expr.Keyword,
+			"superclass must be a class")
+	}
+
+	// A hacky way of arriving at the proper "this" for the superclass. The env
+	// where "this" is bound is always +1 from the env that stores "super".
+	object, ok := i.environment.GetAt(distance-1, "this").(*Instance)
+	if !ok {
+		return nil, nil, errors.RuntimeError.New(expr.Keyword,
+			"can't use \"super\" outside of a method")
+	}
+
+	return superclass, object, nil
+}
+
 func (i *Interpreter) VisitThisExpr(expr *ast.This) (interface{}, error) {
-	return i.lookUpVariable(expr.Keyword, expr), nil
+	return i.lookUpVariable(expr.Keyword, expr)
 }
 
 func (i *Interpreter) VisitGroupingExpr(expr *ast.Grouping) (interface{}, error) {
@@ -141,12 +244,12 @@ func (i *Interpreter) VisitUnaryExpr(expr *ast.Unary) (interface{}, error) {
 }
 
 func (i *Interpreter) VisitVariableExpr(expr *ast.Variable) (interface{}, error) {
-	return i.lookUpVariable(expr.Name, expr), nil
+	return i.lookUpVariable(expr.Name, expr)
 }
 
-func (i *Interpreter) lookUpVariable(name *token.Token, expr ast.Expr) interface{} {
+func (i *Interpreter) lookUpVariable(name *token.Token, expr ast.Expr) (interface{}, error) {
 	if distance, ok := i.locals[expr]; ok {
-		return i.environment.GetAt(distance, name.Lexeme)
+		return i.environment.GetAt(distance, name.Lexeme), nil
 	}
 
 	return i.globals.Get(name)
@@ -158,7 +261,86 @@ func (i *Interpreter) evaluate(expr ast.Expr) (interface{}, error) {
 }
 
 func (i *Interpreter) execute(stmt ast.Stmt) (interface{}, error) {
-	return stmt.Accept(i)
+	if i.stepLimit > 0 {
+		i.steps++
+		if i.steps > i.stepLimit {
+			return nil, errStepLimit
+		}
+	}
+
+	if i.sched.concurrent() {
+		if i.sched.stopped {
+			return nil, errStopped
+		}
+
+		// The turn passes after a statement rather than before it, so the
+		// body of a loop starts on the turn its condition was checked on
+		defer i.step()
+	}
+
+	if i.hooks == nil {
+		return stmt.Accept(i)
+	}
+
+	i.hooks.OnStatement(stmt)
+	value, err := stmt.Accept(i)
+	if t, ok := i.hooks.(tool); ok {
+		t.statementDone(stmt)
+	}
+
+	if err != nil && err != i.hookedError && err != errStopped &&
+		!IsReturnable(err) {
+		i.hookedError = err
+		i.hooks.OnError(err)
+	}
+
+	return value, err
+}
+
+// step counts a step of the goroutine running on i, letting the others take
+// a turn every yieldInterval steps.
+func (i *Interpreter) step() {
+	i.ticks++
+	if i.ticks%yieldInterval == 0 {
+		i.sched.yield()
+	}
+}
+
+// tick counts a step like step, and stops the goroutine running on i once
+// the program has ended or it has been interrupted.
+func (i *Interpreter) tick() error {
+	i.sched.reclaim()
+
+	if e, _ := i.interruption.Load().(interruption); e.err != nil {
+		return e.err
+	}
+
+	if i.sched.stopped {
+		return errStopped
+	} else if i.sched.concurrent() {
+		i.step()
+	}
+
+	return nil
+}
+
+// Interrupt makes the code running on i fail with err at the next iteration
+// of a loop, waking it if it is blocked. It may be called from any goroutine.
+// A nil err withdraws the interruption.
+func (i *Interpreter) Interrupt(err error) {
+	i.interruption.Store(interruption{err})
+	if err == nil {
+		return
+	}
+
+	i.sched.mu.Lock()
+	defer i.sched.mu.Unlock()
+
+	for _, w := range append([]*waiter(nil), i.sched.parked...) {
+		if w.owner == i {
+			i.sched.wake(w, err)
+		}
+	}
 }
 
 func (i *Interpreter) Resolve(expr ast.Expr, depth int) {
@@ -231,6 +413,10 @@ func (i *Interpreter) VisitClassStmt(stmt *ast.Class) (interface{}, error) {
 		sc = superclass.(*Class)
 	}
 	klass := NewClass(i.runtime, stmt.Name.Lexeme, sc, methods)
+	klass.line = stmt.Name.Line
+	for _, method := range methods {
+		method.class = klass
+	}
 
 	// If we updated our superclass environment, we need to revert back to
 	// previous environment.
@@ -239,9 +425,7 @@ func (i *Interpreter) VisitClassStmt(stmt *ast.Class) (interface{}, error) {
 	}
 
 	// Store the runtime oobject with previously declared env variable
-	i.environment.Assign(stmt.Name, klass)
-
-	return nil, nil
+	return nil, i.environment.Assign(stmt.Name, klass)
 }
 
 func (i *Interpreter) VisitBinaryExpr(expr *ast.Binary) (interface{}, error) {
@@ -322,20 +506,36 @@ func (i *Interpreter) VisitBinaryExpr(expr *ast.Binary) (interface{}, error) {
 }
 
 func (i *Interpreter) VisitCallExpr(expr *ast.Call) (interface{}, error) {
+	// Calling a method directly off an instance or "super" invokes it without
+	// creating a bound method first.
+	switch callee := expr.Callee.(type) {
+	case *ast.Get:
+		return i.invoke(expr, callee)
+	case *ast.Super:
+		return i.invokeSuper(expr, callee)
+	}
+
 	callee, err := i.evaluate(expr.Callee)
 	if err != nil {
 		return nil, err
 	}
 
-	var arguments []interface{}
-	for _, arg := range expr.Arguments {
-		argRes, err := i.evaluate(arg)
-		if err != nil {
-			return nil, err
-		}
-		arguments = append(arguments, argRes)
+	return i.call(expr, callee)
+}
+
+func (i *Interpreter) call(expr *ast.Call, callee interface{}) (interface{}, error) {
+	arguments, err := i.evaluateArguments(expr.Arguments)
+	if err != nil {
+		return nil, err
 	}
 
+	return i.callValue(expr, callee, arguments)
+}
+
+// variadic is the arity of natives taking any number of arguments.
+const variadic = vm.Variadic
+
+func (i *Interpreter) callValue(expr *ast.Call, callee interface{}, arguments []interface{}) (interface{}, error) {
 	// Confirm the object is indeed callable
 	if !IsCallable(callee) {
 		return nil, errors.RuntimeError.New(expr.Paren, "can only call functions "+
@@ -343,11 +543,138 @@ func (i *Interpreter) VisitCallExpr(expr *ast.Call) (interface{}, error) {
 	}
 
 	function := callee.(Callable)
-	if len(arguments) != function.Arity() {
+	if function.Arity() != variadic && len(arguments) != function.Arity() {
 		return nil, errors.RuntimeError.New(expr.Paren, fmt.Sprintf("expected %d "+
 			"arguments but got %d", function.Arity(), len(arguments)))
 	}
-	return function.Call(i, arguments)
+
+	if i.depth == maxCallDepth {
+		return nil, errors.RuntimeError.New(expr.Paren, "stack overflow")
+	}
+
+	i.depth++
+	defer func() { i.depth-- }()
+
+	if i.hooks != nil {
+		i.hooks.OnCall(expr, callee, arguments)
+	}
+
+	value, err := function.Call(i, arguments)
+
+	// Natives don't know where they were called from
+	if e, ok := err.(*errors.CustomErr); ok && e.Token == nil {
+		e.Token = expr.Paren
+	}
+
+	if i.hooks != nil {
+		i.hooks.OnReturn(expr, callee, value)
+	}
+
+	return value, err
+}
+
+func (i *Interpreter) invoke(expr *ast.Call, get *ast.Get) (interface{}, error) {
+	object, err := i.evaluate(get.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, ok := object.(*Instance)
+	if o, isObject := object.(common.Object); isObject {
+		method, err := objectMethod(o, get.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return i.call(expr, method)
+	} else if !ok {
+		return nil, errors.RuntimeError.New(get.Name, "only instances have "+
+			"properties")
+	}
+
+	// Fields shadow methods, so only take the fast path if there is no field
+	// with the same name.
+	method := instance.Klass.FindMethod(get.Name.Lexeme)
+	if _, isField := instance.Fields[get.Name.Lexeme]; isField || method == nil {
+		field, err := instance.Get(get.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return i.call(expr, field)
+	}
+
+	return i.callMethod(expr, method, instance)
+}
+
+func (i *Interpreter) invokeSuper(expr *ast.Call, super *ast.Super) (interface{}, error) {
+	superclass, object, err := i.lookUpSuper(super)
+	if err != nil {
+		return nil, err
+	}
+
+	method := superclass.FindMethod(super.Method.Lexeme)
+	if method == nil {
+		return nil, errors.RuntimeError.New(super.Method,
+			fmt.Sprintf("undefined property %q", super.Method.Lexeme))
+	}
+
+	return i.callMethod(expr, method, object)
+}
+
+func (i *Interpreter) callMethod(expr *ast.Call, method *Function, instance *Instance) (interface{}, error) {
+	arguments, err := i.evaluateArguments(expr.Arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(arguments) != method.Arity() {
+		return nil, errors.RuntimeError.New(expr.Paren, fmt.Sprintf("expected %d "+
+			"arguments but got %d", method.Arity(), len(arguments)))
+	}
+
+	if i.depth == maxCallDepth {
+		return nil, errors.RuntimeError.New(expr.Paren, "stack overflow")
+	}
+
+	i.depth++
+	defer func() { i.depth-- }()
+
+	if i.hooks == nil {
+		return method.callMethod(i, instance, arguments)
+	}
+
+	// Hooks see the method bound to its instance, as if it had been looked
+	// up before the call.
+	callee := method.Bind(instance)
+	i.hooks.OnCall(expr, callee, arguments)
+	value, err := method.callMethod(i, instance, arguments)
+	i.hooks.OnReturn(expr, callee, value)
+
+	return value, err
+}
+
+// callFrame describes a call to a Lox function, method or class for the tools
+// that follow calls, such as the profiler and the tracer.
+type callFrame struct {
+	key       interface{} // the declaration or class called
+	name      string      // qualified by the class for methods
+	class     string      // the class of a method or constructor
+	line      int         // where the callee is declared
+	arguments []interface{}
+}
+
+func (i *Interpreter) evaluateArguments(expressions []ast.Expr) ([]interface{}, error) {
+	var arguments []interface{}
+	for _, arg := range expressions {
+		argRes, err := i.evaluate(arg)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argRes)
+	}
+
+	return arguments, nil
 }
 
 func (i *Interpreter) VisitGetExpr(expr *ast.Get) (interface{}, error) {
@@ -357,13 +684,28 @@ func (i *Interpreter) VisitGetExpr(expr *ast.Get) (interface{}, error) {
 	}
 
 	if IsInstance(object) {
-		return object.(*Instance).Get(expr.Name), nil
+		return object.(*Instance).Get(expr.Name)
+	}
+
+	if o, ok := object.(common.Object); ok {
+		return objectMethod(o, expr.Name)
 	}
 
 	return nil, errors.RuntimeError.New(expr.Name, "only instances have "+
 		"properties")
 }
 
+// objectMethod looks up a method of a value implemented in Go.
+func objectMethod(o common.Object, name *token.Token) (interface{}, error) {
+	method, ok := o.Method(name.Lexeme)
+	if !ok {
+		return nil, errors.RuntimeError.New(name,
+			fmt.Sprintf("undefined property %q", name.Lexeme))
+	}
+
+	return nativeMethod{method}, nil
+}
+
 func (i *Interpreter) VisitExpressionStmt(stmt *ast.Expression) (interface{}, error) {
 	return i.evaluate(stmt.Expression)
 }
@@ -375,7 +717,14 @@ func (i *Interpreter) VisitFunctionStmt(stmt *ast.Function) (interface{}, error)
 }
 
 func (i *Interpreter) VisitIfStmt(stmt *ast.If) (interface{}, error) {
-	condition, _ := i.evaluate(stmt.Condition)
+	condition, err := i.evaluate(stmt.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := i.hooks.(tool); ok {
+		t.branch(stmt, common.IsTruthy(condition))
+	}
 
 	if common.IsTruthy(condition) {
 		return i.execute(stmt.ThenBranch)
@@ -392,7 +741,7 @@ func (i *Interpreter) VisitPrintStmt(stmt *ast.Print) (interface{}, error) {
 		return nil, err
 	}
 
-	fmt.Println(common.Stringfy(value))
+	fmt.Fprintln(i.runtime.out, common.Stringfy(value))
 	return nil, nil
 }
 
@@ -411,6 +760,51 @@ func (i *Interpreter) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
 	return nil, NewReturn(value)
 }
 
+// VisitSpawnStmt evaluates the callee and arguments of the call, then runs it
+// on a new goroutine.
+func (i *Interpreter) VisitSpawnStmt(stmt *ast.Spawn) (interface{}, error) {
+	// Methods are looked up now, bound to their instance
+	callee, err := i.evaluate(stmt.Call.Callee)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments, err := i.evaluateArguments(stmt.Call.Arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	goroutine := i.fork()
+	i.sched.spawn(goroutine, func() error {
+		_, err := goroutine.callValue(stmt.Call, callee, arguments)
+		return err
+	})
+
+	return nil, nil
+}
+
+// VisitYieldStmt passes a value to the caller of the generator running on i
+// and waits for the next to be asked for.
+func (i *Interpreter) VisitYieldStmt(stmt *ast.Yield) (interface{}, error) {
+	var value interface{}
+
+	if stmt.Value != nil {
+		var err error
+
+		value, err = i.evaluate(stmt.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, i.coroutine.yield(value)
+}
+
+// Tests are ignored unless run by RunTests.
+func (i *Interpreter) VisitTestStmt(stmt *ast.Test) (interface{}, error) {
+	return nil, nil
+}
+
 func (i *Interpreter) VisitVarStmt(stmt *ast.Var) (interface{}, error) {
 	var value interface{}
 	var err error
@@ -428,11 +822,20 @@ func (i *Interpreter) VisitVarStmt(stmt *ast.Var) (interface{}, error) {
 
 func (i *Interpreter) VisitWhileStmt(stmt *ast.While) (interface{}, error) {
 	for {
+		// A loop with an empty body still takes turns
+		if err := i.tick(); err != nil {
+			return nil, err
+		}
+
 		condition, err := i.evaluate(stmt.Condition)
 		if err != nil {
 			return nil, err
 		}
 
+		if t, ok := i.hooks.(tool); ok {
+			t.branch(stmt, common.IsTruthy(condition))
+		}
+
 		if common.IsTruthy(condition) {
 			_, err := i.execute(stmt.Body)
 			if err != nil {
@@ -454,8 +857,8 @@ func (i *Interpreter) VisitAssignExpr(expr *ast.Assign) (interface{}, error) {
 
 	if distance, ok := i.locals[expr]; ok {
 		i.environment.AssignAt(distance, expr.Name, value)
-	} else {
-		i.globals.Assign(expr.Name, value)
+	} else if err := i.globals.Assign(expr.Name, value); err != nil {
+		return nil, err
 	}
 
 	return value, nil
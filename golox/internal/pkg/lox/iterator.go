@@ -0,0 +1,202 @@
+package lox
+
+import (
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/errors"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// Values that produce a sequence of values one at a time follow the iterator
+// protocol. An iterator has a hasNext() method, reporting whether it has a
+// value left, and a next() method returning that value. An iterable has an
+// iterator() method returning a new iterator over its values. Instances of
+// any class defining those methods take part, as do generators, which are
+// iterators that return themselves as their iterator. Lists, strings and
+// ranges are iterable too.
+
+// iterator produces the values a for-in loop runs over.
+type iterator interface {
+	hasNext() (bool, error)
+	next() (interface{}, error)
+}
+
+// builtinIterator iterates over a list, string or range.
+type builtinIterator struct {
+	it *common.Iterator
+}
+
+func (b builtinIterator) hasNext() (bool, error) {
+	return b.it.HasNext(), nil
+}
+
+func (b builtinIterator) next() (interface{}, error) {
+	return b.it.Next()
+}
+
+// instanceIterator calls the hasNext and next methods of an iterator
+// implemented in Lox.
+type instanceIterator struct {
+	interpreter *Interpreter
+	keyword     *token.Token // the loop, which errors are reported at
+	hasNextFn   Callable
+	nextFn      Callable
+}
+
+func (it instanceIterator) hasNext() (bool, error) {
+	more, err := it.interpreter.callProtocol(it.keyword, it.hasNextFn)
+	if err != nil {
+		return false, err
+	}
+
+	return common.IsTruthy(more), nil
+}
+
+func (it instanceIterator) next() (interface{}, error) {
+	return it.interpreter.callProtocol(it.keyword, it.nextFn)
+}
+
+// iteratorOf returns an iterator over the values of iterable for the for-in
+// loop at keyword.
+func (i *Interpreter) iteratorOf(keyword *token.Token, iterable interface{}) (iterator, error) {
+	if it, ok := common.Iterate(iterable); ok {
+		return builtinIterator{it}, nil
+	} else if g, ok := iterable.(*Generator); ok {
+		return g, nil
+	}
+
+	method, ok := protocolMethod(iterable, "iterator")
+	if !ok {
+		return nil, errors.RuntimeError.New(keyword, "can only iterate over "+
+			"lists, strings, ranges and iterables")
+	}
+
+	value, err := i.callProtocol(keyword, method)
+	if err != nil {
+		return nil, err
+	}
+
+	if it, ok := common.Iterate(value); ok {
+		return builtinIterator{it}, nil
+	} else if g, ok := value.(*Generator); ok {
+		return g, nil
+	}
+
+	hasNext, okHasNext := protocolMethod(value, "hasNext")
+	next, okNext := protocolMethod(value, "next")
+	if !okHasNext || !okNext {
+		return nil, errors.RuntimeError.New(keyword, "iterator() must return "+
+			"a value with hasNext and next methods")
+	}
+
+	return instanceIterator{i, keyword, hasNext, next}, nil
+}
+
+// protocolMethod returns the method called name of an instance or a value
+// implemented in Go, if it has one.
+func protocolMethod(value interface{}, name string) (Callable, bool) {
+	switch v := value.(type) {
+	case *Instance:
+		// Fields shadow methods
+		if field, ok := v.Fields[name]; ok {
+			callable, ok := field.(Callable)
+			return callable, ok
+		}
+
+		if method := v.Klass.FindMethod(name); method != nil {
+			return method.Bind(v), true
+		}
+	case common.Object:
+		if method, ok := v.Method(name); ok {
+			return nativeMethod{method}, true
+		}
+	}
+
+	return nil, false
+}
+
+// callProtocol calls a method of the iterator protocol, which takes no
+// arguments, for the for-in loop at keyword.
+func (i *Interpreter) callProtocol(keyword *token.Token, method Callable) (interface{}, error) {
+	if method.Arity() != 0 {
+		return nil, errors.RuntimeError.New(keyword, "iterator methods must "+
+			"take no arguments")
+	}
+
+	if i.depth == maxCallDepth {
+		return nil, errors.RuntimeError.New(keyword, "stack overflow")
+	}
+
+	i.depth++
+	defer func() { i.depth-- }()
+
+	value, err := method.Call(i, nil)
+
+	// Natives don't know where they were called from
+	if e, ok := err.(*errors.CustomErr); ok && e.Token == nil {
+		e.Token = keyword
+	}
+
+	return value, err
+}
+
+// VisitForInStmt runs the body once for each value of an iterable. Each
+// iteration binds the loop variable afresh, so closures created by the body
+// capture the value of their own iteration.
+func (i *Interpreter) VisitForInStmt(stmt *ast.ForIn) (interface{}, error) {
+	iterable, err := i.evaluate(stmt.Iterable)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := i.iteratorOf(stmt.Keyword, iterable)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []ast.Stmt{stmt.Body}
+	for {
+		// A loop with an empty body still takes turns
+		if err := i.tick(); err != nil {
+			return nil, err
+		}
+
+		more, err := it.hasNext()
+		if err != nil {
+			return nil, i.atLoop(stmt, err)
+		}
+
+		if t, ok := i.hooks.(tool); ok {
+			t.branch(stmt, more)
+		}
+
+		if !more {
+			return nil, nil
+		}
+
+		value, err := it.next()
+		if err != nil {
+			return nil, i.atLoop(stmt, err)
+		}
+
+		environment := NewLocalEnvironment(i.environment)
+		environment.Define(stmt.Name.Lexeme, value)
+		if _, err := i.executeBlock(body, environment); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// atLoop reports an error raised in Go by an iterator at the loop using it.
+func (i *Interpreter) atLoop(stmt *ast.ForIn, err error) error {
+	if e, ok := err.(*errors.CustomErr); ok {
+		if e.Token == nil {
+			e.Token = stmt.Keyword
+		}
+		return e
+	} else if err == errStopped {
+		return err
+	}
+
+	return errors.RuntimeError.New(stmt.Keyword, err.Error())
+}
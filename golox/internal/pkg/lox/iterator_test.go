@@ -0,0 +1,19 @@
+package lox
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The scripts under test/for_in cover the other iterables. A list only comes
+// from the arguments of a script, which the suite doesn't pass.
+func TestForInList(t *testing.T) {
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+		l.Args = []string{"a", "b"}
+
+		checkRun(t, fmt.Sprintf("engine %d", engine), l,
+			[]string{`for (a in args()) print a;`}, "a\nb\n", "")
+	}
+}
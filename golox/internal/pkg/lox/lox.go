@@ -5,122 +5,480 @@ package lox
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 
+	"github.com/mz1290/golox/internal/pkg/ast"
 	"github.com/mz1290/golox/internal/pkg/common"
 	"github.com/mz1290/golox/internal/pkg/errors"
 	"github.com/mz1290/golox/internal/pkg/token"
+	"github.com/mz1290/golox/internal/pkg/vm"
 )
 
+// Engine selects how resolved programs are executed.
+type Engine byte
+
+const (
+	ENGINE_TREE Engine = iota // walk the syntax tree directly
+	ENGINE_VM                 // compile to bytecode and run on the stack VM
+)
+
+func ParseEngine(name string) (Engine, error) {
+	switch strings.ToLower(name) {
+	case "", "tree":
+		return ENGINE_TREE, nil
+	case "vm":
+		return ENGINE_VM, nil
+	}
+
+	return ENGINE_TREE, fmt.Errorf("unknown engine %q", name)
+}
+
+// Lox runs programs. Instances share no state, so separate instances can run
+// on separate goroutines, but each must only be used by one at a time.
 type Lox struct {
 	HadError        bool // represents syntax/static errors
 	HadRuntimeError bool // errors during execution
 	Interpreter     *Interpreter
-	Debug           int
+	VM              *vm.VM
+	Engine          Engine
+	Optimize        bool // run the AST optimizer after resolving
+
+	// Debug selects the stages that print their workings as they run
+	Debug common.Debug
+
+	// Args are the arguments the script was run with, returned by args()
+	Args []string
+
+	// When Coverage is set the tree-walk interpreter counts the lines and
+	// branches that run. The optimizer is skipped so the report covers the
+	// program as written.
+	Coverage *Coverage
+
+	// When Profile is set the tree-walk interpreter measures the time spent
+	// on each line of each Lox function run by RunFile.
+	Profile *Profile
+
+	// When Trace is set the tree-walk interpreter records every call made
+	// by RunFile.
+	Trace *Trace
+
+	// path names the script being run in reports, empty for the REPL
+	path string
+
+	// toolsInstalled is set once Coverage, Profile and Trace are installed
+	// as hooks of the interpreter
+	toolsInstalled bool
+
+	// Script output is buffered and flushed at exit, before any error is
+	// reported and before each REPL prompt.
+	out    *bufio.Writer
+	errOut io.Writer
 }
 
 func New() *Lox {
 	l := &Lox{
 		HadError:        false,
 		HadRuntimeError: false,
+		Optimize:        true,
+		out:             bufio.NewWriter(os.Stdout),
+		errOut:          os.Stderr,
 	}
 
 	l.Interpreter = NewInterpreter(l)
+	l.VM = l.newVM()
 	return l
 }
 
-// Read and execute file
+// newVM returns a VM with the natives that depend on l, such as args.
+func (l *Lox) newVM() *vm.VM {
+	machine := vm.New(l)
+	machine.DefineNative("args", 0, func([]interface{}) (interface{}, error) {
+		return common.NewStringList(l.Args), nil
+	})
+
+	return machine
+}
+
+// Debugging reports whether the debug category is enabled.
+func (l *Lox) Debugging(category common.Debug) bool {
+	return l.Debug&category != 0
+}
+
+// SetOutput redirects script output, such as print statements, to w.
+func (l *Lox) SetOutput(w io.Writer) {
+	l.Flush()
+	l.out = bufio.NewWriter(w)
+}
+
+// SetErrorOutput redirects error reports to w.
+func (l *Lox) SetErrorOutput(w io.Writer) {
+	l.errOut = w
+}
+
+// Output returns the writer script output is written to.
+func (l *Lox) Output() io.Writer {
+	return l.out
+}
+
+// Flush writes any buffered script output.
+func (l *Lox) Flush() {
+	l.out.Flush()
+}
+
+func (l *Lox) exit(code int) {
+	l.finish()
+	os.Exit(code)
+}
+
+// finish flushes output and writes the coverage, profile and trace reports
+// that are enabled.
+func (l *Lox) finish() {
+	l.Flush()
+
+	if l.Coverage != nil {
+		if err := l.Coverage.Write(l.errOut); err != nil {
+			fmt.Fprintln(l.errOut, err)
+		}
+	}
+
+	if l.Profile != nil {
+		if err := l.Profile.Write(l.errOut); err != nil {
+			fmt.Fprintln(l.errOut, err)
+		}
+	}
+
+	if l.Trace != nil {
+		if err := l.Trace.Close(); err != nil {
+			fmt.Fprintln(l.errOut, err)
+		}
+	}
+}
+
+// Run executes source and flushes its output. Unlike RunFile it never exits
+// the process, so callers inspect HadError and HadRuntimeError instead.
+func (l *Lox) Run(source string) {
+	l.run(source)
+	l.Flush()
+}
+
+// Check scans, parses and resolves source, and compiles it when the engine
+// is the VM, reporting static errors without running anything.
+func (l *Lox) Check(source string) {
+	statements := l.parse(source)
+	if !l.HadError && l.Engine == ENGINE_VM {
+		vm.NewCompiler(l).Compile(statements)
+	}
+}
+
+// Read and execute file. Files produced by BuildFile are run directly on the
+// VM without being scanned, parsed or resolved again.
 func (l *Lox) RunFile(path string) {
+	l.RunFiles(path)
+}
+
+// RunFiles runs scripts as one program: they share the global environment
+// and run in the order given, the first runtime error ending the program.
+// Nothing runs if any of them has a static error. Errors name the file they
+// are in when there is more than one.
+func (l *Lox) RunFiles(paths ...string) {
+	sources := make([]string, len(paths))
+	for n, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(l.errOut, err)
+			l.exit(66)
+		}
+
+		if vm.IsCompiled(data) && len(paths) > 1 {
+			fmt.Fprintf(l.errOut, "%s: compiled files can only be run on "+
+				"their own\n", path)
+			l.exit(65)
+		}
+		sources[n] = string(data)
+	}
+	l.path = paths[0]
+
+	if l.Profile != nil {
+		l.Profile.begin(l.path)
+	}
+
+	if l.Trace != nil {
+		l.Trace.begin(l.path)
+	}
+
+	if len(paths) > 1 {
+		statements := l.parseFiles(paths, sources)
+		if !l.HadError {
+			l.execute(statements)
+		}
+	} else if data := []byte(sources[0]); vm.IsCompiled(data) {
+		l.runCompiled(l.path, data)
+	} else {
+		l.run(sources[0])
+	}
+
+	if l.HadError {
+		l.exit(65)
+	} else if l.HadRuntimeError {
+		l.exit(70)
+	}
+	l.finish()
+}
+
+func (l *Lox) runCompiled(path string, data []byte) {
+	function, err := vm.Decode(data)
+	if err != nil {
+		fmt.Fprintf(l.errOut, "%s: %s\n", path, err)
+		l.exit(65)
+	}
+
+	l.VM.Interpret(function)
+}
+
+// BuildFile compiles the script at path to bytecode and writes it to output.
+func (l *Lox) BuildFile(path, output string) {
 	data, err := os.ReadFile(path)
 	if err != nil {
+		fmt.Fprintln(l.errOut, err)
+		os.Exit(66)
+	}
+
+	statements := l.parse(string(data))
+	if l.HadError {
 		os.Exit(65)
 	}
 
-	l.run(string(data))
+	function := vm.NewCompiler(l).Compile(statements)
 	if l.HadError {
 		os.Exit(65)
-	} else if l.HadRuntimeError {
-		os.Exit(70)
+	}
+
+	// Encoding first means a failure can't leave a partial file behind
+	var encoded bytes.Buffer
+	if err := vm.Encode(&encoded, function); err != nil {
+		fmt.Fprintln(l.errOut, err)
+		os.Exit(74)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintln(l.errOut, err)
+		os.Exit(73)
+	}
+
+	_, err = file.Write(encoded.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Remove what was written, unless output is a device or pipe
+		if info, statErr := os.Stat(output); statErr == nil && info.Mode().IsRegular() {
+			os.Remove(output)
+		}
+		fmt.Fprintln(l.errOut, err)
+		os.Exit(74)
 	}
 }
 
-// Start interactive golox prompt
-func (l *Lox) RunPrompt() {
-	reader := bufio.NewReader(os.Stdin)
+func (l *Lox) run(source string) {
+	statements := l.parse(source)
 
-	for {
-		fmt.Printf("> ")
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			os.Exit(65)
+	// Stop if there was a syntax or semantic error
+	if l.HadError {
+		return
+	}
+
+	l.execute(statements)
+}
+
+// execute runs a resolved program on the selected engine.
+func (l *Lox) execute(statements []ast.Stmt) {
+	if l.Engine == ENGINE_VM {
+		// Compile to bytecode, stopping if a limit of the format was hit
+		function := vm.NewCompiler(l).Compile(statements)
+		if l.HadError {
+			return
 		}
 
-		// Check if user signaled end of session
-		if line == "exit\n" {
-			break
+		l.VM.Interpret(function)
+		return
+	}
+
+	// Execute/evaluate expression
+	l.installTools()
+	l.Interpreter.Interpret(statements)
+}
+
+// installTools installs the enabled coverage report, profiler and tracer as
+// hooks of the tree-walk interpreter, the first time a program runs.
+func (l *Lox) installTools() {
+	if l.toolsInstalled {
+		return
+	}
+	l.toolsInstalled = true
+
+	if l.Coverage != nil {
+		l.Interpreter.AddHooks(l.Coverage)
+	}
+
+	if l.Profile != nil {
+		l.Interpreter.AddHooks(l.Profile)
+	}
+
+	if l.Trace != nil {
+		l.Interpreter.AddHooks(l.Trace)
+	}
+}
+
+// parse scans, parses and resolves source, returning the resolved program.
+// Callers must check HadError before using the result.
+func (l *Lox) parse(source string) []ast.Stmt {
+	statements := l.syntax("", source)
+
+	// Stop if there was a syntax error
+	if l.HadError {
+		return nil
+	}
+
+	statements = l.resolve(statements)
+	if !l.HadError && l.Coverage != nil {
+		l.Coverage.register(l.path, statements)
+	}
+
+	return statements
+}
+
+// parseFiles scans and parses each of sources, read from paths, on its own
+// goroutine, then resolves them together as one program in the order given.
+// Errors are reported in that order too, naming the file they are in.
+func (l *Lox) parseFiles(paths, sources []string) []ast.Stmt {
+	type file struct {
+		statements []ast.Stmt
+		output     strings.Builder // tokens printed when debugging
+		errors     strings.Builder
+		hadError   bool
+	}
+
+	files := make([]file, len(sources))
+	var wg sync.WaitGroup
+	for n := range sources {
+		wg.Add(1)
+		go func(f *file, path, source string) {
+			defer wg.Done()
+
+			// Each file reports to a Lox of its own, collecting its errors
+			reporter := &Lox{
+				Debug:  l.Debug,
+				out:    bufio.NewWriter(&f.output),
+				errOut: &f.errors,
+			}
+			f.statements = reporter.syntax(path, source)
+			reporter.Flush()
+			f.hadError = reporter.HadError
+		}(&files[n], paths[n], sources[n])
+	}
+	wg.Wait()
+
+	var statements []ast.Stmt
+	for n := range files {
+		f := &files[n]
+		io.WriteString(l.out, f.output.String())
+		if f.hadError {
+			l.Flush()
+			io.WriteString(l.errOut, f.errors.String())
+			l.HadError = true
 		}
+		statements = append(statements, f.statements...)
+	}
+
+	// Stop if there was a syntax error
+	if l.HadError {
+		return nil
+	}
 
-		// Execute user lox statement or expression
-		l.run(line)
-		l.HadError = false
-		l.HadRuntimeError = false
+	statements = l.resolve(statements)
+	if !l.HadError && l.Coverage != nil {
+		for n, path := range paths {
+			l.Coverage.register(path, files[n].statements)
+		}
 	}
+
+	return statements
 }
 
-func (l *Lox) run(source string) {
+// syntax scans and parses source, naming file, if it isn't empty, in its
+// tokens.
+func (l *Lox) syntax(file, source string) []ast.Stmt {
 	// create a new scanner instance
 	s := NewScanner(l, source)
+	s.file = file
 	tokens := s.ScanTokens()
 
-	if (common.DEBUGLOX & common.SCANNING) != 0 {
+	if l.Debugging(common.SCANNING) {
 		for _, token := range tokens {
-			fmt.Println(token)
+			fmt.Fprintln(l.out, token)
 		}
 	}
 
 	// create new parser instance
 	parser := NewParser(l, tokens)
-	statements := parser.Parse()
-
-	// Stop if there was a syntax error
-	if l.HadError {
-		return
-	}
+	return parser.Parse()
+}
 
+// resolve finds the variable bindings of a parsed program and then, unless
+// disabled, optimizes it.
+func (l *Lox) resolve(statements []ast.Stmt) []ast.Stmt {
 	// Run the resolver to find variable bindings
 	resolver := NewResolver(l, l.Interpreter)
 	resolver.Resolve(statements)
 
-	// Stop if there was a semantic error
-	if l.HadError {
-		return
+	// Simplify the resolved tree unless disabled with -O0
+	if !l.HadError && l.Optimize && l.Coverage == nil {
+		optimizer := NewOptimizer(l, l.Interpreter)
+		statements = optimizer.Optimize(statements)
 	}
 
-	// Execute/evaluate expression
-	l.Interpreter.Interpret(statements)
+	return statements
 }
 
 //ErrorMessage prints error message as stderr
 func (l *Lox) ErrorMessage(line int, message string) {
-	l.report(line, "", message)
+	l.report("", line, "", message)
 }
 
 func (l *Lox) ErrorTokenMessage(t *token.Token, message string) {
 	if t.Type == token.EOF {
-		l.report(t.Line, " at end", message)
+		l.report(t.File, t.Line, " at end", message)
 	} else {
-		l.report(t.Line, fmt.Sprintf(" at %q", t.Lexeme), message)
+		l.report(t.File, t.Line, fmt.Sprintf(" at %q", t.Lexeme), message)
 	}
 }
 
-func (l *Lox) report(line int, where string, message string) {
-	fmt.Fprintf(os.Stderr, "[line %d] error%s: %s\n", line, where, message)
+func (l *Lox) report(file string, line int, where string, message string) {
+	l.Flush()
+	fmt.Fprintf(l.errOut, "[%s] error%s: %s\n", token.Position(file, line),
+		where, message)
 	l.HadError = true
 }
 
 func (l *Lox) RuntimeError(err error) {
-	e := err.(*errors.CustomErr)
-	fmt.Fprintf(os.Stderr, "[line %d] %s\n", e.Token.Line, err)
+	l.Flush()
+	fmt.Fprintln(l.errOut, RuntimeErrorMessage(err))
 	l.HadRuntimeError = true
 }
+
+// RuntimeErrorMessage formats err the way runtime errors are reported.
+func RuntimeErrorMessage(err error) string {
+	// Errors that don't come from a token, such as running out of steps,
+	// have no line to report.
+	if e, ok := err.(*errors.CustomErr); ok && e.Token != nil {
+		return fmt.Sprintf("[%s] %s", e.Token.Position(), err)
+	}
+
+	return fmt.Sprintf("RuntimeError: %s", err)
+}
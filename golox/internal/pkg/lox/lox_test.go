@@ -0,0 +1,242 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+)
+
+func TestArgs(t *testing.T) {
+	source := strings.Join([]string{
+		`#!/usr/bin/env golox`,
+		`var a = args();`,
+		`print a;`,
+		`print a.length();`,
+		`print a.get(1);`,
+		`print a.get(2);`,
+	}, "\n")
+
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+		l.Args = []string{"a", "b"}
+
+		var out, errOut strings.Builder
+		l.SetOutput(&out)
+		l.SetErrorOutput(&errOut)
+		l.Run(source)
+
+		if want := "[a, b]\n2\nb\n"; out.String() != want {
+			t.Errorf("engine %d: got output %q, want %q", engine, out.String(), want)
+		}
+
+		if !strings.Contains(errOut.String(), "list index 2 out of range") {
+			t.Errorf("engine %d: got errors %q", engine, errOut.String())
+		}
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	paths := []string{"a.lox", "b.lox", "main.lox"}
+	sources := []string{
+		"var greeting = \"hi\";\nfun greet(name) { return greeting + \" \" + name; }\n",
+		"fun fail() {\n  return -greeting;\n}\n",
+		"print greet(\"lox\");\nfail();\n",
+	}
+
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+
+		var out, errOut strings.Builder
+		l.SetOutput(&out)
+		l.SetErrorOutput(&errOut)
+
+		l.execute(l.parseFiles(paths, sources))
+		l.Flush()
+
+		if want := "hi lox\n"; out.String() != want {
+			t.Errorf("engine %d: got output %q, want %q", engine, out.String(), want)
+		}
+
+		if want := "[b.lox:2] RuntimeError: operand must be a number\n"; errOut.String() != want {
+			t.Errorf("engine %d: got errors %q, want %q", engine, errOut.String(), want)
+		}
+	}
+
+	// Static errors are reported in the order of the files
+	l := New()
+	var errOut strings.Builder
+	l.SetErrorOutput(&errOut)
+	l.parseFiles(paths, []string{"var a = ;", sources[1], "print (;"})
+
+	want := "[a.lox:1] error at \";\": expected expression\n" +
+		"[main.lox:1] error at \";\": expected expression\n" +
+		"[main.lox:1] error at \";\": expected \")\" after expression\n"
+	if !l.HadError || errOut.String() != want {
+		t.Errorf("got errors %q, want %q", errOut.String(), want)
+	}
+}
+
+// Instances keep their settings to themselves, so those running with debug
+// output don't affect the others running alongside them.
+func TestConcurrentInstances(t *testing.T) {
+	source := strings.Join([]string{
+		`class Counter {`,
+		`  init() { this.n = 0; }`,
+		`  inc() { this.n = this.n + 1; return this.n; }`,
+		`}`,
+		`fun count(times) {`,
+		`  var c = Counter();`,
+		`  for (var i = 0; i < times; i = i + 1) c.inc();`,
+		`  return c.n;`,
+		`}`,
+		`print count(100);`,
+	}, "\n")
+
+	const instances = 200
+
+	outputs := make([]string, instances)
+	var wg sync.WaitGroup
+	for n := 0; n < instances; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+
+			l := New()
+			l.Engine = Engine(n % 2)
+			if n%4 < 2 {
+				l.Debug = common.SCANNING | common.BYTECODE
+			}
+
+			var out strings.Builder
+			l.SetOutput(&out)
+			l.Run(source)
+			outputs[n] = out.String()
+		}(n)
+	}
+	wg.Wait()
+
+	for n, out := range outputs {
+		if n%4 < 2 {
+			if !strings.HasSuffix(out, "\n100\n") || !strings.Contains(out, "IDENTIFIER") {
+				t.Errorf("instance %d with debug output: got %q", n, out)
+			}
+		} else if out != "100\n" {
+			t.Errorf("instance %d: got %q, want %q", n, out, "100\n")
+		}
+	}
+}
+
+// Output is buffered, so it must be flushed before each error is reported
+// for the two to appear in the order they happened.
+// checkRun runs source on l and fails the test, naming it after name, unless
+// it writes exactly out to its output and errOut to its error output.
+func checkRun(t *testing.T, name string, l *Lox, source []string, out,
+	errOut string) {
+	t.Helper()
+
+	var gotOut, gotErr strings.Builder
+	l.SetOutput(&gotOut)
+	l.SetErrorOutput(&gotErr)
+	l.Run(strings.Join(source, "\n"))
+
+	if gotOut.String() != out || gotErr.String() != errOut {
+		t.Errorf("%s: got output %q and errors %q, want %q and %q", name,
+			gotOut.String(), gotErr.String(), out, errOut)
+	}
+}
+
+func TestOutputOrder(t *testing.T) {
+	source := strings.Join([]string{
+		`print "a";`,
+		`fun f() { print "b"; return -"c"; }`,
+		`f();`,
+		`print "d";`,
+	}, "\n")
+
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+
+		var both strings.Builder
+		l.SetOutput(&both)
+		l.SetErrorOutput(&both)
+		l.Run(source)
+
+		want := "a\nb\n[line 2] RuntimeError: operand must be a number\n"
+		if both.String() != want {
+			t.Errorf("engine %d: got %q, want %q", engine, both.String(), want)
+		}
+	}
+}
+
+// runFile runs RunFiles on paths in a child process, since it exits the
+// process, and returns what it wrote to stdout and stderr, interleaved, and
+// its exit code.
+func runFile(t *testing.T, paths ...string) (string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "GOLOX_RUN_FILES="+strings.Join(paths,
+		string(os.PathListSeparator)))
+
+	var both bytes.Buffer
+	cmd.Stdout = &both
+	cmd.Stderr = &both
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return both.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return both.String(), 0
+}
+
+// runFilesChild runs RunFiles in the child process started by runFile.
+func runFilesChild() {
+	if paths := os.Getenv("GOLOX_RUN_FILES"); paths != "" {
+		New().RunFiles(filepath.SplitList(paths)...)
+		os.Exit(0)
+	}
+}
+
+func TestRunFilesExit(t *testing.T) {
+	runFilesChild()
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.lox")
+	source := "print \"before\";\nprint -\"a\";\nprint \"after\";\n"
+	if err := os.WriteFile(script, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output written before the error survives the exit, ahead of the error
+	out, code := runFile(t, script)
+	want := "before\n[line 2] RuntimeError: operand must be a number\n"
+	if out != want || code != 70 {
+		t.Errorf("got %q and exit code %d, want %q and 70", out, code, want)
+	}
+}
+
+func TestRunFilesMissing(t *testing.T) {
+	runFilesChild()
+
+	missing := filepath.Join(t.TempDir(), "missing.lox")
+	out, code := runFile(t, missing)
+
+	want := "open " + missing + ": no such file or directory\n"
+	if out != want || code != 66 {
+		t.Errorf("got %q and exit code %d, want %q and 66", out, code, want)
+	}
+}
@@ -0,0 +1,452 @@
+package lox
+
+import (
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// The optimizer runs after the resolver and rewrites the syntax tree before
+// it is executed. It only performs transformations that don't change what a
+// program prints or the errors it reports, which optimizer_test.go checks by
+// running programs with and without it:
+// - arithmetic, comparison and equality on literal operands are folded
+// - string concatenation of two literals is folded
+// - "!" and unary "-" of a literal are folded
+// - "and"/"or" with a literal left operand are short-circuited at compile time
+// - branches of an "if" or "while" that can never run are dropped
+// - statements following a "return" in the same block are dropped
+//
+// Operations that would produce a runtime error, such as adding a number to a
+// string, are left in place so the error is still reported at runtime.
+//
+// Every visit method returns the node that should replace the one visited.
+// Statement visitors may return nil to remove the statement altogether.
+// Nodes are updated in place wherever possible so the interpreter's locals map,
+// which is keyed by node, stays valid. Entries for nodes that are dropped are
+// removed from the map.
+type Optimizer struct {
+	runtime     *Lox
+	interpreter *Interpreter
+}
+
+func NewOptimizer(l *Lox, i *Interpreter) *Optimizer {
+	return &Optimizer{
+		runtime:     l,
+		interpreter: i,
+	}
+}
+
+func (o *Optimizer) Optimize(statements []ast.Stmt) []ast.Stmt {
+	return o.optimizeStatements(statements)
+}
+
+func (o *Optimizer) optimizeStatements(statements []ast.Stmt) []ast.Stmt {
+	optimized := statements[:0]
+
+	for i, stmt := range statements {
+		stmt = o.optimizeStatement(stmt)
+		if stmt == nil {
+			continue
+		}
+		optimized = append(optimized, stmt)
+
+		// Nothing after a return in the same block can ever execute
+		if _, ok := stmt.(*ast.Return); ok {
+			for _, unreachable := range statements[i+1:] {
+				o.discardStatement(unreachable)
+			}
+			break
+		}
+	}
+
+	return optimized
+}
+
+func (o *Optimizer) optimizeStatement(stmt ast.Stmt) ast.Stmt {
+	res, _ := stmt.Accept(o)
+	if res == nil {
+		return nil
+	}
+
+	return res.(ast.Stmt)
+}
+
+func (o *Optimizer) optimizeExpression(expr ast.Expr) ast.Expr {
+	res, _ := expr.Accept(o)
+	return res.(ast.Expr)
+}
+
+func literalOf(expr ast.Expr) (*ast.Literal, bool) {
+	literal, ok := expr.(*ast.Literal)
+	return literal, ok
+}
+
+func (o *Optimizer) VisitBlockStmt(stmt *ast.Block) (interface{}, error) {
+	stmt.Statements = o.optimizeStatements(stmt.Statements)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitClassStmt(stmt *ast.Class) (interface{}, error) {
+	for _, method := range stmt.Methods {
+		method.Body = o.optimizeStatements(method.Body)
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitExpressionStmt(stmt *ast.Expression) (interface{}, error) {
+	stmt.Expression = o.optimizeExpression(stmt.Expression)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitFunctionStmt(stmt *ast.Function) (interface{}, error) {
+	stmt.Body = o.optimizeStatements(stmt.Body)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitTestStmt(stmt *ast.Test) (interface{}, error) {
+	stmt.Body = o.optimizeStatements(stmt.Body)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitIfStmt(stmt *ast.If) (interface{}, error) {
+	stmt.Condition = o.optimizeExpression(stmt.Condition)
+
+	literal, ok := literalOf(stmt.Condition)
+	if !ok {
+		stmt.ThenBranch = o.optimizeStatement(stmt.ThenBranch)
+		if stmt.ThenBranch == nil {
+			stmt.ThenBranch = &ast.Block{}
+		}
+
+		if stmt.ElseBranch != nil {
+			stmt.ElseBranch = o.optimizeStatement(stmt.ElseBranch)
+		}
+
+		return stmt, nil
+	}
+
+	// The condition is known, so only one branch can ever run
+	if common.IsTruthy(literal.Value) {
+		if stmt.ElseBranch != nil {
+			o.discardStatement(stmt.ElseBranch)
+		}
+		return o.optimizeStatement(stmt.ThenBranch), nil
+	}
+
+	o.discardStatement(stmt.ThenBranch)
+	if stmt.ElseBranch != nil {
+		return o.optimizeStatement(stmt.ElseBranch), nil
+	}
+
+	return nil, nil
+}
+
+func (o *Optimizer) VisitPrintStmt(stmt *ast.Print) (interface{}, error) {
+	stmt.Expression = o.optimizeExpression(stmt.Expression)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
+	if stmt.Value != nil {
+		stmt.Value = o.optimizeExpression(stmt.Value)
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitSpawnStmt(stmt *ast.Spawn) (interface{}, error) {
+	// Calls are optimized in place, so the statement keeps its call
+	o.optimizeExpression(stmt.Call)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitForInStmt(stmt *ast.ForIn) (interface{}, error) {
+	stmt.Iterable = o.optimizeExpression(stmt.Iterable)
+	stmt.Body = o.optimizeStatement(stmt.Body)
+	if stmt.Body == nil {
+		stmt.Body = &ast.Block{}
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitYieldStmt(stmt *ast.Yield) (interface{}, error) {
+	if stmt.Value != nil {
+		stmt.Value = o.optimizeExpression(stmt.Value)
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitVarStmt(stmt *ast.Var) (interface{}, error) {
+	if stmt.Initializer != nil {
+		stmt.Initializer = o.optimizeExpression(stmt.Initializer)
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitWhileStmt(stmt *ast.While) (interface{}, error) {
+	stmt.Condition = o.optimizeExpression(stmt.Condition)
+
+	// A loop whose condition is always false never runs its body
+	if literal, ok := literalOf(stmt.Condition); ok && !common.IsTruthy(literal.Value) {
+		o.discardStatement(stmt.Body)
+		return nil, nil
+	}
+
+	stmt.Body = o.optimizeStatement(stmt.Body)
+	if stmt.Body == nil {
+		stmt.Body = &ast.Block{}
+	}
+
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitAssignExpr(expr *ast.Assign) (interface{}, error) {
+	expr.Value = o.optimizeExpression(expr.Value)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitBinaryExpr(expr *ast.Binary) (interface{}, error) {
+	expr.Left = o.optimizeExpression(expr.Left)
+	expr.Right = o.optimizeExpression(expr.Right)
+
+	left, ok := literalOf(expr.Left)
+	if !ok {
+		return expr, nil
+	}
+
+	right, ok := literalOf(expr.Right)
+	if !ok {
+		return expr, nil
+	}
+
+	if value, ok := foldBinary(expr.Operator.Type, left.Value, right.Value); ok {
+		return &ast.Literal{Value: value}, nil
+	}
+
+	return expr, nil
+}
+
+// foldBinary evaluates a binary operator on two constant operands. It reports
+// false if the operation would fail at runtime.
+func foldBinary(operator token.Type, left, right interface{}) (interface{}, bool) {
+	switch operator {
+	case token.EQUAL_EQUAL:
+		return common.IsEqual(left, right), true
+	case token.BANG_EQUAL:
+		return !common.IsEqual(left, right), true
+	}
+
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok && operator == token.PLUS {
+			return l + r, true
+		}
+		return nil, false
+	}
+
+	l, ok := left.(float64)
+	if !ok {
+		return nil, false
+	}
+
+	r, ok := right.(float64)
+	if !ok {
+		return nil, false
+	}
+
+	switch operator {
+	case token.GREATER:
+		return l > r, true
+	case token.GREATER_EQUAL:
+		return l >= r, true
+	case token.LESS:
+		return l < r, true
+	case token.LESS_EQUAL:
+		return l <= r, true
+	case token.MINUS:
+		return l - r, true
+	case token.PLUS:
+		return l + r, true
+	case token.SLASH:
+		return l / r, true
+	case token.STAR:
+		return l * r, true
+	}
+
+	return nil, false
+}
+
+func (o *Optimizer) VisitCallExpr(expr *ast.Call) (interface{}, error) {
+	expr.Callee = o.optimizeExpression(expr.Callee)
+
+	for i, arg := range expr.Arguments {
+		expr.Arguments[i] = o.optimizeExpression(arg)
+	}
+
+	return expr, nil
+}
+
+func (o *Optimizer) VisitGetExpr(expr *ast.Get) (interface{}, error) {
+	expr.Object = o.optimizeExpression(expr.Object)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitGroupingExpr(expr *ast.Grouping) (interface{}, error) {
+	expr.Expression = o.optimizeExpression(expr.Expression)
+
+	// Parentheses around a constant no longer matter
+	if literal, ok := literalOf(expr.Expression); ok {
+		return literal, nil
+	}
+
+	return expr, nil
+}
+
+func (o *Optimizer) VisitLiteralExpr(expr *ast.Literal) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitLogicalExpr(expr *ast.Logical) (interface{}, error) {
+	expr.Left = o.optimizeExpression(expr.Left)
+	expr.Right = o.optimizeExpression(expr.Right)
+
+	left, ok := literalOf(expr.Left)
+	if !ok {
+		return expr, nil
+	}
+
+	// With a constant left operand we know which side the operator yields
+	if common.IsTruthy(left.Value) == (expr.Operator.Type == token.OR) {
+		o.discardExpression(expr.Right)
+		return left, nil
+	}
+
+	return expr.Right, nil
+}
+
+func (o *Optimizer) VisitSetExpr(expr *ast.Set) (interface{}, error) {
+	expr.Object = o.optimizeExpression(expr.Object)
+	expr.Value = o.optimizeExpression(expr.Value)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitSuperExpr(expr *ast.Super) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitThisExpr(expr *ast.This) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitUnaryExpr(expr *ast.Unary) (interface{}, error) {
+	expr.Right = o.optimizeExpression(expr.Right)
+
+	right, ok := literalOf(expr.Right)
+	if !ok {
+		return expr, nil
+	}
+
+	switch expr.Operator.Type {
+	case token.BANG:
+		return &ast.Literal{Value: !common.IsTruthy(right.Value)}, nil
+	case token.MINUS:
+		if value, ok := right.Value.(float64); ok {
+			return &ast.Literal{Value: -value}, nil
+		}
+	}
+
+	return expr, nil
+}
+
+func (o *Optimizer) VisitVariableExpr(expr *ast.Variable) (interface{}, error) {
+	return expr, nil
+}
+
+// discardStatement removes every resolved expression in a dropped statement
+// from the interpreter's locals map.
+func (o *Optimizer) discardStatement(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		for _, inner := range s.Statements {
+			o.discardStatement(inner)
+		}
+	case *ast.Class:
+		if s.Superclass != nil {
+			o.discardExpression(s.Superclass)
+		}
+		for _, method := range s.Methods {
+			o.discardStatement(method)
+		}
+	case *ast.Expression:
+		o.discardExpression(s.Expression)
+	case *ast.Function:
+		for _, inner := range s.Body {
+			o.discardStatement(inner)
+		}
+	case *ast.Test:
+		for _, inner := range s.Body {
+			o.discardStatement(inner)
+		}
+	case *ast.If:
+		o.discardExpression(s.Condition)
+		o.discardStatement(s.ThenBranch)
+		if s.ElseBranch != nil {
+			o.discardStatement(s.ElseBranch)
+		}
+	case *ast.Print:
+		o.discardExpression(s.Expression)
+	case *ast.Return:
+		if s.Value != nil {
+			o.discardExpression(s.Value)
+		}
+	case *ast.Spawn:
+		o.discardExpression(s.Call)
+	case *ast.Var:
+		if s.Initializer != nil {
+			o.discardExpression(s.Initializer)
+		}
+	case *ast.While:
+		o.discardExpression(s.Condition)
+		o.discardStatement(s.Body)
+	case *ast.Yield:
+		if s.Value != nil {
+			o.discardExpression(s.Value)
+		}
+	case *ast.ForIn:
+		o.discardExpression(s.Iterable)
+		o.discardStatement(s.Body)
+	}
+}
+
+func (o *Optimizer) discardExpression(expr ast.Expr) {
+	delete(o.interpreter.locals, expr)
+
+	switch e := expr.(type) {
+	case *ast.Assign:
+		o.discardExpression(e.Value)
+	case *ast.Binary:
+		o.discardExpression(e.Left)
+		o.discardExpression(e.Right)
+	case *ast.Call:
+		o.discardExpression(e.Callee)
+		for _, arg := range e.Arguments {
+			o.discardExpression(arg)
+		}
+	case *ast.Get:
+		o.discardExpression(e.Object)
+	case *ast.Grouping:
+		o.discardExpression(e.Expression)
+	case *ast.Logical:
+		o.discardExpression(e.Left)
+		o.discardExpression(e.Right)
+	case *ast.Set:
+		o.discardExpression(e.Object)
+		o.discardExpression(e.Value)
+	case *ast.Unary:
+		o.discardExpression(e.Right)
+	}
+}
@@ -0,0 +1,243 @@
+package lox
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+func TestOptimizer(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		optimized string
+	}{
+		{
+			name:      "arithmetic",
+			source:    `print 1 + 2 * (3 - 1) / 4;`,
+			optimized: `print 2;`,
+		},
+		{
+			name:      "comparison and equality",
+			source:    `print 1 < 2 == (3 >= 4);`,
+			optimized: `print false;`,
+		},
+		{
+			name:      "concatenation",
+			source:    `print "a" + "b" + "c";`,
+			optimized: `print "abc";`,
+		},
+		{
+			name:      "unary",
+			source:    `print -(2) + 3; print !nil;`,
+			optimized: `print 1; print true;`,
+		},
+		{
+			name:      "runtime errors are kept",
+			source:    `print 1 + "a"; print -"b";`,
+			optimized: `print 1 + "a"; print -"b";`,
+		},
+		{
+			name:      "and/or",
+			source:    `var a; print nil or a; print true or a; print false and a; print 1 and a;`,
+			optimized: `var a; print a; print true; print false; print a;`,
+		},
+		{
+			name:      "if",
+			source:    `if (1 > 2) print "a"; else print "b"; if (true) print "c"; if (nil) print "d";`,
+			optimized: `print "b"; print "c";`,
+		},
+		{
+			name:      "while",
+			source:    `while (false) print "a"; var i = 0; while (i < 1) i = i + 1;`,
+			optimized: `var i = 0; while (i < 1) i = i + 1;`,
+		},
+		{
+			name:      "return",
+			source:    `fun f() { print "a"; return 1; print "b"; }`,
+			optimized: `fun f() { print "a"; return 1; }`,
+		},
+	}
+
+	for _, test := range tests {
+		// The expected tree is the one parsed from the optimized source
+		// with the optimizer off, so tokens on line 1 compare equal.
+		want := New()
+		want.Optimize = false
+		expected := want.parse(test.optimized)
+		if want.HadError {
+			t.Fatalf("%s: %s doesn't parse", test.name, test.optimized)
+		}
+
+		l := New()
+
+		var errOut strings.Builder
+		l.SetErrorOutput(&errOut)
+		statements := l.parse(test.source)
+
+		if !reflect.DeepEqual(statements, expected) {
+			t.Errorf("%s: optimized tree differs from the tree of %s",
+				test.name, test.optimized)
+		}
+
+		if errOut.Len() > 0 {
+			t.Errorf("%s: got errors %q", test.name, errOut.String())
+		}
+	}
+}
+
+// Each program runs the same with and without the optimizer.
+func TestOptimizerBehavior(t *testing.T) {
+	tests := []struct {
+		name   string
+		source []string
+		out    string
+		err    string
+	}{
+		{
+			name: "folding",
+			source: []string{
+				`print 1 + 2 * 3;`,
+				`print 7 / 2 - 0.5;`,
+				`print 1 / 0;`,
+				`print 0 / 0 == 0 / 0;`,
+				`print "a" + "b" == "ab";`,
+				`print -(-(1));`,
+				`print !!"";`,
+			},
+			out: "7\n3\n+Inf\nfalse\ntrue\n1\ntrue\n",
+		},
+		{
+			name: "short-circuit",
+			source: []string{
+				`fun f() { print "called"; return true; }`,
+				`print nil or "default";`,
+				`print "value" or f();`,
+				`print false and f();`,
+				`print true and f();`,
+			},
+			out: "default\nvalue\nfalse\ncalled\ntrue\n",
+		},
+		{
+			name: "dead branches",
+			source: []string{
+				`var a = "global";`,
+				`{`,
+				`  var a = "local";`,
+				`  if (false) { a = "then"; print a; } else print a;`,
+				`  while (nil) print a;`,
+				`  if (true) print a + "!";`,
+				`}`,
+				`print a;`,
+			},
+			out: "local\nlocal!\nglobal\n",
+		},
+		{
+			name: "after return",
+			source: []string{
+				`fun f(x) {`,
+				`  if (x) { return "early"; print "never"; }`,
+				`  return "late";`,
+				`  x = "never";`,
+				`}`,
+				`print f(true);`,
+				`print f(false);`,
+			},
+			out: "early\nlate\n",
+		},
+		{
+			name: "closures over dropped code",
+			source: []string{
+				`fun make() {`,
+				`  var n = 1;`,
+				`  fun get() { return n; }`,
+				`  if (false) n = 2;`,
+				`  return get;`,
+				`  n = 3;`,
+				`}`,
+				`print make()();`,
+			},
+			out: "1\n",
+		},
+		{
+			name: "runtime error kept",
+			source: []string{
+				`print "before";`,
+				`print 1 + "a";`,
+			},
+			out: "before\n",
+			err: "[line 2] RuntimeError: operands must be two numbers or two strings\n",
+		},
+		{
+			name: "unary runtime error kept",
+			source: []string{
+				`print -"a";`,
+			},
+			err: "[line 1] RuntimeError: operand must be a number\n",
+		},
+	}
+
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		for _, test := range tests {
+			for _, optimize := range []bool{true, false} {
+				l := New()
+				l.Engine = engine
+				l.Optimize = optimize
+
+				checkRun(t, fmt.Sprintf("engine %d, %s, optimize %t", engine,
+					test.name, optimize), l, test.source, test.out, test.err)
+			}
+		}
+	}
+}
+
+// Code the optimizer drops must leave no entries behind in the locals map,
+// and code it keeps must keep its entries.
+func TestOptimizerLocals(t *testing.T) {
+	source := strings.Join([]string{
+		`fun f(a, b) {`,
+		`  if (false) { print -a; b = a; print a.x; a.y = b; print (a); print a(b); }`,
+		`  while (false) print !b;`,
+		`  print nil or a;`,
+		`  print true or b;`,
+		`  print false and -b;`,
+		`  return a;`,
+		`  print b + a;`,
+		`}`,
+		`class A < B { m() { if (nil) return super.m() + this.n; return this; } }`,
+	}, "\n")
+
+	resolved := New()
+	resolved.Optimize = false
+	resolved.parse(source)
+
+	l := New()
+	statements := l.parse(source)
+	if l.HadError {
+		t.Fatal("program doesn't resolve")
+	}
+
+	// Discarding what is left must remove every entry. An entry that remains
+	// belongs to a node no longer in the tree.
+	stale := make(map[ast.Expr]int)
+	for expr, depth := range l.Interpreter.locals {
+		stale[expr] = depth
+	}
+	o := NewOptimizer(l, &Interpreter{locals: stale})
+	for _, stmt := range statements {
+		o.discardStatement(stmt)
+	}
+
+	for expr, depth := range stale {
+		t.Errorf("stale local %T at depth %d", expr, depth)
+	}
+
+	// a in "nil or a", "return a", and this in "return this" are kept
+	if kept := len(l.Interpreter.locals); kept != 3 {
+		t.Errorf("got %d locals, want 3 of the %d resolved", kept,
+			len(resolved.Interpreter.locals))
+	}
+}
@@ -17,6 +17,10 @@ type Parser struct {
 	tokens        []*token.Token
 	current       int
 	hadParseError bool
+
+	// yielded is set by a yield in the body of the function being parsed,
+	// which makes it a generator
+	yielded bool
 }
 
 func NewParser(l *Lox, tokens []*token.Token) *Parser {
@@ -59,6 +63,17 @@ func (p *Parser) statement() ast.Stmt {
 		return p.whileStatement()
 	}
 
+	if p.match(token.YIELD) {
+		return p.yieldStatement()
+	}
+
+	// Like "test", "spawn" is only a keyword in front of the call it spawns
+	if p.check(token.IDENTIFIER) && p.peek().Lexeme == "spawn" &&
+		(p.checkNext(token.IDENTIFIER) || p.checkNext(token.THIS) ||
+			p.checkNext(token.SUPER)) {
+		return p.spawnStatement()
+	}
+
 	if p.match(token.LEFT_BRACE) {
 		return &ast.Block{Statements: p.block()}
 	}
@@ -67,11 +82,19 @@ func (p *Parser) statement() ast.Stmt {
 }
 
 func (p *Parser) forStatement() ast.Stmt {
+	keyword := p.previous()
 	_, ok := p.consume(token.LEFT_PAREN)
 	if !ok {
 		p.NewParserError(p.peek(), "expected \"(\" after \"for\"")
 	}
 
+	// A name followed by "in" can't start a C-style clause, so "in" is only a
+	// keyword there
+	if p.check(token.IDENTIFIER) && p.checkNext(token.IDENTIFIER) &&
+		p.tokens[p.current+1].Lexeme == "in" {
+		return p.forInStatement(keyword)
+	}
+
 	var initializer ast.Stmt
 	if p.match(token.SEMICOLON) {
 		initializer = nil
@@ -122,6 +145,22 @@ func (p *Parser) forStatement() ast.Stmt {
 	return body
 }
 
+func (p *Parser) forInStatement(keyword *token.Token) ast.Stmt {
+	name := p.advance()
+	p.advance()
+	iterable := p.expression()
+
+	_, ok := p.consume(token.RIGHT_PAREN)
+	if !ok {
+		p.NewParserError(p.peek(), "expected \")\" after for-in clause")
+	}
+
+	body := p.statement()
+
+	return &ast.ForIn{Keyword: keyword, Name: name, Iterable: iterable,
+		Body: body}
+}
+
 func (p *Parser) ifStatement() ast.Stmt {
 	_, ok := p.consume(token.LEFT_PAREN)
 	if !ok {
@@ -149,6 +188,7 @@ func (p *Parser) ifStatement() ast.Stmt {
 }
 
 func (p *Parser) printStatement() ast.Stmt {
+	keyword := p.previous()
 	value := p.expression()
 
 	_, ok := p.consume(token.SEMICOLON)
@@ -156,7 +196,7 @@ func (p *Parser) printStatement() ast.Stmt {
 		p.NewParserError(p.peek(), "expected \";\" after value")
 	}
 
-	return &ast.Print{Expression: value}
+	return &ast.Print{Keyword: keyword, Expression: value}
 }
 
 func (p *Parser) returnStatement() ast.Stmt {
@@ -175,6 +215,23 @@ func (p *Parser) returnStatement() ast.Stmt {
 	return &ast.Return{Keyword: keyword, Value: value}
 }
 
+func (p *Parser) spawnStatement() ast.Stmt {
+	keyword := p.advance()
+	expr := p.expression()
+
+	call, isCall := expr.(*ast.Call)
+	if !isCall {
+		p.NewParserError(keyword, "expected a call to spawn")
+	}
+
+	_, ok := p.consume(token.SEMICOLON)
+	if !ok {
+		p.NewParserError(p.peek(), "expected \";\" after spawned call")
+	}
+
+	return &ast.Spawn{Keyword: keyword, Call: call}
+}
+
 func (p *Parser) varDeclaration() ast.Stmt {
 	name, ok := p.consume(token.IDENTIFIER)
 	if !ok {
@@ -212,6 +269,23 @@ func (p *Parser) whileStatement() ast.Stmt {
 	return &ast.While{Condition: condition, Body: body}
 }
 
+func (p *Parser) yieldStatement() ast.Stmt {
+	keyword := p.previous()
+	p.yielded = true
+
+	var value ast.Expr
+	if !p.check(token.SEMICOLON) {
+		value = p.expression()
+	}
+
+	_, ok := p.consume(token.SEMICOLON)
+	if !ok {
+		p.NewParserError(p.peek(), "expected \";\" after yield value")
+	}
+
+	return &ast.Yield{Keyword: keyword, Value: value}
+}
+
 func (p *Parser) expressionStatement() ast.Stmt {
 	expr := p.expression()
 
@@ -223,7 +297,11 @@ func (p *Parser) expressionStatement() ast.Stmt {
 	return &ast.Expression{Expression: expr}
 }
 
+// function parses a function or method declaration. Those marked with "*",
+// or that yield, are generators.
 func (p *Parser) function(kind string) ast.Stmt {
+	generator := p.match(token.STAR)
+
 	name, ok := p.consume(token.IDENTIFIER)
 	if !ok {
 		p.NewParserError(p.peek(), fmt.Sprintf("expected %q name", kind))
@@ -266,9 +344,14 @@ func (p *Parser) function(kind string) ast.Stmt {
 			kind))
 	}
 
+	enclosing := p.yielded
+	p.yielded = false
 	body := p.block()
+	generator = generator || p.yielded
+	p.yielded = enclosing
 
-	return &ast.Function{Name: name, Params: parameters, Body: body}
+	return &ast.Function{Name: name, Params: parameters, Body: body,
+		Generator: generator}
 }
 func (p *Parser) block() []ast.Stmt {
 	var statements []ast.Stmt
@@ -351,6 +434,13 @@ func (p *Parser) declaration() ast.Stmt {
 		return p.varDeclaration()
 	}
 
+	// "test" is only a keyword in front of a test name, so scripts can keep
+	// using it as an identifier.
+	if p.check(token.IDENTIFIER) && p.peek().Lexeme == "test" &&
+		p.checkNext(token.STRING) {
+		return p.testDeclaration()
+	}
+
 	res := p.statement()
 	if p.hadParseError {
 		p.synchronize()
@@ -397,6 +487,21 @@ func (p *Parser) classDeclaration() ast.Stmt {
 	return &ast.Class{Name: name, Superclass: superclass, Methods: methods}
 }
 
+func (p *Parser) testDeclaration() ast.Stmt {
+	keyword := p.advance()
+	name := p.advance()
+
+	_, ok := p.consume(token.LEFT_BRACE)
+	if !ok {
+		p.NewParserError(p.peek(), "expected \"{\" before test body")
+		p.synchronize()
+		p.hadParseError = false
+		return nil
+	}
+
+	return &ast.Test{Keyword: keyword, Name: name, Body: p.block()}
+}
+
 func (p *Parser) equality() ast.Expr {
 	expr := p.comparison()
 
@@ -583,6 +688,15 @@ func (p Parser) check(t token.Type) bool {
 	return p.peek().Type == t
 }
 
+// checkNext() looks one token past the current one
+func (p Parser) checkNext(t token.Type) bool {
+	if p.isAtEnd() {
+		return false
+	}
+
+	return p.tokens[p.current+1].Type == t
+}
+
 // advance() method consumes the current token and returns it
 func (p *Parser) advance() *token.Token {
 	if !p.isAtEnd() {
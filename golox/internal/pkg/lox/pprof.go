@@ -0,0 +1,170 @@
+package lox
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// writePprof writes the profile as gzipped protocol buffers in the format of
+// https://github.com/google/pprof/blob/main/proto/profile.proto, which go tool
+// pprof reads. Every node of the call tree becomes a sample whose locations
+// are the lines on its stack, innermost first.
+func (p *Profile) writePprof(w io.Writer, duration time.Duration) error {
+	var b protoBuffer
+
+	// Strings are referenced by their index in the string table
+	index := map[string]int64{"": 0}
+	table := []string{""}
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = int64(len(table))
+		table = append(table, s)
+		return index[s]
+	}
+
+	// sample_type
+	for _, t := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}} {
+		var vt protoBuffer
+		vt.int64(1, str(t[0]))
+		vt.int64(2, str(t[1]))
+		b.message(1, &vt)
+	}
+
+	locations := make(map[profileKey]uint64)
+	var order []profileKey
+	location := func(key profileKey) uint64 {
+		id, ok := locations[key]
+		if !ok {
+			id = uint64(len(locations) + 1)
+			locations[key] = id
+			order = append(order, key)
+		}
+		return id
+	}
+
+	// sample
+	p.root.walk(func(n *profileNode) {
+		if n.calls == 0 && n.nanos == 0 {
+			return
+		}
+
+		var ids []uint64
+		for a := n; a.function != nil; a = a.parent {
+			ids = append(ids, location(a.key()))
+		}
+
+		var sample protoBuffer
+		sample.packedUint64(1, ids)
+		sample.packedInt64(2, []int64{n.calls, n.nanos})
+		b.message(2, &sample)
+	})
+
+	// location
+	for _, key := range order {
+		var line protoBuffer
+		line.uint64(1, key.function.id)
+		line.int64(2, int64(key.line))
+
+		var loc protoBuffer
+		loc.uint64(1, locations[key])
+		loc.message(4, &line)
+		b.message(4, &loc)
+	}
+
+	// function
+	functions := make([]*profileFunction, len(p.functions))
+	for _, f := range p.functions {
+		functions[f.id-1] = f
+	}
+	for _, f := range functions {
+		var fn protoBuffer
+		fn.uint64(1, f.id)
+		fn.int64(2, str(f.name))
+		fn.int64(3, str(f.name))
+		fn.int64(4, str(p.path))
+		fn.int64(5, int64(f.line))
+		b.message(5, &fn)
+	}
+
+	// time_nanos and duration_nanos
+	b.int64(9, p.start.UnixNano())
+	b.int64(10, int64(duration))
+
+	// default_sample_type
+	b.int64(14, str("time"))
+
+	// string_table, last so it holds every string used above
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(b.data); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+// protoBuffer encodes the protocol buffer wire format.
+type protoBuffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) key(field int, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packedUint64(field int, values []uint64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(v)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protoBuffer) packedInt64(field int, values []int64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	b.bytes(field, packed.data)
+}
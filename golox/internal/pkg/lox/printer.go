@@ -0,0 +1,222 @@
+package lox
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// AstPrinter shows a syntax tree in a Lisp-like form that makes nesting and
+// precedence explicit, so "1 + 2 * 3;" is printed as "(; (+ 1 (* 2 3)))".
+type AstPrinter struct{}
+
+// Print returns statements in parenthesized form, one per line.
+func (p AstPrinter) Print(statements []ast.Stmt) string {
+	var sb strings.Builder
+	for _, stmt := range statements {
+		sb.WriteString(p.stmt(stmt) + "\n")
+	}
+
+	return sb.String()
+}
+
+func (p AstPrinter) stmt(stmt ast.Stmt) string {
+	if stmt == nil {
+		return "nil"
+	}
+
+	s, _ := stmt.Accept(p)
+	return s.(string)
+}
+
+func (p AstPrinter) expr(expr ast.Expr) string {
+	if expr == nil {
+		return "nil"
+	}
+
+	s, _ := expr.Accept(p)
+	return s.(string)
+}
+
+// parenthesize wraps name and parts, which are syntax tree nodes, tokens or
+// strings, in parentheses.
+func (p AstPrinter) parenthesize(name string, parts ...interface{}) (interface{}, error) {
+	var sb strings.Builder
+	sb.WriteString("(" + name)
+
+	for _, part := range parts {
+		sb.WriteString(" ")
+
+		switch v := part.(type) {
+		case ast.Expr:
+			sb.WriteString(p.expr(v))
+		case ast.Stmt:
+			sb.WriteString(p.stmt(v))
+		case *token.Token:
+			sb.WriteString(v.Lexeme)
+		case string:
+			sb.WriteString(v)
+		}
+	}
+
+	sb.WriteString(")")
+	return sb.String(), nil
+}
+
+func (p AstPrinter) statements(statements []ast.Stmt) []interface{} {
+	parts := make([]interface{}, len(statements))
+	for n, stmt := range statements {
+		parts[n] = stmt
+	}
+
+	return parts
+}
+
+func (p AstPrinter) VisitBlockStmt(stmt *ast.Block) (interface{}, error) {
+	return p.parenthesize("block", p.statements(stmt.Statements)...)
+}
+
+func (p AstPrinter) VisitClassStmt(stmt *ast.Class) (interface{}, error) {
+	parts := []interface{}{stmt.Name}
+	if stmt.Superclass != nil {
+		parts = append(parts, "<", stmt.Superclass.Name)
+	}
+
+	for _, method := range stmt.Methods {
+		parts = append(parts, method)
+	}
+
+	return p.parenthesize("class", parts...)
+}
+
+func (p AstPrinter) VisitExpressionStmt(stmt *ast.Expression) (interface{}, error) {
+	return p.parenthesize(";", stmt.Expression)
+}
+
+func (p AstPrinter) VisitFunctionStmt(stmt *ast.Function) (interface{}, error) {
+	params := make([]string, len(stmt.Params))
+	for n, param := range stmt.Params {
+		params[n] = param.Lexeme
+	}
+
+	parts := []interface{}{stmt.Name, "(" + strings.Join(params, " ") + ")"}
+	name := "fun"
+	if stmt.Generator {
+		name = "fun*"
+	}
+	return p.parenthesize(name, append(parts, p.statements(stmt.Body)...)...)
+}
+
+func (p AstPrinter) VisitIfStmt(stmt *ast.If) (interface{}, error) {
+	if stmt.ElseBranch == nil {
+		return p.parenthesize("if", stmt.Condition, stmt.ThenBranch)
+	}
+
+	return p.parenthesize("if", stmt.Condition, stmt.ThenBranch, stmt.ElseBranch)
+}
+
+func (p AstPrinter) VisitPrintStmt(stmt *ast.Print) (interface{}, error) {
+	return p.parenthesize("print", stmt.Expression)
+}
+
+func (p AstPrinter) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
+	if stmt.Value == nil {
+		return p.parenthesize("return")
+	}
+
+	return p.parenthesize("return", stmt.Value)
+}
+
+func (p AstPrinter) VisitForInStmt(stmt *ast.ForIn) (interface{}, error) {
+	return p.parenthesize("for-in", stmt.Name, stmt.Iterable, stmt.Body)
+}
+
+func (p AstPrinter) VisitYieldStmt(stmt *ast.Yield) (interface{}, error) {
+	if stmt.Value == nil {
+		return p.parenthesize("yield")
+	}
+
+	return p.parenthesize("yield", stmt.Value)
+}
+
+func (p AstPrinter) VisitSpawnStmt(stmt *ast.Spawn) (interface{}, error) {
+	return p.parenthesize("spawn", stmt.Call)
+}
+
+func (p AstPrinter) VisitTestStmt(stmt *ast.Test) (interface{}, error) {
+	parts := []interface{}{stmt.Name}
+	return p.parenthesize("test", append(parts, p.statements(stmt.Body)...)...)
+}
+
+func (p AstPrinter) VisitVarStmt(stmt *ast.Var) (interface{}, error) {
+	if stmt.Initializer == nil {
+		return p.parenthesize("var", stmt.Name)
+	}
+
+	return p.parenthesize("var", stmt.Name, stmt.Initializer)
+}
+
+func (p AstPrinter) VisitWhileStmt(stmt *ast.While) (interface{}, error) {
+	return p.parenthesize("while", stmt.Condition, stmt.Body)
+}
+
+func (p AstPrinter) VisitAssignExpr(expr *ast.Assign) (interface{}, error) {
+	return p.parenthesize("=", expr.Name, expr.Value)
+}
+
+func (p AstPrinter) VisitBinaryExpr(expr *ast.Binary) (interface{}, error) {
+	return p.parenthesize(expr.Operator.Lexeme, expr.Left, expr.Right)
+}
+
+func (p AstPrinter) VisitCallExpr(expr *ast.Call) (interface{}, error) {
+	parts := []interface{}{expr.Callee}
+	for _, argument := range expr.Arguments {
+		parts = append(parts, argument)
+	}
+
+	return p.parenthesize("call", parts...)
+}
+
+func (p AstPrinter) VisitGetExpr(expr *ast.Get) (interface{}, error) {
+	return p.parenthesize(".", expr.Object, expr.Name)
+}
+
+func (p AstPrinter) VisitGroupingExpr(expr *ast.Grouping) (interface{}, error) {
+	return p.parenthesize("group", expr.Expression)
+}
+
+func (p AstPrinter) VisitLiteralExpr(expr *ast.Literal) (interface{}, error) {
+	if s, ok := expr.Value.(string); ok {
+		return strconv.Quote(s), nil
+	}
+
+	return common.Stringfy(expr.Value), nil
+}
+
+func (p AstPrinter) VisitLogicalExpr(expr *ast.Logical) (interface{}, error) {
+	return p.parenthesize(expr.Operator.Lexeme, expr.Left, expr.Right)
+}
+
+func (p AstPrinter) VisitSetExpr(expr *ast.Set) (interface{}, error) {
+	get, _ := p.parenthesize(".", expr.Object, expr.Name)
+	return p.parenthesize("=", get.(string), expr.Value)
+}
+
+func (p AstPrinter) VisitSuperExpr(expr *ast.Super) (interface{}, error) {
+	return p.parenthesize("super", expr.Method)
+}
+
+func (p AstPrinter) VisitThisExpr(expr *ast.This) (interface{}, error) {
+	return "this", nil
+}
+
+func (p AstPrinter) VisitUnaryExpr(expr *ast.Unary) (interface{}, error) {
+	return p.parenthesize(expr.Operator.Lexeme, expr.Right)
+}
+
+func (p AstPrinter) VisitVariableExpr(expr *ast.Variable) (interface{}, error) {
+	return expr.Name.Lexeme, nil
+}
@@ -0,0 +1,342 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// profileTop is the number of functions and lines in the text summary.
+const profileTop = 10
+
+// Profile measures where a script spends its time in terms of Lox code. The
+// tree-walk interpreter reports every statement it starts and every function,
+// method and class it calls, and the time between two of these events is
+// charged to the line running in the innermost call. Until the first
+// statement of a call starts, between its statements and once the last one
+// has finished, the time is charged to the line that made the call instead,
+// since it is spent setting up and returning from the call. Calls are counted
+// for the function called.
+//
+// Call stacks are kept as a tree with a node for every line of every function
+// reached by a distinct path, so the cost of an event is a map lookup at
+// most. Each node becomes a sample of the pprof profile.
+type Profile struct {
+	noTool
+
+	out   string
+	path  string // the script being profiled
+	start time.Time
+	last  time.Time
+
+	functions map[interface{}]*profileFunction
+	root      *profileNode
+	current   *profileNode
+
+	// callers holds the node each active call was made from
+	callers []*profileNode
+
+	// lines holds the line each running statement moved its call from
+	lines []int
+}
+
+// profileFunction is a function, method or class that was called, or the
+// top-level script.
+type profileFunction struct {
+	id    uint64
+	name  string
+	line  int
+	calls int64
+}
+
+type profileNode struct {
+	parent   *profileNode
+	function *profileFunction
+	line     int // 0 while no statement of the call is running
+	children map[profileKey]*profileNode
+
+	calls int64
+	nanos int64
+}
+
+type profileKey struct {
+	function *profileFunction
+	line     int
+}
+
+// NewProfile returns a Profile that writes pprof data to out.
+func NewProfile(out string) *Profile {
+	return &Profile{
+		out:       out,
+		functions: make(map[interface{}]*profileFunction),
+		root:      &profileNode{},
+	}
+}
+
+// begin starts profiling the script at path.
+func (p *Profile) begin(path string) {
+	p.path = path
+	p.start = time.Now()
+	p.last = p.start
+
+	script := p.function(p, "script", 1)
+	p.current = p.root.child(script, 1)
+}
+
+// function returns the profiled function for key, a syntax tree node or
+// class, creating it the first time.
+func (p *Profile) function(key interface{}, name string, line int) *profileFunction {
+	f, ok := p.functions[key]
+	if !ok {
+		f = &profileFunction{
+			id:   uint64(len(p.functions) + 1),
+			name: name,
+			line: line,
+		}
+		p.functions[key] = f
+	}
+
+	return f
+}
+
+func (n *profileNode) child(f *profileFunction, line int) *profileNode {
+	key := profileKey{f, line}
+
+	child, ok := n.children[key]
+	if !ok {
+		if n.children == nil {
+			n.children = make(map[profileKey]*profileNode)
+		}
+
+		child = &profileNode{parent: n, function: f, line: line}
+		n.children[key] = child
+	}
+
+	return child
+}
+
+// charge adds the time since the last event to the running line.
+func (p *Profile) charge() {
+	now := time.Now()
+
+	// A call running none of its statements charges its caller, which may be
+	// doing the same, such as a class calling its initializer
+	n := p.current
+	for k := len(p.callers) - 1; n.line == 0 && k >= 0; k-- {
+		n = p.callers[k]
+	}
+
+	n.nanos += int64(now.Sub(p.last))
+	p.last = now
+}
+
+// line moves the innermost call to line and returns the line it was on.
+func (p *Profile) line(line int) int {
+	previous := p.current.line
+	if line == previous {
+		return previous
+	}
+
+	p.charge()
+	p.current = p.current.parent.child(p.current.function, line)
+	return previous
+}
+
+// OnStatement moves the innermost call to the line of stmt. Statements
+// without a line of their own, such as blocks, leave it alone.
+func (p *Profile) OnStatement(stmt ast.Stmt) {
+	if line := statementLine(stmt); line != 0 {
+		p.lines = append(p.lines, p.line(line))
+	}
+}
+
+// statementDone moves the innermost call back to the line it was on before
+// stmt started.
+func (p *Profile) statementDone(stmt ast.Stmt) {
+	if statementLine(stmt) != 0 {
+		n := len(p.lines) - 1
+		p.line(p.lines[n])
+		p.lines = p.lines[:n]
+	}
+}
+
+// enter starts a call.
+func (p *Profile) enter(frame *callFrame) {
+	p.charge()
+
+	f := p.function(frame.key, frame.name, frame.line)
+	f.calls++
+
+	p.callers = append(p.callers, p.current)
+	p.current = p.current.child(f, 0)
+	p.current.calls++
+}
+
+// leave returns from the innermost call.
+func (p *Profile) leave(*callFrame) {
+	p.charge()
+
+	n := len(p.callers) - 1
+	p.current = p.callers[n]
+	p.callers = p.callers[:n]
+}
+
+// Write writes the pprof profile and prints the functions and lines that
+// took the most time to summary. There is nothing to write if no script was
+// profiled, such as when it couldn't be read.
+func (p *Profile) Write(summary io.Writer) error {
+	if p.current == nil {
+		return nil
+	}
+
+	p.charge()
+	duration := p.last.Sub(p.start)
+
+	f, err := os.Create(p.out)
+	if err != nil {
+		return err
+	}
+
+	if err := p.writePprof(f, duration); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	p.writeTop(summary, duration)
+	return nil
+}
+
+// profileEntry totals the time of a function or line.
+type profileEntry struct {
+	name  string
+	calls int64
+	flat  int64 // spent in the function or on the line itself
+	cum   int64 // including the calls it made
+}
+
+func (p *Profile) writeTop(w io.Writer, duration time.Duration) {
+	functions := make(map[*profileFunction]*profileEntry)
+	lines := make(map[profileKey]*profileEntry)
+
+	function := func(f *profileFunction) *profileEntry {
+		if functions[f] == nil {
+			functions[f] = &profileEntry{
+				name:  fmt.Sprintf("%s (%s:%d)", f.name, p.path, f.line),
+				calls: f.calls,
+			}
+		}
+		return functions[f]
+	}
+
+	line := func(key profileKey) *profileEntry {
+		if lines[key] == nil {
+			lines[key] = &profileEntry{
+				name: fmt.Sprintf("%s:%d (%s)", p.path, key.line, key.function.name),
+			}
+		}
+		return lines[key]
+	}
+
+	p.root.walk(func(n *profileNode) {
+		function(n.function).flat += n.nanos
+		if n.line != 0 {
+			line(profileKey{n.function, n.line}).flat += n.nanos
+		}
+
+		// The time of a node counts towards every function and line on its
+		// stack once, however many times recursion put them there.
+		seenFunctions := make(map[*profileFunction]bool)
+		seenLines := make(map[profileKey]bool)
+		for a := n; a.function != nil; a = a.parent {
+			if !seenFunctions[a.function] {
+				seenFunctions[a.function] = true
+				function(a.function).cum += n.nanos
+			}
+
+			key := profileKey{a.function, a.line}
+			if a.line != 0 && !seenLines[key] {
+				seenLines[key] = true
+				line(key).cum += n.nanos
+			}
+		}
+	})
+
+	fmt.Fprintf(w, "Duration: %s\n", duration.Round(time.Microsecond))
+
+	var byFunction []*profileEntry
+	for _, e := range functions {
+		byFunction = append(byFunction, e)
+	}
+	writeEntries(w, "function", byFunction, duration, true)
+
+	var byLine []*profileEntry
+	for _, e := range lines {
+		byLine = append(byLine, e)
+	}
+	writeEntries(w, "line", byLine, duration, false)
+}
+
+func writeEntries(w io.Writer, title string, entries []*profileEntry, duration time.Duration, calls bool) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].flat != entries[j].flat {
+			return entries[i].flat > entries[j].flat
+		}
+		return entries[i].name < entries[j].name
+	})
+	if len(entries) > profileTop {
+		entries = entries[:profileTop]
+	}
+
+	percent := func(nanos int64) string {
+		if duration == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(nanos)/float64(duration)*100)
+	}
+
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "flat\tflat%\tcum\tcum%\t"
+	if calls {
+		header += "calls\t"
+	}
+	fmt.Fprintf(t, "\n%s %s\n", header, title)
+
+	for _, e := range entries {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t",
+			time.Duration(e.flat).Round(time.Microsecond), percent(e.flat),
+			time.Duration(e.cum).Round(time.Microsecond), percent(e.cum))
+		if calls {
+			fmt.Fprintf(t, "%d\t", e.calls)
+		}
+		fmt.Fprintf(t, " %s\n", e.name)
+	}
+
+	t.Flush()
+}
+
+// key returns the function and line of n for pprof, which shows a call
+// running none of its statements on the line declaring the function.
+func (n *profileNode) key() profileKey {
+	if n.line == 0 {
+		return profileKey{n.function, n.function.line}
+	}
+
+	return profileKey{n.function, n.line}
+}
+
+// walk calls visit for every node below n.
+func (n *profileNode) walk(visit func(*profileNode)) {
+	for _, child := range n.children {
+		visit(child)
+		child.walk(visit)
+	}
+}
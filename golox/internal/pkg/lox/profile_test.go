@@ -0,0 +1,100 @@
+package lox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const profileScript = `fun count(n) {
+  if (n > 0) count(n - 1);
+}
+
+class Counter {
+  init() { count(3); }
+  again() { count(1); }
+}
+
+Counter().again();
+`
+
+func TestProfile(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "cpu.pprof")
+
+	l := New()
+	l.SetOutput(io.Discard)
+	l.Profile = NewProfile(out)
+	l.Profile.begin("counter.lox")
+	l.Run(profileScript)
+
+	if l.HadError || l.HadRuntimeError {
+		t.Fatal("unexpected error")
+	}
+
+	var summary bytes.Buffer
+	if err := l.Profile.Write(&summary); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(map[string]int64)
+	for _, f := range l.Profile.functions {
+		calls[f.name] = f.calls
+	}
+
+	expected := map[string]int64{
+		"script":        0,
+		"count":         6,
+		"Counter":       1,
+		"Counter.init":  1,
+		"Counter.again": 1,
+	}
+	for name, n := range expected {
+		if calls[name] != n {
+			t.Errorf("%s called %d times, expected %d", name, calls[name], n)
+		}
+	}
+
+	// Every call has returned
+	if len(l.Profile.callers) != 0 {
+		t.Errorf("%d calls still active", len(l.Profile.callers))
+	}
+
+	// Setting up and returning from a call is charged to the line making it
+	l.Profile.root.walk(func(n *profileNode) {
+		if n.line == 0 && n.nanos != 0 {
+			t.Errorf("%s charged %s outside its statements", n.function.name,
+				time.Duration(n.nanos))
+		}
+	})
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Function names are in the string table
+	for name := range expected {
+		if !bytes.Contains(data, []byte(name)) {
+			t.Errorf("profile has no function %s", name)
+		}
+	}
+
+	if !strings.Contains(summary.String(), "Counter.init (counter.lox:6)") {
+		t.Errorf("unexpected summary:\n%s", summary.String())
+	}
+}
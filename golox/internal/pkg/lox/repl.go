@@ -0,0 +1,230 @@
+package lox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/lineedit"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+
+	// historyFile is kept in the user's home directory
+	historyFile = ".golox_history"
+)
+
+// RunPrompt starts an interactive session on standard input. Input spanning
+// several lines is collected until its braces and parentheses balance, and
+// the value of an expression entered on its own is printed. Lines starting
+// with a colon are commands, which ":help" lists. The session ends at end of
+// input, ^D, or when "exit" is entered.
+//
+// When standard input is a terminal lines can be edited and earlier entries
+// recalled with the arrow keys, tab completes keywords, globals and the
+// members of instances, and input is colored unless NO_COLOR is set. The
+// history is kept in ~/.golox_history.
+func (l *Lox) RunPrompt() {
+	editor := lineedit.New(os.Stdin, os.Stdout)
+	editor.Complete = l.complete
+	if os.Getenv("NO_COLOR") == "" {
+		editor.Highlight = highlight
+	}
+
+	var history string
+	if editor.IsTerminal() {
+		if home, err := os.UserHomeDir(); err == nil {
+			history = filepath.Join(home, historyFile)
+			editor.LoadHistory(history)
+		}
+	}
+
+	l.prompt(editor)
+
+	if history != "" {
+		if err := editor.SaveHistory(history); err != nil {
+			fmt.Fprintln(l.errOut, err)
+		}
+	}
+	l.Flush()
+}
+
+// prompt reads and runs entries from editor until the end of input.
+func (l *Lox) prompt(editor *lineedit.Editor) {
+	s := &session{l: l}
+	var lines []string
+
+	for {
+		l.Flush()
+
+		p := prompt
+		if len(lines) > 0 {
+			p = continuationPrompt
+		}
+
+		line, err := editor.ReadLine(p)
+		if errors.Is(err, lineedit.ErrInterrupted) {
+			// ^C abandons the entry being written
+			lines = nil
+			continue
+		} else if err != nil {
+			if !editor.IsTerminal() {
+				// The editor only ends the line itself on a terminal
+				fmt.Fprintln(l.out)
+			}
+			return
+		}
+
+		// Check if user signaled end of session
+		if len(lines) == 0 && strings.TrimSpace(line) == "exit" {
+			return
+		}
+
+		// Commands take a single line
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			editor.AddHistory(line)
+			s.command(strings.TrimSpace(line))
+			continue
+		}
+
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if strings.TrimSpace(source) == "" {
+			lines = nil
+			continue
+		}
+
+		// Keep reading while a block, call or grouping is open
+		if unbalanced(source) {
+			continue
+		}
+		lines = nil
+
+		editor.AddHistory(strings.Join(strings.Fields(source), " "))
+		if l.runEntry(source) {
+			s.inputs = append(s.inputs, terminate(source))
+		}
+	}
+}
+
+// runEntry executes a REPL entry, printing the value of a trailing
+// expression statement. It reports whether the entry ran without errors.
+func (l *Lox) runEntry(source string) bool {
+	value, echo, ok := l.evaluateEntry(source)
+	if ok && echo {
+		fmt.Fprintln(l.out, common.Stringfy(value))
+	}
+
+	return ok
+}
+
+// evaluateEntry executes a REPL entry. If the entry ends with an expression
+// statement other than an assignment, echo is set and the value of the
+// expression is returned. ok reports whether the entry ran without errors.
+func (l *Lox) evaluateEntry(source string) (value interface{}, echo bool, ok bool) {
+	defer func() {
+		l.HadError = false
+		l.HadRuntimeError = false
+	}()
+
+	// Entries holding nothing but comments do nothing
+	if tokens := scanQuietly(source); len(tokens) < 2 {
+		return nil, false, false
+	}
+
+	statements := l.parse(terminate(source))
+	if l.HadError {
+		return nil, false, false
+	}
+
+	n := len(statements)
+	if n > 0 {
+		if stmt, isExpr := statements[n-1].(*ast.Expression); isExpr && echoes(stmt.Expression) {
+			value := l.executeForValue(statements[:n-1], stmt.Expression)
+			return value, true, !l.HadError && !l.HadRuntimeError
+		}
+	}
+
+	l.execute(statements)
+	return nil, false, !l.HadError && !l.HadRuntimeError
+}
+
+// executeForValue runs resolved statements followed by expr and returns the
+// value of expr. The value is passed out through a hidden global so both
+// engines can run it.
+func (l *Lox) executeForValue(statements []ast.Stmt, expr ast.Expr) interface{} {
+	statements = append(statements, &ast.Var{
+		Name:        token.New(token.IDENTIFIER, evaluatedName, nil, 1),
+		Initializer: expr,
+	})
+	l.execute(statements)
+
+	globals := l.globals()
+	value := globals[evaluatedName]
+	delete(globals, evaluatedName)
+
+	return value
+}
+
+// echoes reports whether the value of an expression statement is printed.
+// Assignments are statements in all but name, so they stay quiet.
+func echoes(expr ast.Expr) bool {
+	switch expr.(type) {
+	case *ast.Assign, *ast.Set:
+		return false
+	}
+
+	return true
+}
+
+// terminate adds the semicolon that may be left out at the end of an entry,
+// on a line of its own if the last line ends in a comment.
+func terminate(source string) string {
+	tokens := scanQuietly(source)
+	if len(tokens) < 2 {
+		return source
+	}
+
+	if t := tokens[len(tokens)-2].Type; t == token.SEMICOLON || t == token.RIGHT_BRACE {
+		return source
+	}
+
+	lastLine := source[strings.LastIndex(source, "\n")+1:]
+	if strings.Contains(lastLine, "//") {
+		return source + "\n;"
+	}
+
+	return source + ";"
+}
+
+// unbalanced reports whether source opens more braces or parentheses than it
+// closes.
+func unbalanced(source string) bool {
+	depth := 0
+	for _, t := range scanQuietly(source) {
+		switch t.Type {
+		case token.LEFT_BRACE, token.LEFT_PAREN:
+			depth++
+		case token.RIGHT_BRACE, token.RIGHT_PAREN:
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+// scanQuietly scans source without reporting errors, which the parse that
+// follows reports instead.
+func scanQuietly(source string) []*token.Token {
+	quiet := &Lox{out: bufio.NewWriter(io.Discard), errOut: io.Discard}
+	return NewScanner(quiet, source).ScanTokens()
+}
@@ -0,0 +1,186 @@
+package lox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/lineedit"
+)
+
+func TestPrompt(t *testing.T) {
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+
+		var out, errOut strings.Builder
+		l.SetOutput(&out)
+		l.SetErrorOutput(&errOut)
+
+		input := strings.Join([]string{
+			`1 + 2`,
+			`fun greet(name) {`,
+			`  return "hello " + name;`,
+			`}`,
+			`greet("lox")`,
+			`var a = 1`,
+			`a = 2`,
+			`a // the value of a`,
+			`print a;`,
+			`-nil`,
+			`"after the error"`,
+			`exit`,
+			`"never run"`,
+		}, "\n")
+
+		l.prompt(lineedit.New(strings.NewReader(input), io.Discard))
+		l.Flush()
+
+		want := "3\nhello lox\n2\n2\nafter the error\n"
+		if out.String() != want {
+			t.Errorf("engine %d: got output %q, want %q", engine, out.String(), want)
+		}
+
+		if !strings.Contains(errOut.String(), "operand must be a number") {
+			t.Errorf("engine %d: got errors %q", engine, errOut.String())
+		}
+	}
+}
+
+func TestUnbalanced(t *testing.T) {
+	tests := map[string]bool{
+		`print 1;`:              false,
+		`fun f() {`:             true,
+		`fun f() { return (1`:   true,
+		`fun f() { return 1; }`: false,
+		`print "{";`:            false,
+		`// {`:                  false,
+	}
+
+	for source, want := range tests {
+		if got := unbalanced(source); got != want {
+			t.Errorf("unbalanced(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	saved := filepath.Join(t.TempDir(), "session.lox")
+
+	l := New()
+	var out, errOut strings.Builder
+	l.SetOutput(&out)
+	l.SetErrorOutput(&errOut)
+
+	input := strings.Join([]string{
+		`class Point { init(x) { this.x = x; } }`,
+		`var p = Point(1)`,
+		`-nil`,
+		`:type p`,
+		`:type p.x`,
+		`:type Point`,
+		`:ast 1 + 2 * (3 - p.x)`,
+		`:save ` + saved,
+		`:reset`,
+		`:env`,
+		`:load ` + saved,
+		`p.x`,
+		`:type`,
+		`:nope`,
+	}, "\n")
+
+	l.prompt(lineedit.New(strings.NewReader(input), io.Discard))
+	l.Flush()
+
+	want := strings.Join([]string{
+		`instance of Point`,
+		`number`,
+		`class`,
+		`(; (+ 1 (* 2 (group (- 3 (. p x))))))`,
+		`Channel = <native fn>`,
+		`Mutex = <native fn>`,
+		`WaitGroup = <native fn>`,
+		`args = <native fn>`,
+		`assert = <native fn>`,
+		`assertEqual = <native fn>`,
+		`clock = <native fn>`,
+		`range = <native fn>`,
+		`select = <native fn>`,
+		`1`,
+		``, // the end of input
+		``,
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := "class Point { init(x) { this.x = x; } }\nvar p = Point(1);\n"
+	if string(data) != session {
+		t.Errorf("saved %q, want %q", data, session)
+	}
+
+	for _, message := range []string{"usage: :type <expr>", `unknown command ":nope"`} {
+		if !strings.Contains(errOut.String(), message) {
+			t.Errorf("errors %q don't include %q", errOut.String(), message)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+		l.SetOutput(io.Discard)
+		l.Run(`
+class Animal { speak() {} sleep() {} }
+class Dog < Animal { init() { this.name = "rex"; this.owner = Animal(); } fetch() {} }
+var dog = Dog();
+var sleepy = 1;
+`)
+
+		tests := []struct {
+			line  string
+			start int
+			want  []string
+		}{
+			{"wh", 0, []string{"while"}},
+			{"print sl", 6, []string{"sleepy"}},
+			{"dog.", 4, []string{"fetch", "init", "name", "owner", "sleep", "speak"}},
+			{"dog.s", 4, []string{"sleep", "speak"}},
+			{"dog.owner.sp", 10, []string{"speak"}},
+			{"dog.name.", 9, nil},
+			{"cat.", 4, nil},
+		}
+
+		for _, test := range tests {
+			start, got := l.complete(test.line, len(test.line))
+			if start != test.start || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("engine %d: complete(%q) = %d, %q, want %d, %q",
+					engine, test.line, start, got, test.start, test.want)
+			}
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := map[string]string{
+		`var a = 1;`:      colorKeyword + "var" + colorReset + " a = " + colorLiteral + "1" + colorReset + ";",
+		`print "hi" // c`: colorKeyword + "print" + colorReset + " " + colorString + `"hi"` + colorReset + " " + colorComment + "// c" + colorReset,
+		`a @ nil`:         "a " + colorError + "@" + colorReset + " " + colorLiteral + "nil" + colorReset,
+		`x = "open`:       "x = " + colorString + `"open` + colorReset,
+	}
+
+	for line, want := range tests {
+		if got := highlight(line); got != want {
+			t.Errorf("highlight(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
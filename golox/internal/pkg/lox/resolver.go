@@ -49,6 +49,9 @@ type Resolver struct {
 	currentFunction FunctionType
 	currentClass    ClassType
 
+	// generator is set while resolving the body of a generator
+	generator bool
+
 	// scopes stack is only used for local block scopes. Global variables are
 	// not tracked by the Resolver. If a variable cannot be fonud in scopes,
 	// then we assume it is global.
@@ -88,6 +91,14 @@ func (r *Resolver) resolveExpression(expression ast.Expr) {
 func (r *Resolver) resolveFunction(function *ast.Function, ftype FunctionType) {
 	enclosingFunction := r.currentFunction
 	r.currentFunction = ftype
+	enclosingGenerator := r.generator
+	r.generator = function.Generator
+
+	// An initializer returns its instance, not a generator
+	if ftype == FT_INITIALIZER && function.Generator {
+		r.runtime.ErrorTokenMessage(function.Name, "an initializer can't be "+
+			"a generator")
+	}
 
 	// Create new scope for function body (static analysis)
 	r.beginScope()
@@ -101,6 +112,7 @@ func (r *Resolver) resolveFunction(function *ast.Function, ftype FunctionType) {
 
 	r.endScope()
 	r.currentFunction = enclosingFunction
+	r.generator = enclosingGenerator
 }
 
 func (r *Resolver) beginScope() {
@@ -300,6 +312,11 @@ func (r *Resolver) VisitPrintStmt(stmt *ast.Print) (interface{}, error) {
 	return nil, nil
 }
 
+func (r *Resolver) VisitSpawnStmt(stmt *ast.Spawn) (interface{}, error) {
+	r.resolveExpression(stmt.Call)
+	return nil, nil
+}
+
 func (r *Resolver) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
 	// Check if we are inside a function
 	if r.currentFunction == FT_NONE {
@@ -312,6 +329,9 @@ func (r *Resolver) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
 		if r.currentFunction == FT_INITIALIZER {
 			r.runtime.ErrorTokenMessage(stmt.Keyword, "can't return a value "+
 				"from an initializer")
+		} else if r.generator {
+			r.runtime.ErrorTokenMessage(stmt.Keyword, "can't return a value "+
+				"from a generator")
 		}
 
 		r.resolveExpression(stmt.Value)
@@ -320,6 +340,48 @@ func (r *Resolver) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
 	return nil, nil
 }
 
+// VisitForInStmt resolves the body of a for-in loop in a scope of its own
+// binding the loop variable, as each iteration gets a fresh binding.
+func (r *Resolver) VisitForInStmt(stmt *ast.ForIn) (interface{}, error) {
+	r.resolveExpression(stmt.Iterable)
+
+	r.beginScope()
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+	r.resolveStatement(stmt.Body)
+	r.endScope()
+
+	return nil, nil
+}
+
+func (r *Resolver) VisitYieldStmt(stmt *ast.Yield) (interface{}, error) {
+	if r.currentFunction == FT_NONE {
+		r.runtime.ErrorTokenMessage(stmt.Keyword, "can't yield from "+
+			"top-level code")
+	}
+
+	if stmt.Value != nil {
+		r.resolveExpression(stmt.Value)
+	}
+
+	return nil, nil
+}
+
+func (r *Resolver) VisitTestStmt(stmt *ast.Test) (interface{}, error) {
+	if r.scopes.Len() != 0 {
+		r.runtime.ErrorTokenMessage(stmt.Keyword, "tests must be declared at "+
+			"the top level")
+		return nil, nil
+	}
+
+	// The body runs in its own environment, enclosed by the globals
+	r.beginScope()
+	r.resolveStatements(stmt.Body)
+	r.endScope()
+
+	return nil, nil
+}
+
 func (r *Resolver) VisitWhileStmt(stmt *ast.While) (interface{}, error) {
 	r.resolveExpression(stmt.Condition)
 	r.resolveStatement(stmt.Body)
@@ -3,6 +3,7 @@ package lox
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/mz1290/golox/internal/pkg/common"
 	"github.com/mz1290/golox/internal/pkg/token"
@@ -15,6 +16,12 @@ type Scanner struct {
 	start   int
 	current int
 	line    int
+
+	// file names the script in the tokens, when a program is made of several
+	file string
+
+	// comments makes the scanner emit COMMENT tokens
+	comments bool
 }
 
 func NewScanner(lox *Lox, source string) *Scanner {
@@ -28,13 +35,23 @@ func NewScanner(lox *Lox, source string) *Scanner {
 }
 
 func (s *Scanner) ScanTokens() []*token.Token {
+	// A first line such as "#!/usr/bin/env golox" lets a script run as a
+	// program
+	if strings.HasPrefix(s.source, "#!") {
+		for s.peek() != '\n' && !s.isAtEnd() {
+			s.advance()
+		}
+	}
+
 	for !s.isAtEnd() {
 		// We are at the beginning of the next lexeme.
 		s.start = s.current
 		s.scanToken()
 	}
 
-	s.tokens = append(s.tokens, token.New(token.EOF, "", nil, s.line))
+	eof := token.New(token.EOF, "", nil, s.line)
+	eof.File = s.file
+	s.tokens = append(s.tokens, eof)
 	return s.tokens
 }
 
@@ -99,6 +116,10 @@ func (s *Scanner) scanToken() {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+
+			if s.comments {
+				s.addToken(token.COMMENT, nil)
+			}
 		} else {
 			s.addToken(token.SLASH, nil)
 		}
@@ -114,7 +135,7 @@ func (s *Scanner) scanToken() {
 		} else if common.IsAlpha(c) {
 			s.identifier()
 		} else {
-			s.runtime.ErrorMessage(s.line, "unexpected character")
+			s.runtime.report(s.file, s.line, "", "unexpected character")
 		}
 	}
 }
@@ -153,8 +174,8 @@ func (s *Scanner) number() {
 	// Convert string to Go's float64
 	num, err := strconv.ParseFloat(s.source[s.start:s.current], 64)
 	if err != nil {
-		s.runtime.ErrorMessage(s.line, fmt.Sprintf("failed to convert %q to "+
-			"Lox number", s.source[s.start:s.current]))
+		s.runtime.report(s.file, s.line, "", fmt.Sprintf("failed to convert "+
+			"%q to Lox number", s.source[s.start:s.current]))
 		return
 	}
 
@@ -170,7 +191,7 @@ func (s *Scanner) string() {
 	}
 
 	if s.isAtEnd() {
-		s.runtime.ErrorMessage(s.line, "unterminated string")
+		s.runtime.report(s.file, s.line, "", "unterminated string")
 		return
 	}
 
@@ -224,5 +245,7 @@ func (s *Scanner) advance() byte {
 
 func (s *Scanner) addToken(t token.Type, literal interface{}) {
 	text := s.source[s.start:s.current]
-	s.tokens = append(s.tokens, token.New(t, text, literal, s.line))
+	tok := token.New(t, text, literal, s.line)
+	tok.File = s.file
+	s.tokens = append(s.tokens, tok)
 }
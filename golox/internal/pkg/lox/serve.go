@@ -0,0 +1,521 @@
+package lox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/vm"
+)
+
+// Server gives every connection accepted on a listener a REPL session. The
+// protocol is line based: each line a client sends is an entry, continued on
+// the following lines while its braces and parentheses are open, or a
+// command as in the REPL. "exit" closes the connection.
+//
+// In text mode the output and errors of each entry are written back as the
+// REPL shows them. The command ":json" switches a session to JSON mode, and
+// ":text" back, after which every entry is answered with a single line
+// holding a JSON object:
+//
+//	{"ok":true,"output":"","value":"3","type":"number"}
+//	{"ok":false,"output":"","error":"[line 1] RuntimeError: undefined variable \"y\""}
+//
+// value and type are present when the entry ends with an expression, and
+// output holds anything it printed.
+//
+// Sessions can't use the REPL commands that read or write files, since the
+// server runs whatever its clients send.
+type Server struct {
+	// shared is the Lox every session runs on, or nil when each session gets
+	// its own from isolated.
+	shared   *Lox
+	isolated func() *Lox
+
+	// program is the interpreter of the shared Lox's script, from which
+	// entries on the tree-walk interpreter are forked
+	program *Interpreter
+
+	// script is run by every isolated session before its first entry
+	script string
+
+	// JSON starts sessions in JSON mode.
+	JSON bool
+
+	// Timeout, when positive, limits how long an entry runs. On the VM,
+	// where the sessions sharing a Lox run their entries one at a time, it
+	// also limits how long an entry waits for its turn.
+	Timeout time.Duration
+
+	// turn is held by the script or entry running on a shared Lox whose
+	// engine is the VM. On the tree-walk interpreter entries take turns with
+	// the goroutines of the program instead.
+	turn chan struct{}
+}
+
+// outputs are where a goroutine of a served program, or an entry of one of
+// its sessions, writes. The Lox is switched to them, and to the interpreter
+// the entry runs on, when it takes its turn.
+type outputs struct {
+	interpreter     *Interpreter
+	out             *bufio.Writer
+	errOut          io.Writer
+	hadError        bool
+	hadRuntimeError bool
+}
+
+func (o *outputs) restore() {
+	l := o.interpreter.runtime
+	l.Interpreter = o.interpreter
+	l.out = o.out
+	l.errOut = o.errOut
+	l.HadError = o.hadError
+	l.HadRuntimeError = o.hadRuntimeError
+}
+
+func (o *outputs) save() {
+	l := o.interpreter.runtime
+	l.Flush()
+	o.hadError = l.HadError
+	o.hadRuntimeError = l.HadRuntimeError
+}
+
+// outputs returns where the goroutine running on i writes.
+func (s *scheduler) outputs(i *Interpreter) *outputs {
+	if i.outputs != nil {
+		return i.outputs
+	}
+
+	return s.program
+}
+
+// NewSharedServer returns a Server whose sessions take turns running entries
+// on l, so they see and change the same globals. On the tree-walk
+// interpreter its entries are goroutines of the program, which keep running
+// between them, and the program goes on writing where l did. l must not be
+// used directly once it is served.
+func NewSharedServer(l *Lox) *Server {
+	s := &Server{
+		shared:  l,
+		program: l.Interpreter,
+		turn:    make(chan struct{}, 1),
+	}
+
+	if l.Engine == ENGINE_TREE {
+		l.Interpreter.sched.serve(&outputs{
+			interpreter: l.Interpreter,
+			out:         l.out,
+			errOut:      l.errOut,
+		})
+	}
+
+	return s
+}
+
+// NewIsolatedServer returns a Server that gives each session the Lox that
+// newLox returns.
+func NewIsolatedServer(newLox func() *Lox) *Server {
+	return &Server{isolated: newLox}
+}
+
+// Start runs a script for the sessions to attach to. A shared Lox runs it in
+// the background, its sessions taking turns with it, and reports its errors
+// where it writes. Every isolated session runs it before its first entry, and
+// is ended with the errors it reports, if any. Static errors are reported
+// straight away, and Start returns false without running anything.
+func (s *Server) Start(source string) bool {
+	if s.shared == nil {
+		l := s.isolated()
+		l.Check(source)
+		if l.HadError {
+			return false
+		}
+
+		s.script = source
+		return true
+	}
+
+	l := s.shared
+	if l.Engine == ENGINE_VM {
+		s.turn <- struct{}{}
+
+		var function *vm.Function
+		if statements := l.parse(source); !l.HadError {
+			function = vm.NewCompiler(l).Compile(statements)
+		}
+		if l.HadError {
+			l.HadError = false
+			<-s.turn
+			return false
+		}
+
+		// The turn is handed over to the script
+		go func() {
+			l.VM.Interpret(function)
+			l.Flush()
+			l.HadRuntimeError = false
+			<-s.turn
+		}()
+		return true
+	}
+
+	sched := s.program.sched
+	sched.enter(s.program)
+	statements := l.parse(source)
+	if l.HadError {
+		l.HadError = false
+		sched.leave()
+		return false
+	}
+
+	go func() {
+		s.program.Interpret(statements)
+		l.HadRuntimeError = false
+		sched.leave()
+	}()
+	return true
+}
+
+// serveResult is the answer to an entry in JSON mode.
+type serveResult struct {
+	OK     bool    `json:"ok"`
+	Output string  `json:"output"`
+	Value  *string `json:"value,omitempty"`
+	Type   string  `json:"type,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Listen listens on address, either "unix:" followed by the path of a socket
+// or a TCP address on the loopback interface such as "localhost:7000", with
+// an optional "tcp:" prefix. Sessions can run any code, so other hosts are
+// refused and the socket is only accessible to its owner.
+func Listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+
+		listener, err := net.Listen("unix", path)
+		if err != nil && staleSocket(path) {
+			// A server that didn't shut down cleanly left its socket behind
+			os.Remove(path)
+			listener, err = net.Listen("unix", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Chmod(path, 0600); err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		return listener, nil
+	}
+
+	address = strings.TrimPrefix(address, "tcp:")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "" {
+		host = "127.0.0.1"
+	} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("refusing to listen on %q: only loopback addresses are allowed", host)
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+// staleSocket reports whether path is a socket nothing is listening on.
+func staleSocket(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+
+	return true
+}
+
+// Serve accepts connections on listener, serving each one on its own
+// goroutine. Once the listener is closed it closes the connections, waits
+// for their sessions to end and returns nil.
+func (s *Server) Serve(listener net.Listener) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool)
+	)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			mu.Lock()
+			for conn := range conns {
+				conn.Close()
+			}
+			mu.Unlock()
+			wg.Wait()
+
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeConn(conn)
+
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// ServeConn runs a session on conn until the client sends "exit" or stops
+// sending.
+func (s *Server) ServeConn(conn io.ReadWriter) {
+	jsonMode := s.JSON
+	w := bufio.NewWriter(conn)
+
+	l := s.shared
+	if l == nil {
+		l = s.isolated()
+		if result := s.setup(l); !result.OK {
+			writeAnswer(w, result, jsonMode)
+			w.Flush()
+			return
+		}
+	}
+
+	sess := &session{l: l, served: true, shared: s.shared != nil}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(lines) == 0 {
+			switch strings.TrimSpace(line) {
+			case "exit":
+				w.Flush()
+				return
+			case ":json", ":text":
+				jsonMode = strings.TrimSpace(line) == ":json"
+				if jsonMode {
+					writeResult(w, serveResult{OK: true})
+				}
+				w.Flush()
+				continue
+			}
+		}
+
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if strings.TrimSpace(source) == "" {
+			lines = nil
+			continue
+		}
+
+		command := len(lines) == 1 && strings.HasPrefix(strings.TrimSpace(line), ":")
+		if !command && unbalanced(source) {
+			continue
+		}
+		lines = nil
+
+		writeAnswer(w, s.evaluate(sess, source, command), jsonMode)
+		w.Flush()
+	}
+}
+
+// setup runs the script on the Lox of an isolated session. Only the errors
+// it reports are shown to the client.
+func (s *Server) setup(l *Lox) serveResult {
+	if s.script == "" {
+		return serveResult{OK: true}
+	}
+
+	var errOut strings.Builder
+	l.SetOutput(io.Discard)
+	l.SetErrorOutput(&errOut)
+
+	s.limit(l.interrupter(), func() { l.Run(s.script) })
+	result := serveResult{
+		OK:    !l.HadError && !l.HadRuntimeError,
+		Error: errOut.String(),
+	}
+
+	l.HadError = false
+	l.HadRuntimeError = false
+	l.SetErrorOutput(io.Discard)
+	return result
+}
+
+// evaluate runs an entry or command of a session, capturing what it writes.
+func (s *Server) evaluate(sess *session, source string, command bool) serveResult {
+	l := sess.l
+
+	var result serveResult
+	var out, errOut strings.Builder
+	run := func() {
+		if command {
+			sess.command(strings.TrimSpace(source))
+			result.OK = errOut.Len() == 0
+		} else {
+			value, echo, ok := l.evaluateEntry(source)
+			if ok {
+				sess.inputs = append(sess.inputs, terminate(source))
+			}
+
+			result.OK = ok
+			if ok && echo {
+				str := common.Stringfy(value)
+				result.Value = &str
+				result.Type = typeName(value)
+			}
+		}
+
+		l.Flush()
+	}
+
+	switch {
+	case s.shared != nil && l.Engine == ENGINE_TREE:
+		// The entry runs as a goroutine of the program with outputs of its
+		// own, which the scheduler switches to when it takes its turn
+		entry := s.program.fork()
+		entry.outputs = &outputs{
+			interpreter: entry,
+			out:         bufio.NewWriter(&out),
+			errOut:      &errOut,
+		}
+
+		s.limit(entry, func() {
+			entry.sched.enter(entry)
+			defer entry.sched.leave()
+			run()
+		})
+	case s.shared != nil:
+		if !s.wait() {
+			return serveResult{Error: fmt.Sprintf("RuntimeError: timed out "+
+				"after %s waiting for the entries before it\n", s.Timeout)}
+		}
+		defer func() { <-s.turn }()
+		fallthrough
+	default:
+		l.SetOutput(&out)
+		l.SetErrorOutput(&errOut)
+		s.limit(l.interrupter(), run)
+
+		// Leave nothing pointing at this request's buffers
+		l.SetOutput(io.Discard)
+		l.SetErrorOutput(io.Discard)
+	}
+
+	result.Output = out.String()
+	result.Error = errOut.String()
+	return result
+}
+
+// wait takes the turn to run an entry on a shared Lox whose engine is the VM,
+// and reports whether it got it before the timeout.
+func (s *Server) wait() bool {
+	if s.Timeout <= 0 {
+		s.turn <- struct{}{}
+		return true
+	}
+
+	timer := time.NewTimer(s.Timeout)
+	defer timer.Stop()
+
+	select {
+	case s.turn <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// An interrupter stops the code running on an engine.
+type interrupter interface {
+	Interrupt(err error)
+}
+
+// interrupter returns the engine l runs code on.
+func (l *Lox) interrupter() interrupter {
+	if l.Engine == ENGINE_VM {
+		return l.VM
+	}
+
+	return l.Interpreter
+}
+
+// limit runs f, interrupting the code it runs on target once the timeout has
+// passed.
+func (s *Server) limit(target interrupter, f func()) {
+	if s.Timeout <= 0 {
+		f()
+		return
+	}
+
+	fired := make(chan struct{})
+	timer := time.AfterFunc(s.Timeout, func() {
+		defer close(fired)
+		target.Interrupt(fmt.Errorf("timed out after %s", s.Timeout))
+	})
+
+	f()
+
+	// Interrupting the tree-walk interpreter takes a turn, so wait for it
+	// only once f has given up its own
+	if !timer.Stop() {
+		<-fired
+	}
+	target.Interrupt(nil)
+}
+
+// writeAnswer writes the answer to an entry as a JSON object, or as the REPL
+// shows it.
+func writeAnswer(w *bufio.Writer, result serveResult, jsonMode bool) {
+	if jsonMode {
+		writeResult(w, result)
+		return
+	}
+
+	w.WriteString(result.Output)
+	if result.Value != nil {
+		w.WriteString(*result.Value + "\n")
+	}
+	w.WriteString(result.Error)
+}
+
+func writeResult(w io.Writer, result serveResult) {
+	result.Error = strings.TrimRight(result.Error, "\n")
+
+	// Lox values such as "<fn f>" read better unescaped
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.Encode(result)
+}
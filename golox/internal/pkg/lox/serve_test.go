@@ -0,0 +1,282 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// conn feeds a session its input and collects what it answers.
+type conn struct {
+	io.Reader
+	strings.Builder
+}
+
+func serveLines(s *Server, lines ...string) string {
+	c := &conn{Reader: strings.NewReader(strings.Join(lines, "\n") + "\n")}
+	s.ServeConn(c)
+	return c.String()
+}
+
+func TestServeText(t *testing.T) {
+	l := New()
+	l.Run(`var greeting = "hello";`)
+	s := NewSharedServer(l)
+
+	got := serveLines(s,
+		`greeting + " world"`,
+		`fun twice(n) {`,
+		`  return n * 2;`,
+		`}`,
+		`print twice(21);`,
+		`missing`,
+		`:type twice`,
+		`exit`,
+		`"never run"`,
+	)
+
+	want := "hello world\n42\n[line 1] RuntimeError: undefined variable \"missing\"\nfunction\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	s := NewIsolatedServer(New)
+	s.JSON = true
+
+	got := serveLines(s,
+		`var a = 1;`,
+		`print a; a + 1`,
+		`a.b`,
+		`:text`,
+		`a`,
+	)
+
+	want := strings.Join([]string{
+		`{"ok":true,"output":""}`,
+		`{"ok":true,"output":"1\n","value":"2","type":"number"}`,
+		`{"ok":false,"output":"","error":"[line 1] RuntimeError: only instances have properties"}`,
+		`1`,
+		``,
+	}, "\n")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Every session of an isolated server starts afresh
+	if got := serveLines(s, `a`); !strings.Contains(got, `undefined variable \"a\"`) {
+		t.Errorf("second session got %q", got)
+	}
+}
+
+func TestServeShared(t *testing.T) {
+	l := New()
+	l.Run(`var count = 0;`)
+	s := NewSharedServer(l)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lines := make([]string, 100)
+			for i := range lines {
+				lines[i] = `count = count + 1;`
+			}
+			serveLines(s, lines...)
+		}()
+	}
+	wg.Wait()
+
+	if got := serveLines(s, `count`); got != "1000\n" {
+		t.Errorf("got count %q, want 1000", got)
+	}
+}
+
+func TestListenRefusesOtherHosts(t *testing.T) {
+	for _, address := range []string{"example.com:7000", "tcp:10.0.0.1:7000"} {
+		if listener, err := Listen(address); err == nil {
+			listener.Close()
+			t.Errorf("Listen(%q) succeeded", address)
+		}
+	}
+
+	listener, err := Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+}
+
+// Clients can't get at the server's files, nor replace an interpreter other
+// sessions share.
+func TestServeCommands(t *testing.T) {
+	for _, s := range []*Server{NewSharedServer(New()), NewIsolatedServer(New)} {
+		got := serveLines(s,
+			`:load /etc/passwd`,
+			`:save /tmp/lox-serve-test.lox`,
+		)
+
+		want := ":load is not available in served sessions\n" +
+			":save is not available in served sessions\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if help := serveLines(s, `:help`); strings.Contains(help, ":load") ||
+			strings.Contains(help, ":save") || !strings.Contains(help, ":env") {
+			t.Errorf("got help %q", help)
+		}
+	}
+
+	s := NewSharedServer(New())
+	if got, want := serveLines(s, `:reset`), ":reset is not available in shared sessions\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Sessions attach to the program while its script runs, and its goroutines
+// keep running between entries.
+func TestServeAttach(t *testing.T) {
+	l := New()
+	var errOut strings.Builder
+	l.SetOutput(io.Discard)
+	l.SetErrorOutput(&errOut)
+	s := NewSharedServer(l)
+	s.Timeout = 100 * time.Millisecond
+
+	ok := s.Start(strings.Join([]string{
+		`var total = 0;`,
+		`var numbers = Channel(0);`,
+		`var totals = Channel(0);`,
+		`fun sum() {`,
+		`  for (;;) {`,
+		`    total = total + numbers.receive();`,
+		`    totals.send(total);`,
+		`  }`,
+		`}`,
+		`spawn sum();`,
+		`var running = true;`,
+		`var n = 0;`,
+		`while (running) n = n + 1;`,
+	}, "\n"))
+	if !ok {
+		t.Fatalf("got errors %q", errOut.String())
+	}
+
+	got := serveLines(s,
+		`n > 0`,
+		`running = false;`,
+		`numbers.send(2);`,
+		`totals.receive()`,
+		`numbers.send(3);`,
+		`totals.receive()`,
+	)
+
+	if want := "true\nnil\n2\nnil\n5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A goroutine's runtime error is reported by the program, and only ends
+	// that goroutine
+	got = serveLines(s,
+		`numbers.send(nil);`,
+		`totals.receive()`,
+		`total`,
+	)
+
+	want := "nil\n[line 1] RuntimeError: timed out after 100ms\n5\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	want = "[line 6] RuntimeError: operands must be two numbers or two strings\n"
+	if errOut.String() != want {
+		t.Errorf("got program errors %q, want %q", errOut.String(), want)
+	}
+}
+
+func TestServeTimeout(t *testing.T) {
+	for _, engine := range []Engine{ENGINE_TREE, ENGINE_VM} {
+		l := New()
+		l.Engine = engine
+		s := NewSharedServer(l)
+		s.Timeout = 50 * time.Millisecond
+
+		got := serveLines(s,
+			`var n = 0;`,
+			`while (true) n = n + 1;`,
+			`n > 0`,
+		)
+
+		want := "RuntimeError: timed out after 50ms\ntrue\n"
+		if got != want {
+			t.Errorf("engine %d: got %q, want %q", engine, got, want)
+		}
+	}
+
+	// An entry blocked forever gives up its turn, and times out too
+	s := NewSharedServer(New())
+	s.Timeout = 50 * time.Millisecond
+	if got, want := serveLines(s, `Channel(0).receive();`, `1`),
+		"[line 1] RuntimeError: timed out after 50ms\n1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Entries on a shared VM run one at a time, so they time out waiting for a
+// script that doesn't finish.
+func TestServeTimeoutWaiting(t *testing.T) {
+	l := New()
+	l.Engine = ENGINE_VM
+	l.SetErrorOutput(io.Discard)
+	s := NewSharedServer(l)
+	s.Timeout = 50 * time.Millisecond
+
+	if !s.Start(`while (true) {}`) {
+		t.Fatal("script doesn't compile")
+	}
+	defer l.VM.Interrupt(fmt.Errorf("test ended"))
+
+	want := "RuntimeError: timed out after 50ms waiting for the entries before it\n"
+	if got := serveLines(s, `1`); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServeScriptErrors(t *testing.T) {
+	l := New()
+	var errOut strings.Builder
+	l.SetErrorOutput(&errOut)
+	if NewSharedServer(l).Start(`print ;`) {
+		t.Error("script with a syntax error started")
+	}
+	if errOut.Len() == 0 {
+		t.Error("syntax error not reported")
+	}
+
+	s := NewIsolatedServer(func() *Lox {
+		l := New()
+		l.SetErrorOutput(io.Discard)
+		return l
+	})
+	if s.Start(`var a = ;`) {
+		t.Error("isolated script with a syntax error started")
+	}
+
+	// Each isolated session runs the script, and ends if it fails
+	s.JSON = true
+	if !s.Start(`var a = 1; print a.b;`) {
+		t.Fatal("script didn't start")
+	}
+
+	got := serveLines(s, `a`)
+	want := `{"ok":false,"output":"","error":"[line 1] RuntimeError: only instances have properties"}` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
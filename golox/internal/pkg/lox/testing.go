@@ -0,0 +1,112 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+)
+
+// TestResult is the outcome of one test declaration.
+type TestResult struct {
+	Name    string
+	Line    int
+	Elapsed time.Duration
+	Err     error // nil when the test passed
+}
+
+// Passed reports whether the test ran without an error.
+func (r TestResult) Passed() bool {
+	return r.Err == nil
+}
+
+// RunTests runs every test declared at the top level of source, which was
+// read from path:
+//
+//	test "adds numbers" {
+//	  assertEqual(1 + 2, 3);
+//	}
+//
+// Each test gets a fresh interpreter that runs the rest of the script first,
+// so tests see its functions and classes but never each other's changes to
+// globals. Only what the test itself prints is written to the output. A test
+// fails if it raises a runtime error, such as a failed assertion, which
+// doesn't stop the tests after it. done is called as each test finishes.
+//
+// Tests only run on the tree-walk interpreter. Callers must check HadError,
+// which is set when source has syntax or semantic errors and nothing ran.
+func (l *Lox) RunTests(path, source string, done func(TestResult)) {
+	l.path = path
+	statements := l.parse(source)
+	if l.HadError {
+		return
+	}
+
+	for n, test := range tests(statements) {
+		start := time.Now()
+		err := l.runTest(source, n)
+
+		done(TestResult{
+			Name:    test.Name.Literal.(string),
+			Line:    test.Keyword.Line,
+			Elapsed: time.Since(start),
+			Err:     err,
+		})
+	}
+}
+
+// runTest runs the nth test of source on a new interpreter.
+func (l *Lox) runTest(source string, n int) (err error) {
+	t := New()
+	t.Optimize = l.Optimize
+	t.Debug = l.Debug
+	t.Coverage = l.Coverage
+	t.path = l.path
+	t.SetOutput(io.Discard)
+	t.SetErrorOutput(l.errOut)
+	defer t.Flush()
+
+	// A bug in the interpreter fails the test rather than the whole run
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// Goroutines the test spawns are stopped when it ends, and fail it if
+	// they failed
+	sched := t.Interpreter.sched
+	sched.begin(t.Interpreter)
+	defer sched.end()
+	defer func() {
+		if err == nil || err == errStopped {
+			err = sched.failure
+		}
+	}()
+
+	statements := t.parse(source)
+	t.installTools()
+	for _, stmt := range statements {
+		if _, err := t.Interpreter.execute(stmt); err != nil {
+			return err
+		}
+	}
+
+	t.SetOutput(l.Output())
+	i := t.Interpreter
+	_, err = i.executeBlock(tests(statements)[n].Body,
+		NewLocalEnvironment(i.globals))
+	return err
+}
+
+func tests(statements []ast.Stmt) []*ast.Test {
+	var tests []*ast.Test
+	for _, stmt := range statements {
+		if test, ok := stmt.(*ast.Test); ok {
+			tests = append(tests, test)
+		}
+	}
+
+	return tests
+}
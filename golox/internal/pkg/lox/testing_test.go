@@ -0,0 +1,96 @@
+package lox
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+const testScript = `
+var counter = 0;
+var test = "test is still an identifier";
+print "setup";
+
+test "passes" {
+  counter = counter + 1;
+  assertEqual(counter, 1);
+  print "inside";
+}
+
+test "sees fresh globals" {
+  assertEqual(counter, 0);
+}
+
+test "fails an assertion" {
+  assert(counter > 0, "counter is zero");
+}
+
+test "fails with a runtime error" {
+  nil.field;
+}
+`
+
+func TestRunTests(t *testing.T) {
+	var out bytes.Buffer
+	l := New()
+	l.SetOutput(&out)
+	l.SetErrorOutput(io.Discard)
+
+	var results []TestResult
+	l.RunTests("testing.lox", testScript, func(r TestResult) { results = append(results, r) })
+	l.Flush()
+
+	if l.HadError {
+		t.Fatal("unexpected static error")
+	}
+
+	expected := []struct {
+		name  string
+		error string
+	}{
+		{"passes", ""},
+		{"sees fresh globals", ""},
+		{"fails an assertion", "[line 17] RuntimeError: assertion failed: counter is zero"},
+		{"fails with a runtime error", "[line 21] RuntimeError: only instances have properties"},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("got %d results, expected %d", len(results), len(expected))
+	}
+
+	for n, e := range expected {
+		r := results[n]
+		if r.Name != e.name {
+			t.Errorf("test %d is named %q, expected %q", n, r.Name, e.name)
+		}
+
+		var message string
+		if !r.Passed() {
+			message = RuntimeErrorMessage(r.Err)
+		}
+		if message != e.error {
+			t.Errorf("%s: got error %q, expected %q", e.name, message, e.error)
+		}
+	}
+
+	// Setup output is discarded, only tests print
+	if out.String() != "inside\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestRunIgnoresTests(t *testing.T) {
+	var out bytes.Buffer
+	l := New()
+	l.SetOutput(&out)
+	l.SetErrorOutput(io.Discard)
+
+	l.Run(testScript)
+
+	if l.HadError || l.HadRuntimeError {
+		t.Fatal("unexpected error")
+	}
+	if out.String() != "setup\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
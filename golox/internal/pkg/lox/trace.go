@@ -0,0 +1,162 @@
+package lox
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+)
+
+// maxTraceArgument is the longest an argument is shown in a trace.
+const maxTraceArgument = 40
+
+// Trace writes a timeline of the calls a script makes in the Chrome Trace
+// Event format, which chrome://tracing and https://ui.perfetto.dev load.
+// Every call of a function, method or class is a pair of begin and end
+// events, nested inside a span for the whole script:
+//
+//	{"name":"Point.init","cat":"method","ph":"B","ts":12.5,"pid":1,"tid":1,
+//	 "args":{"class":"Point","file":"app.lox","line":7,"arguments":["1","2"]}}
+//
+// Events are written as they happen, so a trace costs little memory however
+// long the script runs.
+type Trace struct {
+	noTool
+
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+	path  string
+	err   error
+
+	// events counts the events written so far
+	events int
+}
+
+type traceEvent struct {
+	Name  string     `json:"name"`
+	Cat   string     `json:"cat,omitempty"`
+	Phase string     `json:"ph"`
+	TS    float64    `json:"ts"` // microseconds since the trace started
+	PID   int        `json:"pid"`
+	TID   int        `json:"tid"`
+	Args  *traceArgs `json:"args,omitempty"`
+}
+
+type traceArgs struct {
+	Class     string   `json:"class,omitempty"`
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	Arguments []string `json:"arguments,omitempty"`
+}
+
+// NewTrace creates the trace file out.
+func NewTrace(out string) (*Trace, error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Trace{file: f, w: bufio.NewWriter(f)}
+	t.w.WriteString(`{"displayTimeUnit":"ms","traceEvents":[`)
+	return t, nil
+}
+
+// begin starts the span of the script at path.
+func (t *Trace) begin(path string) {
+	t.path = path
+	t.start = time.Now()
+	t.write(traceEvent{
+		Name:  "script",
+		Cat:   "script",
+		Phase: "B",
+		Args:  &traceArgs{File: path, Line: 1},
+	})
+}
+
+func (t *Trace) enter(frame *callFrame) {
+	cat := "function"
+	if _, ok := frame.key.(*Class); ok {
+		cat = "class"
+	} else if frame.class != "" {
+		cat = "method"
+	}
+
+	arguments := make([]string, len(frame.arguments))
+	for n, argument := range frame.arguments {
+		arguments[n] = summarize(argument)
+	}
+
+	t.write(traceEvent{
+		Name:  frame.name,
+		Cat:   cat,
+		Phase: "B",
+		Args: &traceArgs{
+			Class:     frame.class,
+			File:      t.path,
+			Line:      frame.line,
+			Arguments: arguments,
+		},
+	})
+}
+
+func (t *Trace) leave(frame *callFrame) {
+	t.write(traceEvent{Name: frame.name, Phase: "E"})
+}
+
+// summarize returns how an argument is shown, cut short if it is long.
+func summarize(value interface{}) string {
+	s := common.Stringfy(value)
+	if common.IsString(value) {
+		s = `"` + s + `"`
+	}
+
+	if runes := []rune(s); len(runes) > maxTraceArgument {
+		s = strings.TrimSpace(string(runes[:maxTraceArgument-3])) + "..."
+	}
+
+	return s
+}
+
+func (t *Trace) write(event traceEvent) {
+	if t.err != nil {
+		return
+	}
+
+	event.TS = float64(time.Since(t.start).Nanoseconds()) / 1000
+	event.PID, event.TID = 1, 1
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	if t.events > 0 {
+		t.w.WriteByte(',')
+	}
+	t.w.WriteByte('\n')
+	_, t.err = t.w.Write(data)
+	t.events++
+}
+
+// Close ends the script's span and finishes the trace file.
+func (t *Trace) Close() error {
+	if t.path != "" {
+		t.write(traceEvent{Name: "script", Phase: "E"})
+	}
+	t.w.WriteString("\n]}\n")
+
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+
+	if err := t.file.Close(); t.err == nil {
+		t.err = err
+	}
+
+	return t.err
+}
@@ -0,0 +1,88 @@
+package lox
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const traceScript = `class Greeter {
+  greet(name) { return "hello " + name; }
+}
+
+fun run() {
+  Greeter().greet("a very long name that will not fit in the trace");
+}
+
+run();
+nil.field;
+`
+
+func TestTrace(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "trace.json")
+
+	trace, err := NewTrace(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New()
+	l.SetOutput(io.Discard)
+	l.SetErrorOutput(io.Discard)
+	l.Trace = trace
+	trace.begin("greeter.lox")
+	l.Run(traceScript)
+
+	// The trace is finished even though the script failed
+	if !l.HadRuntimeError {
+		t.Fatal("expected a runtime error")
+	}
+	if err := trace.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("invalid trace: %v\n%s", err, data)
+	}
+
+	var events []string
+	for _, e := range document.TraceEvents {
+		events = append(events, e.Phase+" "+e.Name)
+	}
+
+	expected := []string{
+		"B script",
+		"B run",
+		"B Greeter",
+		"E Greeter",
+		"B Greeter.greet",
+		"E Greeter.greet",
+		"E run",
+		"E script",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("got events %q, expected %q", events, expected)
+	}
+
+	greet := document.TraceEvents[4]
+	args := traceArgs{
+		Class:     "Greeter",
+		File:      "greeter.lox",
+		Line:      2,
+		Arguments: []string{`"a very long name that will not fit i...`},
+	}
+	if greet.Cat != "method" || !reflect.DeepEqual(*greet.Args, args) {
+		t.Errorf("unexpected event %+v %+v", greet, *greet.Args)
+	}
+}
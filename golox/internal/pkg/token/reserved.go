@@ -18,4 +18,5 @@ var Keywords = map[string]Type{
 	"true":   TRUE,
 	"var":    VAR,
 	"while":  WHILE,
+	"yield":  YIELD,
 }
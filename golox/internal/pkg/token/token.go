@@ -9,10 +9,29 @@ type Token struct {
 	Lexeme  string
 	Literal interface{}
 	Line    int
+
+	// File names the script the token was scanned from when a program is
+	// made of several, and is empty otherwise.
+	File string
 }
 
 func New(t Type, lexeme string, literal interface{}, line int) *Token {
-	return &Token{t, lexeme, literal, line}
+	return &Token{Type: t, Lexeme: lexeme, Literal: literal, Line: line}
+}
+
+// Position describes where the token is for error reports, as "line 3" or,
+// when it has a file, "a.lox:3".
+func (t *Token) Position() string {
+	return Position(t.File, t.Line)
+}
+
+// Position describes a line of file, which may be empty, for error reports.
+func Position(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+
+	return fmt.Sprintf("%s:%d", file, line)
 }
 
 func (t Token) String() string {
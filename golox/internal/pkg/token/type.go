@@ -48,8 +48,12 @@ const (
 	TRUE
 	VAR
 	WHILE
+	YIELD
 
 	EOF
+
+	// Only scanned for tools, such as the formatter, that keep comments
+	COMMENT
 )
 
 func (t Type) String() string {
@@ -130,8 +134,12 @@ func (t Type) String() string {
 		return "VAR"
 	case WHILE:
 		return "WHILE"
+	case YIELD:
+		return "YIELD"
 	case EOF:
 		return "EOF"
+	case COMMENT:
+		return "COMMENT"
 	default:
 		return "UNKNOWN"
 	}
@@ -0,0 +1,195 @@
+package vm
+
+// A "chunk" refers to a sequence of bytecode. Bytecode is a linear sequence of
+// binary instructions that the VM decodes and executes one at a time.
+
+// Each instruction has a one-byte operation code ("opcode"). This number
+// controls what kind of instruction we're dealing with.
+type OpCode byte
+
+const (
+	OP_CONSTANT OpCode = iota
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_POP
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	OP_GET_GLOBAL
+	OP_DEFINE_GLOBAL
+	OP_SET_GLOBAL
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	OP_GET_PROPERTY
+	OP_SET_PROPERTY
+	OP_GET_SUPER
+	OP_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_NOT
+	OP_NEGATE
+	OP_PRINT
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_LOOP
+	OP_CALL
+	OP_INVOKE
+	OP_SUPER_INVOKE
+	OP_CLOSURE
+	OP_CLOSE_UPVALUE
+	OP_RETURN
+	OP_CLASS
+	OP_INHERIT
+	OP_METHOD
+	OP_ITERATOR
+)
+
+func (op OpCode) String() string {
+	switch op {
+	case OP_CONSTANT:
+		return "OP_CONSTANT"
+	case OP_NIL:
+		return "OP_NIL"
+	case OP_TRUE:
+		return "OP_TRUE"
+	case OP_FALSE:
+		return "OP_FALSE"
+	case OP_POP:
+		return "OP_POP"
+	case OP_GET_LOCAL:
+		return "OP_GET_LOCAL"
+	case OP_SET_LOCAL:
+		return "OP_SET_LOCAL"
+	case OP_GET_GLOBAL:
+		return "OP_GET_GLOBAL"
+	case OP_DEFINE_GLOBAL:
+		return "OP_DEFINE_GLOBAL"
+	case OP_SET_GLOBAL:
+		return "OP_SET_GLOBAL"
+	case OP_GET_UPVALUE:
+		return "OP_GET_UPVALUE"
+	case OP_SET_UPVALUE:
+		return "OP_SET_UPVALUE"
+	case OP_GET_PROPERTY:
+		return "OP_GET_PROPERTY"
+	case OP_SET_PROPERTY:
+		return "OP_SET_PROPERTY"
+	case OP_GET_SUPER:
+		return "OP_GET_SUPER"
+	case OP_EQUAL:
+		return "OP_EQUAL"
+	case OP_GREATER:
+		return "OP_GREATER"
+	case OP_GREATER_EQUAL:
+		return "OP_GREATER_EQUAL"
+	case OP_LESS:
+		return "OP_LESS"
+	case OP_LESS_EQUAL:
+		return "OP_LESS_EQUAL"
+	case OP_ADD:
+		return "OP_ADD"
+	case OP_SUBTRACT:
+		return "OP_SUBTRACT"
+	case OP_MULTIPLY:
+		return "OP_MULTIPLY"
+	case OP_DIVIDE:
+		return "OP_DIVIDE"
+	case OP_NOT:
+		return "OP_NOT"
+	case OP_NEGATE:
+		return "OP_NEGATE"
+	case OP_PRINT:
+		return "OP_PRINT"
+	case OP_JUMP:
+		return "OP_JUMP"
+	case OP_JUMP_IF_FALSE:
+		return "OP_JUMP_IF_FALSE"
+	case OP_LOOP:
+		return "OP_LOOP"
+	case OP_CALL:
+		return "OP_CALL"
+	case OP_INVOKE:
+		return "OP_INVOKE"
+	case OP_SUPER_INVOKE:
+		return "OP_SUPER_INVOKE"
+	case OP_CLOSURE:
+		return "OP_CLOSURE"
+	case OP_CLOSE_UPVALUE:
+		return "OP_CLOSE_UPVALUE"
+	case OP_RETURN:
+		return "OP_RETURN"
+	case OP_CLASS:
+		return "OP_CLASS"
+	case OP_INHERIT:
+		return "OP_INHERIT"
+	case OP_METHOD:
+		return "OP_METHOD"
+	case OP_ITERATOR:
+		return "OP_ITERATOR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Chunk is the data structure used to store data with instructions. Lines is
+// parallel to Code and records the source line each byte was compiled from so
+// runtime errors can be reported against the original script.
+type Chunk struct {
+	Code      []byte
+	Lines     []int
+	Constants []interface{}
+
+	// files records where the code of each script starts, in programs made
+	// of several. It isn't written to compiled files, which hold one script.
+	files []fileStart
+}
+
+// fileStart is the offset in a chunk at which the code of a script starts.
+type fileStart struct {
+	offset int
+	file   string
+}
+
+func NewChunk() *Chunk {
+	return &Chunk{}
+}
+
+// Write appends a byte to the end of the chunk.
+func (c *Chunk) Write(b byte, line int) {
+	c.Code = append(c.Code, b)
+	c.Lines = append(c.Lines, line)
+}
+
+// setFile records that the bytes written from now on were compiled from file.
+func (c *Chunk) setFile(file string) {
+	if c.file(len(c.Code)) != file {
+		c.files = append(c.files, fileStart{len(c.Code), file})
+	}
+}
+
+// file returns the script the byte at offset was compiled from, or "" if the
+// program is a single script.
+func (c *Chunk) file(offset int) string {
+	file := ""
+	for _, start := range c.files {
+		if start.offset > offset {
+			break
+		}
+		file = start.file
+	}
+
+	return file
+}
+
+// AddConstant adds the given value to the end of the chunk's constant table
+// and returns its index.
+func (c *Chunk) AddConstant(value interface{}) int {
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
@@ -0,0 +1,780 @@
+package vm
+
+import (
+	"io"
+	"math"
+
+	"github.com/mz1290/golox/internal/pkg/ast"
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+const uint8Count = math.MaxUint8 + 1
+
+// Reporter is implemented by the Lox runtime. The compiler and VM use it to
+// surface static and runtime errors the same way the tree-walk interpreter
+// does, and to find where script output should be written.
+type Reporter interface {
+	Output() io.Writer
+	ErrorMessage(line int, message string)
+	ErrorTokenMessage(t *token.Token, message string)
+	RuntimeError(err error)
+	Debugging(category common.Debug) bool
+}
+
+type FunctionType byte
+
+const (
+	TYPE_FUNCTION FunctionType = iota
+	TYPE_INITIALIZER
+	TYPE_METHOD
+	TYPE_SCRIPT
+)
+
+type local struct {
+	name       string
+	depth      int
+	isCaptured bool
+}
+
+type upvalue struct {
+	index   byte
+	isLocal bool
+}
+
+// funcState tracks the function currently being compiled. Each nested function
+// declaration pushes a new state that points back to the one enclosing it.
+type funcState struct {
+	enclosing  *funcState
+	function   *Function
+	ftype      FunctionType
+	locals     []local
+	upvalues   []upvalue
+	scopeDepth int
+}
+
+type classState struct {
+	enclosing     *classState
+	hasSuperclass bool
+}
+
+// Compiler walks the syntax tree produced by the parser and emits bytecode.
+// The resolver has already run by the time the compiler sees a tree, so the
+// compiler can assume the program is semantically valid and only needs to
+// report the limits imposed by the bytecode format.
+type Compiler struct {
+	runtime      Reporter
+	current      *funcState
+	currentClass *classState
+
+	// line is the source line attached to every byte emitted, and file its
+	// script. They are updated whenever the compiler visits a node that
+	// carries a token.
+	line     int
+	file     string
+	previous *token.Token
+
+	// Only the first error is reported since later ones are usually a
+	// consequence of it.
+	hadError bool
+}
+
+func NewCompiler(runtime Reporter) *Compiler {
+	return &Compiler{
+		runtime: runtime,
+		line:    1,
+	}
+}
+
+// Compile turns a resolved program into the top-level script function.
+func (c *Compiler) Compile(statements []ast.Stmt) *Function {
+	c.beginFunction(TYPE_SCRIPT, "")
+	c.statements(statements)
+	return c.endFunction()
+}
+
+func (c *Compiler) statements(statements []ast.Stmt) {
+	for _, stmt := range statements {
+		c.statement(stmt)
+	}
+}
+
+func (c *Compiler) statement(stmt ast.Stmt) {
+	stmt.Accept(c)
+}
+
+func (c *Compiler) expression(expr ast.Expr) {
+	expr.Accept(c)
+}
+
+func (c *Compiler) setLine(t *token.Token) {
+	c.line = t.Line
+	c.file = t.File
+	c.previous = t
+}
+
+func (c *Compiler) error(message string) {
+	if c.hadError {
+		return
+	}
+	c.hadError = true
+
+	if c.previous == nil {
+		c.runtime.ErrorMessage(c.line, message)
+		return
+	}
+
+	c.runtime.ErrorTokenMessage(c.previous, message)
+}
+
+func (c *Compiler) chunk() *Chunk {
+	return c.current.function.Chunk
+}
+
+func (c *Compiler) beginFunction(ftype FunctionType, name string) {
+	state := &funcState{
+		enclosing: c.current,
+		function:  NewFunction(),
+		ftype:     ftype,
+	}
+	state.function.Name = name
+
+	// Slot zero is reserved for the VM. Methods store the receiver there so it
+	// can be accessed as "this".
+	slotZero := local{depth: 0}
+	if ftype != TYPE_FUNCTION && ftype != TYPE_SCRIPT {
+		slotZero.name = "this"
+	}
+	state.locals = append(state.locals, slotZero)
+
+	c.current = state
+}
+
+func (c *Compiler) endFunction() *Function {
+	c.emitReturn()
+	function := c.current.function
+
+	if c.runtime.Debugging(common.BYTECODE) {
+		DisassembleChunk(c.runtime.Output(), function.Chunk, function.String())
+	}
+
+	c.current = c.current.enclosing
+	return function
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().setFile(c.file)
+	c.chunk().Write(b, c.line)
+}
+
+func (c *Compiler) emitBytes(b1, b2 byte) {
+	c.emitByte(b1)
+	c.emitByte(b2)
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitShort(op OpCode, operand int) {
+	c.emitOp(op)
+	c.emitBytes(byte(operand>>8), byte(operand))
+}
+
+func (c *Compiler) emitReturn() {
+	if c.current.ftype == TYPE_INITIALIZER {
+		c.emitBytes(byte(OP_GET_LOCAL), 0)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+
+	c.emitOp(OP_RETURN)
+}
+
+func (c *Compiler) makeConstant(value interface{}) int {
+	constant := c.chunk().AddConstant(value)
+	if constant > math.MaxUint16 {
+		c.error("too many constants in one chunk")
+		return 0
+	}
+
+	return constant
+}
+
+func (c *Compiler) emitConstant(value interface{}) {
+	c.emitShort(OP_CONSTANT, c.makeConstant(value))
+}
+
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOp(op)
+	c.emitBytes(0xff, 0xff)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	// -2 to adjust for the bytecode for the jump offset itself
+	jump := len(c.chunk().Code) - offset - 2
+
+	if jump > math.MaxUint16 {
+		c.error("too much code to jump over")
+	}
+
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	c.emitOp(OP_LOOP)
+
+	// +2 to skip over the loop instruction's own operand
+	offset := len(c.chunk().Code) - loopStart + 2
+	if offset > math.MaxUint16 {
+		c.error("loop body too large")
+	}
+
+	c.emitBytes(byte(offset>>8), byte(offset))
+}
+
+func (c *Compiler) beginScope() {
+	c.current.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	c.current.scopeDepth--
+
+	// Discard every local declared in the scope that just ended. Locals that
+	// were captured by a closure are hoisted onto the heap instead.
+	locals := c.current.locals
+	for len(locals) > 0 && locals[len(locals)-1].depth > c.current.scopeDepth {
+		if locals[len(locals)-1].isCaptured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+		locals = locals[:len(locals)-1]
+	}
+	c.current.locals = locals
+}
+
+func (c *Compiler) identifierConstant(name *token.Token) int {
+	return c.makeConstant(name.Lexeme)
+}
+
+func (c *Compiler) addLocal(name string) {
+	if len(c.current.locals) == uint8Count {
+		c.error("too many local variables in function")
+		return
+	}
+
+	// -1 marks the local as declared but not yet initialized
+	c.current.locals = append(c.current.locals, local{name: name, depth: -1})
+}
+
+func (c *Compiler) declareVariable(name *token.Token) {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+
+	c.setLine(name)
+	c.addLocal(name.Lexeme)
+}
+
+// parseVariable declares the variable and, if it is a global, returns the
+// index of the constant holding its name.
+func (c *Compiler) parseVariable(name *token.Token) int {
+	c.declareVariable(name)
+	if c.current.scopeDepth > 0 {
+		return 0
+	}
+
+	return c.identifierConstant(name)
+}
+
+func (c *Compiler) markInitialized() {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+
+	c.current.locals[len(c.current.locals)-1].depth = c.current.scopeDepth
+}
+
+func (c *Compiler) defineVariable(global int) {
+	// Locals already live in the right stack slot
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+
+	c.emitShort(OP_DEFINE_GLOBAL, global)
+}
+
+func resolveLocal(state *funcState, name string) int {
+	for i := len(state.locals) - 1; i >= 0; i-- {
+		if state.locals[i].name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *Compiler) addUpvalue(state *funcState, index byte, isLocal bool) int {
+	for i, uv := range state.upvalues {
+		if uv.index == index && uv.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(state.upvalues) == uint8Count {
+		c.error("too many closure variables in function")
+		return 0
+	}
+
+	state.upvalues = append(state.upvalues, upvalue{index: index, isLocal: isLocal})
+	state.function.UpvalueCount++
+	return len(state.upvalues) - 1
+}
+
+func (c *Compiler) resolveUpvalue(state *funcState, name string) int {
+	if state.enclosing == nil {
+		return -1
+	}
+
+	if local := resolveLocal(state.enclosing, name); local != -1 {
+		state.enclosing.locals[local].isCaptured = true
+		return c.addUpvalue(state, byte(local), true)
+	}
+
+	if upvalue := c.resolveUpvalue(state.enclosing, name); upvalue != -1 {
+		return c.addUpvalue(state, byte(upvalue), false)
+	}
+
+	return -1
+}
+
+func (c *Compiler) namedVariable(name *token.Token, assign bool) {
+	var getOp, setOp OpCode
+	var arg int
+
+	// Resolving may fail with too many closure variables, which is reported
+	// at the variable.
+	c.setLine(name)
+
+	if arg = resolveLocal(c.current, name.Lexeme); arg != -1 {
+		getOp, setOp = OP_GET_LOCAL, OP_SET_LOCAL
+	} else if arg = c.resolveUpvalue(c.current, name.Lexeme); arg != -1 {
+		getOp, setOp = OP_GET_UPVALUE, OP_SET_UPVALUE
+	} else {
+		arg = c.identifierConstant(name)
+		if assign {
+			c.emitShort(OP_SET_GLOBAL, arg)
+		} else {
+			c.emitShort(OP_GET_GLOBAL, arg)
+		}
+		return
+	}
+
+	if assign {
+		c.emitBytes(byte(setOp), byte(arg))
+	} else {
+		c.emitBytes(byte(getOp), byte(arg))
+	}
+}
+
+func (c *Compiler) function(declaration *ast.Function, ftype FunctionType) {
+	// Suspending a generator needs execution state of its own, like spawn
+	if declaration.Generator {
+		c.setLine(declaration.Name)
+		c.error("generators require the tree engine")
+	}
+
+	c.beginFunction(ftype, declaration.Name.Lexeme)
+	c.beginScope()
+
+	for _, param := range declaration.Params {
+		c.current.function.Arity++
+		c.declareVariable(param)
+		c.markInitialized()
+	}
+
+	c.statements(declaration.Body)
+
+	// No endScope() needed since the whole frame is discarded on return
+	upvalues := c.current.upvalues
+	function := c.endFunction()
+
+	c.setLine(declaration.Name)
+	c.emitShort(OP_CLOSURE, c.makeConstant(function))
+	for _, uv := range upvalues {
+		if uv.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(uv.index)
+	}
+}
+
+func (c *Compiler) VisitBlockStmt(stmt *ast.Block) (interface{}, error) {
+	c.beginScope()
+	c.statements(stmt.Statements)
+	c.endScope()
+	return nil, nil
+}
+
+func (c *Compiler) VisitClassStmt(stmt *ast.Class) (interface{}, error) {
+	nameConstant := c.identifierConstant(stmt.Name)
+	c.declareVariable(stmt.Name)
+
+	c.setLine(stmt.Name)
+	c.emitShort(OP_CLASS, nameConstant)
+	c.defineVariable(nameConstant)
+
+	class := &classState{enclosing: c.currentClass}
+	c.currentClass = class
+
+	if stmt.Superclass != nil {
+		// Load the superclass and keep it on the stack as a local named
+		// "super" so every method closure can capture it.
+		c.namedVariable(stmt.Superclass.Name, false)
+
+		c.beginScope()
+		c.addLocal("super")
+		c.defineVariable(0)
+
+		c.namedVariable(stmt.Name, false)
+		c.setLine(stmt.Superclass.Name)
+		c.emitOp(OP_INHERIT)
+		class.hasSuperclass = true
+	}
+
+	// Load the class so the methods can be attached to it
+	c.namedVariable(stmt.Name, false)
+
+	for _, method := range stmt.Methods {
+		constant := c.identifierConstant(method.Name)
+
+		ftype := TYPE_METHOD
+		if method.Name.Lexeme == "init" {
+			ftype = TYPE_INITIALIZER
+		}
+
+		c.function(method, ftype)
+		c.emitShort(OP_METHOD, constant)
+	}
+
+	c.emitOp(OP_POP)
+
+	if class.hasSuperclass {
+		c.endScope()
+	}
+
+	c.currentClass = c.currentClass.enclosing
+	return nil, nil
+}
+
+func (c *Compiler) VisitExpressionStmt(stmt *ast.Expression) (interface{}, error) {
+	c.expression(stmt.Expression)
+	c.emitOp(OP_POP)
+	return nil, nil
+}
+
+// VisitForInStmt compiles a for-in loop to calls of the iterator protocol,
+// with the iterator in a hidden local. The loop variable is declared in a
+// scope of its own for each iteration, so closures capturing it close over
+// the value of their iteration.
+func (c *Compiler) VisitForInStmt(stmt *ast.ForIn) (interface{}, error) {
+	c.beginScope()
+	c.expression(stmt.Iterable)
+	c.setLine(stmt.Keyword)
+	c.emitOp(OP_ITERATOR)
+	c.emitShort(OP_INVOKE, c.makeConstant("iterator"))
+	c.emitByte(0)
+
+	// The space keeps the name from clashing with any identifier
+	c.addLocal(" iterator")
+	c.markInitialized()
+	slot := byte(len(c.current.locals) - 1)
+
+	loopStart := len(c.chunk().Code)
+	c.emitBytes(byte(OP_GET_LOCAL), slot)
+	c.emitShort(OP_INVOKE, c.makeConstant("hasNext"))
+	c.emitByte(0)
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+
+	c.beginScope()
+	c.emitBytes(byte(OP_GET_LOCAL), slot)
+	c.emitShort(OP_INVOKE, c.makeConstant("next"))
+	c.emitByte(0)
+	c.declareVariable(stmt.Name)
+	c.markInitialized()
+	c.statement(stmt.Body)
+	c.endScope()
+
+	c.setLine(stmt.Keyword)
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+	c.endScope()
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitFunctionStmt(stmt *ast.Function) (interface{}, error) {
+	global := c.parseVariable(stmt.Name)
+
+	// Mark the function initialized before compiling the body so it can refer
+	// to itself recursively.
+	c.markInitialized()
+	c.function(stmt, TYPE_FUNCTION)
+	c.defineVariable(global)
+	return nil, nil
+}
+
+func (c *Compiler) VisitIfStmt(stmt *ast.If) (interface{}, error) {
+	c.expression(stmt.Condition)
+
+	thenJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.statement(stmt.ThenBranch)
+
+	elseJump := c.emitJump(OP_JUMP)
+	c.patchJump(thenJump)
+	c.emitOp(OP_POP)
+
+	if stmt.ElseBranch != nil {
+		c.statement(stmt.ElseBranch)
+	}
+	c.patchJump(elseJump)
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitPrintStmt(stmt *ast.Print) (interface{}, error) {
+	c.expression(stmt.Expression)
+	c.emitOp(OP_PRINT)
+	return nil, nil
+}
+
+// VisitSpawnStmt reports spawn as unsupported: goroutines need execution
+// state of their own, which only the tree-walk interpreter has.
+func (c *Compiler) VisitSpawnStmt(stmt *ast.Spawn) (interface{}, error) {
+	c.setLine(stmt.Keyword)
+	c.error("spawn requires the tree engine")
+	return nil, nil
+}
+
+func (c *Compiler) VisitReturnStmt(stmt *ast.Return) (interface{}, error) {
+	c.setLine(stmt.Keyword)
+
+	if stmt.Value == nil {
+		c.emitReturn()
+		return nil, nil
+	}
+
+	c.expression(stmt.Value)
+	c.emitOp(OP_RETURN)
+	return nil, nil
+}
+
+// Tests are only run by "golox test", which uses the tree-walk interpreter,
+// so they compile to nothing.
+func (c *Compiler) VisitTestStmt(stmt *ast.Test) (interface{}, error) {
+	return nil, nil
+}
+
+// Generators are reported when their function is compiled.
+func (c *Compiler) VisitYieldStmt(stmt *ast.Yield) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *Compiler) VisitVarStmt(stmt *ast.Var) (interface{}, error) {
+	c.setLine(stmt.Name)
+	global := c.parseVariable(stmt.Name)
+
+	if stmt.Initializer != nil {
+		c.expression(stmt.Initializer)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+
+	c.defineVariable(global)
+	return nil, nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt *ast.While) (interface{}, error) {
+	loopStart := len(c.chunk().Code)
+	c.expression(stmt.Condition)
+
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.statement(stmt.Body)
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+	return nil, nil
+}
+
+func (c *Compiler) VisitAssignExpr(expr *ast.Assign) (interface{}, error) {
+	c.expression(expr.Value)
+	c.namedVariable(expr.Name, true)
+	return nil, nil
+}
+
+func (c *Compiler) VisitBinaryExpr(expr *ast.Binary) (interface{}, error) {
+	c.expression(expr.Left)
+	c.expression(expr.Right)
+
+	c.setLine(expr.Operator)
+	switch expr.Operator.Type {
+	case token.BANG_EQUAL:
+		c.emitBytes(byte(OP_EQUAL), byte(OP_NOT))
+	case token.EQUAL_EQUAL:
+		c.emitOp(OP_EQUAL)
+	case token.GREATER:
+		c.emitOp(OP_GREATER)
+	case token.GREATER_EQUAL:
+		c.emitOp(OP_GREATER_EQUAL)
+	case token.LESS:
+		c.emitOp(OP_LESS)
+	case token.LESS_EQUAL:
+		c.emitOp(OP_LESS_EQUAL)
+	case token.PLUS:
+		c.emitOp(OP_ADD)
+	case token.MINUS:
+		c.emitOp(OP_SUBTRACT)
+	case token.STAR:
+		c.emitOp(OP_MULTIPLY)
+	case token.SLASH:
+		c.emitOp(OP_DIVIDE)
+	}
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitCallExpr(expr *ast.Call) (interface{}, error) {
+	switch callee := expr.Callee.(type) {
+	case *ast.Get:
+		// Calling a method directly off an instance skips creating a bound
+		// method.
+		c.expression(callee.Object)
+		c.arguments(expr.Arguments)
+		c.setLine(expr.Paren)
+		c.emitShort(OP_INVOKE, c.identifierConstant(callee.Name))
+		c.emitByte(byte(len(expr.Arguments)))
+	case *ast.Super:
+		c.namedVariable(token.New(token.THIS, "this", nil, callee.Keyword.Line), false)
+		c.arguments(expr.Arguments)
+		c.namedVariable(token.New(token.SUPER, "super", nil, callee.Keyword.Line), false)
+		c.setLine(expr.Paren)
+		c.emitShort(OP_SUPER_INVOKE, c.identifierConstant(callee.Method))
+		c.emitByte(byte(len(expr.Arguments)))
+	default:
+		c.expression(expr.Callee)
+		c.arguments(expr.Arguments)
+		c.setLine(expr.Paren)
+		c.emitBytes(byte(OP_CALL), byte(len(expr.Arguments)))
+	}
+
+	return nil, nil
+}
+
+func (c *Compiler) arguments(arguments []ast.Expr) {
+	for _, arg := range arguments {
+		c.expression(arg)
+	}
+}
+
+func (c *Compiler) VisitGetExpr(expr *ast.Get) (interface{}, error) {
+	c.expression(expr.Object)
+	c.setLine(expr.Name)
+	c.emitShort(OP_GET_PROPERTY, c.identifierConstant(expr.Name))
+	return nil, nil
+}
+
+func (c *Compiler) VisitGroupingExpr(expr *ast.Grouping) (interface{}, error) {
+	c.expression(expr.Expression)
+	return nil, nil
+}
+
+func (c *Compiler) VisitLiteralExpr(expr *ast.Literal) (interface{}, error) {
+	switch expr.Value {
+	case nil:
+		c.emitOp(OP_NIL)
+	case true:
+		c.emitOp(OP_TRUE)
+	case false:
+		c.emitOp(OP_FALSE)
+	default:
+		c.emitConstant(expr.Value)
+	}
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitLogicalExpr(expr *ast.Logical) (interface{}, error) {
+	c.expression(expr.Left)
+	c.setLine(expr.Operator)
+
+	if expr.Operator.Type == token.AND {
+		// Short-circuit: if the left side is falsey leave it as the result
+		endJump := c.emitJump(OP_JUMP_IF_FALSE)
+		c.emitOp(OP_POP)
+		c.expression(expr.Right)
+		c.patchJump(endJump)
+		return nil, nil
+	}
+
+	// For "or", a falsey left side skips over the jump to the end
+	elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+	endJump := c.emitJump(OP_JUMP)
+
+	c.patchJump(elseJump)
+	c.emitOp(OP_POP)
+	c.expression(expr.Right)
+	c.patchJump(endJump)
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitSetExpr(expr *ast.Set) (interface{}, error) {
+	c.expression(expr.Object)
+	c.expression(expr.Value)
+	c.setLine(expr.Name)
+	c.emitShort(OP_SET_PROPERTY, c.identifierConstant(expr.Name))
+	return nil, nil
+}
+
+func (c *Compiler) VisitSuperExpr(expr *ast.Super) (interface{}, error) {
+	c.namedVariable(token.New(token.THIS, "this", nil, expr.Keyword.Line), false)
+	c.namedVariable(token.New(token.SUPER, "super", nil, expr.Keyword.Line), false)
+	c.setLine(expr.Method)
+	c.emitShort(OP_GET_SUPER, c.identifierConstant(expr.Method))
+	return nil, nil
+}
+
+func (c *Compiler) VisitThisExpr(expr *ast.This) (interface{}, error) {
+	c.namedVariable(expr.Keyword, false)
+	return nil, nil
+}
+
+func (c *Compiler) VisitUnaryExpr(expr *ast.Unary) (interface{}, error) {
+	c.expression(expr.Right)
+
+	c.setLine(expr.Operator)
+	switch expr.Operator.Type {
+	case token.BANG:
+		c.emitOp(OP_NOT)
+	case token.MINUS:
+		c.emitOp(OP_NEGATE)
+	}
+
+	return nil, nil
+}
+
+func (c *Compiler) VisitVariableExpr(expr *ast.Variable) (interface{}, error) {
+	c.namedVariable(expr.Name, false)
+	return nil, nil
+}
@@ -0,0 +1,122 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+)
+
+// DisassembleChunk prints every instruction in the chunk in a human readable
+// form. Enabled with the bytecode debug category.
+func DisassembleChunk(w io.Writer, chunk *Chunk, name string) {
+	fmt.Fprintf(w, "== %s ==\n", name)
+
+	for offset := 0; offset < len(chunk.Code); {
+		offset = DisassembleInstruction(w, chunk, offset)
+	}
+}
+
+// DisassembleInstruction prints the instruction at offset and returns the
+// offset of the next instruction.
+func DisassembleInstruction(w io.Writer, chunk *Chunk, offset int) int {
+	fmt.Fprintf(w, "%04d ", offset)
+
+	// A single line of source code can compile to a large sequence of
+	// instructions, so print '|' for instructions from the same line as the
+	// preceding one.
+	if offset > 0 && chunk.Lines[offset] == chunk.Lines[offset-1] {
+		fmt.Fprintf(w, "   | ")
+	} else {
+		fmt.Fprintf(w, "%4d ", chunk.Lines[offset])
+	}
+
+	op := OpCode(chunk.Code[offset])
+	switch op {
+	case OP_CONSTANT, OP_GET_GLOBAL, OP_DEFINE_GLOBAL, OP_SET_GLOBAL,
+		OP_GET_PROPERTY, OP_SET_PROPERTY, OP_GET_SUPER, OP_CLASS, OP_METHOD:
+		return constantInstruction(w, op, chunk, offset)
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL:
+		return byteInstruction(w, op, chunk, offset)
+	case OP_JUMP, OP_JUMP_IF_FALSE:
+		return jumpInstruction(w, op, 1, chunk, offset)
+	case OP_LOOP:
+		return jumpInstruction(w, op, -1, chunk, offset)
+	case OP_INVOKE, OP_SUPER_INVOKE:
+		return invokeInstruction(w, op, chunk, offset)
+	case OP_CLOSURE:
+		return closureInstruction(w, op, chunk, offset)
+	default:
+		fmt.Fprintln(w, op)
+		return offset + 1
+	}
+}
+
+func readShort(chunk *Chunk, offset int) int {
+	return int(chunk.Code[offset])<<8 | int(chunk.Code[offset+1])
+}
+
+func constantInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	fmt.Fprintf(w, "%-16s %4d '%s'\n", op, constant,
+		common.Stringfy(chunk.Constants[constant]))
+	return offset + 3
+}
+
+// Local variable names never get stored in the chunk, so the best we can do
+// is show the slot number.
+func byteInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	fmt.Fprintf(w, "%-16s %4d\n", op, chunk.Code[offset+1])
+	return offset + 2
+}
+
+func jumpInstruction(w io.Writer, op OpCode, sign int, chunk *Chunk, offset int) int {
+	jump := readShort(chunk, offset+1)
+	fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3+sign*jump)
+	return offset + 3
+}
+
+func invokeInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	argCount := chunk.Code[offset+3]
+	fmt.Fprintf(w, "%-16s (%d args) %4d '%s'\n", op, argCount, constant,
+		common.Stringfy(chunk.Constants[constant]))
+	return offset + 4
+}
+
+func closureInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	offset += 3
+
+	function := chunk.Constants[constant].(*Function)
+	fmt.Fprintf(w, "%-16s %4d %s\n", op, constant, function)
+
+	for i := 0; i < function.UpvalueCount; i++ {
+		kind := "upvalue"
+		if chunk.Code[offset] == 1 {
+			kind = "local"
+		}
+
+		fmt.Fprintf(w, "%04d    |                     %s %d\n", offset, kind,
+			chunk.Code[offset+1])
+		offset += 2
+	}
+
+	return offset
+}
+
+// traceExecution shows the current contents of the VM stack followed by the
+// instruction about to execute. Enabled with the trace debug category.
+func (vm *VM) traceExecution(frame *CallFrame) {
+	var sb strings.Builder
+
+	sb.WriteString("          ")
+	for i := 0; i < vm.stackTop; i++ {
+		sb.WriteString(fmt.Sprintf("[ %s ]", common.Stringfy(vm.stack[i])))
+	}
+	out := vm.runtime.Output()
+	fmt.Fprintln(out, sb.String())
+
+	DisassembleInstruction(out, frame.closure.Function.Chunk, frame.ip)
+}
@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// Function is the compiled form of a Lox function declaration. It is never
+// called directly at runtime; the VM always wraps it in a Closure first.
+type Function struct {
+	Arity        int
+	UpvalueCount int
+	Chunk        *Chunk
+	Name         string
+}
+
+func NewFunction() *Function {
+	return &Function{Chunk: NewChunk()}
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "<script>"
+	}
+
+	return fmt.Sprintf("<fn %s>", f.Name)
+}
+
+// Upvalue refers to a local variable in an enclosing function. While the
+// variable still lives on the VM stack, Location points at its stack slot.
+// Once the variable goes out of scope the value is moved into Closed and
+// Location is updated to point there instead.
+type Upvalue struct {
+	Location *interface{}
+	Closed   interface{}
+	Slot     int
+	Next     *Upvalue
+}
+
+func NewUpvalue(slot *interface{}, index int) *Upvalue {
+	return &Upvalue{Location: slot, Slot: index}
+}
+
+// Closure wraps a Function with the upvalues it captured when it was created.
+type Closure struct {
+	Function *Function
+	Upvalues []*Upvalue
+}
+
+func NewClosure(function *Function) *Closure {
+	return &Closure{
+		Function: function,
+		Upvalues: make([]*Upvalue, function.UpvalueCount),
+	}
+}
+
+func (c *Closure) String() string {
+	return c.Function.String()
+}
+
+// Class stores behavior. Inherited methods are copied down into Methods when
+// the class is created so a method lookup never has to walk the superclass
+// chain.
+type Class struct {
+	Name    string
+	Methods map[string]*Closure
+}
+
+func NewClass(name string) *Class {
+	return &Class{
+		Name:    name,
+		Methods: make(map[string]*Closure),
+	}
+}
+
+func (c *Class) String() string {
+	return c.Name
+}
+
+// Instance stores state.
+type Instance struct {
+	Klass  *Class
+	Fields map[string]interface{}
+}
+
+func NewInstance(klass *Class) *Instance {
+	return &Instance{
+		Klass:  klass,
+		Fields: make(map[string]interface{}),
+	}
+}
+
+func (i *Instance) String() string {
+	return fmt.Sprintf("%s instance", i.Klass)
+}
+
+// BoundMethod pairs a method closure with the instance it was accessed from so
+// "this" can be restored when the method is eventually called.
+type BoundMethod struct {
+	Receiver interface{}
+	Method   *Closure
+}
+
+func NewBoundMethod(receiver interface{}, method *Closure) *BoundMethod {
+	return &BoundMethod{
+		Receiver: receiver,
+		Method:   method,
+	}
+}
+
+func (b *BoundMethod) String() string {
+	return b.Method.String()
+}
+
+// Variadic is the arity of natives taking any number of arguments.
+const Variadic = -1
+
+// Native is a function implemented in Go and exposed to Lox code.
+type Native struct {
+	Name     string
+	Arity    int
+	Function func(args []interface{}) (interface{}, error)
+}
+
+func (n *Native) String() string {
+	return "<native fn>"
+}
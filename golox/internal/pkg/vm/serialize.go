@@ -0,0 +1,376 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Compiled programs are stored in a portable file so deployments can skip
+// scanning, parsing and resolving. The layout is:
+//
+//	header:  magic "LOXC" | version uint16 | checksum uint32 | length uint32
+//	payload: the top-level script function
+//
+// All fixed-width integers are big-endian. Inside the payload, integers are
+// unsigned varints and every function is written as:
+//
+//	name | arity | upvalue count | code | line table | constant pool
+//
+// The line table is run-length encoded as (line, count) pairs. Functions
+// nested in the constant pool are written recursively.
+//
+// Code compiled for one instruction set means something else to another, so
+// FormatVersion changes whenever opcodes are added, removed or renumbered.
+const (
+	FormatVersion = 2
+	headerSize    = 14
+)
+
+var magic = []byte("LOXC")
+
+// Constant pool tags
+const (
+	constNil byte = iota
+	constFalse
+	constTrue
+	constNumber
+	constString
+	constFunction
+)
+
+// IsCompiled reports whether data starts with the bytecode file magic.
+func IsCompiled(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encode writes the compiled script to w.
+func Encode(w io.Writer, function *Function) error {
+	var e encoder
+	if err := e.function(function); err != nil {
+		return err
+	}
+
+	payload := e.buf.Bytes()
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[4:], FormatVersion)
+	binary.BigEndian.PutUint32(header[6:], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(header[10:], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+// Decode reads a compiled script previously written by Encode and verifies its
+// code, so that a corrupt or hand-made file is rejected rather than crashing
+// the VM.
+func Decode(data []byte) (*Function, error) {
+	if len(data) < headerSize || !IsCompiled(data) {
+		return nil, fmt.Errorf("not a compiled lox file")
+	}
+
+	version := binary.BigEndian.Uint16(data[4:])
+	if version != FormatVersion {
+		return nil, fmt.Errorf("bytecode version %d is not supported "+
+			"(expected %d)", version, FormatVersion)
+	}
+
+	checksum := binary.BigEndian.Uint32(data[6:])
+	length := binary.BigEndian.Uint32(data[10:])
+	payload := data[headerSize:]
+
+	if uint32(len(payload)) != length {
+		return nil, fmt.Errorf("truncated bytecode file")
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, fmt.Errorf("bytecode checksum mismatch")
+	}
+
+	d := decoder{data: payload}
+	function, err := d.function()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.offset != len(d.data) {
+		return nil, fmt.Errorf("unexpected data after script")
+	}
+
+	if err := verify(function); err != nil {
+		return nil, err
+	}
+
+	return function, nil
+}
+
+type encoder struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) function(f *Function) error {
+	e.string(f.Name)
+	e.uvarint(uint64(f.Arity))
+	e.uvarint(uint64(f.UpvalueCount))
+	e.bytes(f.Chunk.Code)
+	e.lines(f.Chunk.Lines)
+
+	e.uvarint(uint64(len(f.Chunk.Constants)))
+	for _, constant := range f.Chunk.Constants {
+		if err := e.constant(constant); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *encoder) lines(lines []int) {
+	// Count the runs first so the decoder knows how many pairs follow
+	runs := 0
+	for i := range lines {
+		if i == 0 || lines[i] != lines[i-1] {
+			runs++
+		}
+	}
+	e.uvarint(uint64(runs))
+
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && lines[end] == lines[start] {
+			end++
+		}
+
+		e.uvarint(uint64(lines[start]))
+		e.uvarint(uint64(end - start))
+		start = end
+	}
+}
+
+func (e *encoder) constant(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.buf.WriteByte(constNil)
+	case bool:
+		if v {
+			e.buf.WriteByte(constTrue)
+		} else {
+			e.buf.WriteByte(constFalse)
+		}
+	case float64:
+		e.buf.WriteByte(constNumber)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+		e.buf.Write(b[:])
+	case string:
+		e.buf.WriteByte(constString)
+		e.string(v)
+	case *Function:
+		e.buf.WriteByte(constFunction)
+		return e.function(v)
+	default:
+		return fmt.Errorf("cannot serialize constant of type %T", value)
+	}
+
+	return nil
+}
+
+type decoder struct {
+	data   []byte
+	offset int
+	depth  int // of the function being decoded
+}
+
+var errCorrupt = fmt.Errorf("corrupt bytecode file")
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data[d.offset:])
+	if n <= 0 {
+		return 0, errCorrupt
+	}
+
+	d.offset += n
+	return x, nil
+}
+
+// int reads a varint that is used as a count or size and must fit comfortably
+// in the remaining input.
+func (d *decoder) int() (int, error) {
+	x, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+
+	if x > math.MaxInt32 {
+		return 0, errCorrupt
+	}
+
+	return int(x), nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.offset+n > len(d.data) {
+		return nil, errCorrupt
+	}
+
+	b := make([]byte, n)
+	copy(b, d.data[d.offset:])
+	d.offset += n
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.offset >= len(d.data) {
+		return 0, errCorrupt
+	}
+
+	b := d.data[d.offset]
+	d.offset++
+	return b, nil
+}
+
+func (d *decoder) function() (*Function, error) {
+	// Functions nested deeper than the frames the VM allows can never run,
+	// and decoding them would exhaust the Go stack
+	if d.depth == FramesMax {
+		return nil, errCorrupt
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	f := NewFunction()
+
+	name, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	f.Name = string(name)
+
+	if f.Arity, err = d.int(); err != nil {
+		return nil, err
+	}
+
+	if f.UpvalueCount, err = d.int(); err != nil {
+		return nil, err
+	}
+
+	if f.Chunk.Code, err = d.bytes(); err != nil {
+		return nil, err
+	}
+
+	if f.Chunk.Lines, err = d.lines(len(f.Chunk.Code)); err != nil {
+		return nil, err
+	}
+
+	count, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < count; i++ {
+		constant, err := d.constant()
+		if err != nil {
+			return nil, err
+		}
+		f.Chunk.AddConstant(constant)
+	}
+
+	return f, nil
+}
+
+func (d *decoder) lines(codeLen int) ([]int, error) {
+	runs, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]int, 0, codeLen)
+	for i := 0; i < runs; i++ {
+		line, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(lines)+n > codeLen {
+			return nil, errCorrupt
+		}
+
+		for j := 0; j < n; j++ {
+			lines = append(lines, line)
+		}
+	}
+
+	// Every byte of code needs a line so runtime errors can be reported
+	if len(lines) != codeLen {
+		return nil, errCorrupt
+	}
+
+	return lines, nil
+}
+
+func (d *decoder) constant() (interface{}, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case constNil:
+		return nil, nil
+	case constFalse:
+		return false, nil
+	case constTrue:
+		return true, nil
+	case constNumber:
+		if d.offset+8 > len(d.data) {
+			return nil, errCorrupt
+		}
+
+		bits := binary.BigEndian.Uint64(d.data[d.offset:])
+		d.offset += 8
+		return math.Float64frombits(bits), nil
+	case constString:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case constFunction:
+		return d.function()
+	}
+
+	return nil, errCorrupt
+}
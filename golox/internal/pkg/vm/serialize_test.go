@@ -0,0 +1,293 @@
+package vm_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/lox"
+	"github.com/mz1290/golox/internal/pkg/vm"
+)
+
+// The suite shared with clox lives at the root of the repository.
+const suite = "../../../../test"
+
+func encode(t *testing.T, function *vm.Function) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := vm.Encode(&buf, function); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	source := strings.Join([]string{
+		`class A { init(n) { this.n = n; } get() { return this.n; } }`,
+		`class B < A { get() { return super.get() + 0.5; } }`,
+		`fun counter() { var n = 0; fun inc() { n = n + 1; return n; } return inc; }`,
+		`var c = counter();`,
+		`for (var i = 0; i < 3; i = i + 1) c();`,
+		`print c();`,
+		`print B(1).get();`,
+		`print nil == false;`,
+		`print "done";`,
+	}, "\n")
+
+	l := lox.New()
+	function := compile(l, source)
+	if l.HadError {
+		t.Fatal("program doesn't compile")
+	}
+
+	decoded, err := vm.Decode(encode(t, function))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	l.SetOutput(&out)
+	vm.New(l).Interpret(decoded)
+	l.Flush()
+
+	if want := "4\n1.5\nfalse\ndone\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
+
+// Every script of the suite that compiles must survive a round trip, which
+// checks that verification accepts whatever the compiler produces.
+func TestRoundTripSuite(t *testing.T) {
+	err := filepath.Walk(suite, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".lox" {
+			return err
+		}
+
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		l := lox.New()
+		l.SetOutput(&strings.Builder{})
+		l.SetErrorOutput(&strings.Builder{})
+		function := compile(l, string(source))
+		if l.HadError {
+			return nil
+		}
+
+		if _, err := vm.Decode(encode(t, function)); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	l := lox.New()
+	data := encode(t, compile(l, `print "a";`))
+
+	tests := []struct {
+		name string
+		data func() []byte
+		err  string
+	}{
+		{
+			name: "not compiled",
+			data: func() []byte { return []byte(`print "a";`) },
+			err:  "not a compiled lox file",
+		},
+		{
+			name: "version",
+			data: func() []byte {
+				d := append([]byte{}, data...)
+				d[5]++
+				return d
+			},
+			err: "bytecode version 3 is not supported (expected 2)",
+		},
+		{
+			name: "truncated",
+			data: func() []byte { return data[:len(data)-1] },
+			err:  "truncated bytecode file",
+		},
+		{
+			name: "checksum",
+			data: func() []byte {
+				d := append([]byte{}, data...)
+				d[len(d)-1] ^= 0xff
+				return d
+			},
+			err: "bytecode checksum mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := vm.Decode(test.data()); err == nil || err.Error() != test.err {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// Functions nested deeper than the VM's frames are rejected before decoding
+// them exhausts the stack.
+func TestDecodeNesting(t *testing.T) {
+	nest := func(depth int) *vm.Function {
+		var f *vm.Function
+		for i := 0; i < depth; i++ {
+			outer := vm.NewFunction()
+			outer.Chunk.Write(byte(vm.OP_NIL), 1)
+			outer.Chunk.Write(byte(vm.OP_RETURN), 1)
+			if f != nil {
+				outer.Chunk.AddConstant(f)
+			}
+			f = outer
+		}
+		return f
+	}
+
+	if _, err := vm.Decode(encode(t, nest(vm.FramesMax))); err != nil {
+		t.Errorf("got error %v decoding %d nested functions", err, vm.FramesMax)
+	}
+
+	_, err := vm.Decode(encode(t, nest(vm.FramesMax+1)))
+	if want := "corrupt bytecode file"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+// The instruction set below is the one FormatVersion 2 files are compiled
+// for. Changing it without bumping FormatVersion would let older files run
+// with their opcodes meaning something else.
+func TestFormatVersion(t *testing.T) {
+	const version = 2
+	instructions := strings.Join([]string{
+		"OP_CONSTANT", "OP_NIL", "OP_TRUE", "OP_FALSE", "OP_POP",
+		"OP_GET_LOCAL", "OP_SET_LOCAL", "OP_GET_GLOBAL", "OP_DEFINE_GLOBAL",
+		"OP_SET_GLOBAL", "OP_GET_UPVALUE", "OP_SET_UPVALUE", "OP_GET_PROPERTY",
+		"OP_SET_PROPERTY", "OP_GET_SUPER", "OP_EQUAL", "OP_GREATER",
+		"OP_GREATER_EQUAL", "OP_LESS", "OP_LESS_EQUAL", "OP_ADD", "OP_SUBTRACT",
+		"OP_MULTIPLY", "OP_DIVIDE", "OP_NOT", "OP_NEGATE", "OP_PRINT", "OP_JUMP",
+		"OP_JUMP_IF_FALSE", "OP_LOOP", "OP_CALL", "OP_INVOKE",
+		"OP_SUPER_INVOKE", "OP_CLOSURE", "OP_CLOSE_UPVALUE", "OP_RETURN",
+		"OP_CLASS", "OP_INHERIT", "OP_METHOD", "OP_ITERATOR",
+	}, " ")
+
+	var names []string
+	for op := vm.OpCode(0); op.String() != "UNKNOWN"; op++ {
+		names = append(names, op.String())
+	}
+
+	if got := strings.Join(names, " "); got != instructions || vm.FormatVersion != version {
+		t.Errorf("instruction set or FormatVersion %d changed without the other; "+
+			"bump FormatVersion when opcodes change, then update this test\n"+
+			"got  %s\nwant %s", vm.FormatVersion, got, instructions)
+	}
+}
+
+// Files with a valid checksum can still hold code the VM must not run.
+func TestDecodeCorruptCode(t *testing.T) {
+	function := func(code []byte, constants ...interface{}) *vm.Function {
+		f := vm.NewFunction()
+		for _, b := range code {
+			f.Chunk.Write(b, 1)
+		}
+		f.Chunk.Constants = constants
+		return f
+	}
+
+	nested := function([]byte{byte(vm.OP_NIL), byte(vm.OP_RETURN)})
+	nested.Name = "f"
+	nested.UpvalueCount = 1
+
+	tests := []struct {
+		name     string
+		function *vm.Function
+		err      string
+	}{
+		{
+			name: "constant out of range",
+			function: function([]byte{byte(vm.OP_CONSTANT), 0xff, 0xff,
+				byte(vm.OP_RETURN)}, 1.0),
+			err: "constant 65535 out of range",
+		},
+		{
+			name:     "unknown opcode",
+			function: function([]byte{0xee, byte(vm.OP_NIL), byte(vm.OP_RETURN)}),
+			err:      "unknown opcode 238",
+		},
+		{
+			name: "global named by a number",
+			function: function([]byte{byte(vm.OP_GET_GLOBAL), 0, 0,
+				byte(vm.OP_RETURN)}, 1.0),
+			err: "OP_GET_GLOBAL names float64",
+		},
+		{
+			name: "closure over a string",
+			function: function([]byte{byte(vm.OP_CLOSURE), 0, 0,
+				byte(vm.OP_RETURN)}, "f"),
+			err: "closure over string",
+		},
+		{
+			name: "upvalue out of range",
+			function: function([]byte{byte(vm.OP_CLOSURE), 0, 0, 0, 0,
+				byte(vm.OP_RETURN)}, nested),
+			err: "upvalue 0 out of range",
+		},
+		{
+			name:     "operand past the end",
+			function: function([]byte{byte(vm.OP_NIL), byte(vm.OP_CONSTANT), 0}),
+			err:      "OP_CONSTANT runs past the end of the code",
+		},
+		{
+			name: "jump out of range",
+			function: function([]byte{byte(vm.OP_JUMP), 0, 9, byte(vm.OP_NIL),
+				byte(vm.OP_RETURN)}),
+			err: "jump to 12 out of range",
+		},
+		{
+			name: "jump into an instruction",
+			function: function([]byte{byte(vm.OP_LOOP), 0, 2, byte(vm.OP_NIL),
+				byte(vm.OP_RETURN)}),
+			err: "jump into an instruction",
+		},
+		{
+			name:     "no return",
+			function: function([]byte{byte(vm.OP_NIL), byte(vm.OP_PRINT)}),
+			err:      "code doesn't end with a return",
+		},
+		{
+			name:     "underflow",
+			function: function([]byte{byte(vm.OP_POP), byte(vm.OP_RETURN)}),
+			err:      "OP_POP underflows the stack",
+		},
+		{
+			name: "local out of range",
+			function: function([]byte{byte(vm.OP_GET_LOCAL), 5,
+				byte(vm.OP_RETURN)}),
+			err: "local 5 out of range",
+		},
+		{
+			name: "unbalanced branches",
+			function: function([]byte{byte(vm.OP_TRUE), byte(vm.OP_JUMP_IF_FALSE),
+				0, 1, byte(vm.OP_NIL), byte(vm.OP_RETURN)}),
+			err: "stack height",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := vm.Decode(encode(t, test.function))
+		if err == nil || !strings.Contains(err.Error(), test.err) ||
+			!strings.HasPrefix(err.Error(), "corrupt bytecode") {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
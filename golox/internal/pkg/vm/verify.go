@@ -0,0 +1,359 @@
+package vm
+
+import "fmt"
+
+// Compiled files come from outside the process, so Decode verifies their code
+// before the VM trusts it. Verification checks what the VM takes for granted
+// in code it compiled itself: every opcode is known, operands lie within the
+// code, constant operands refer to constants of the right type, jumps land on
+// instructions, and the stack never drops below the slots of its frame.
+
+// operands is the number of operand bytes following each opcode, other than
+// OP_CLOSURE whose operands depend on the function it closes over.
+var operands = map[OpCode]int{
+	OP_CONSTANT:      2,
+	OP_NIL:           0,
+	OP_TRUE:          0,
+	OP_FALSE:         0,
+	OP_POP:           0,
+	OP_GET_LOCAL:     1,
+	OP_SET_LOCAL:     1,
+	OP_GET_GLOBAL:    2,
+	OP_DEFINE_GLOBAL: 2,
+	OP_SET_GLOBAL:    2,
+	OP_GET_UPVALUE:   1,
+	OP_SET_UPVALUE:   1,
+	OP_GET_PROPERTY:  2,
+	OP_SET_PROPERTY:  2,
+	OP_GET_SUPER:     2,
+	OP_EQUAL:         0,
+	OP_GREATER:       0,
+	OP_GREATER_EQUAL: 0,
+	OP_LESS:          0,
+	OP_LESS_EQUAL:    0,
+	OP_ADD:           0,
+	OP_SUBTRACT:      0,
+	OP_MULTIPLY:      0,
+	OP_DIVIDE:        0,
+	OP_NOT:           0,
+	OP_NEGATE:        0,
+	OP_PRINT:         0,
+	OP_JUMP:          2,
+	OP_JUMP_IF_FALSE: 2,
+	OP_LOOP:          2,
+	OP_CALL:          1,
+	OP_INVOKE:        3,
+	OP_SUPER_INVOKE:  3,
+	OP_CLOSURE:       2,
+	OP_CLOSE_UPVALUE: 0,
+	OP_RETURN:        0,
+	OP_CLASS:         2,
+	OP_INHERIT:       0,
+	OP_METHOD:        2,
+	OP_ITERATOR:      0,
+}
+
+// verifier checks the code of a single function.
+type verifier struct {
+	function *Function
+	code     []byte
+
+	// heights maps the offset of each instruction reached so far to the
+	// number of stack slots its frame uses before it runs
+	heights map[int]int
+}
+
+func corrupt(function *Function, offset int, format string, args ...interface{}) error {
+	return fmt.Errorf("corrupt bytecode in %s at offset %d: %s", function,
+		offset, fmt.Sprintf(format, args...))
+}
+
+// verify checks the code of the top-level script and every function nested in
+// its constants.
+func verify(script *Function) error {
+	if script.Arity != 0 {
+		return corrupt(script, 0, "script takes arguments")
+	} else if script.UpvalueCount != 0 {
+		return corrupt(script, 0, "script closes over variables")
+	}
+
+	return verifyFunction(script)
+}
+
+func verifyFunction(function *Function) error {
+	v := &verifier{
+		function: function,
+		code:     function.Chunk.Code,
+		heights:  make(map[int]int),
+	}
+
+	starts, err := v.instructions()
+	if err != nil {
+		return err
+	}
+
+	if err := v.stack(starts); err != nil {
+		return err
+	}
+
+	for _, constant := range function.Chunk.Constants {
+		if nested, ok := constant.(*Function); ok {
+			if err := verifyFunction(nested); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// instructions checks every instruction on its own and returns the offsets at
+// which instructions start.
+func (v *verifier) instructions() (map[int]bool, error) {
+	starts := make(map[int]bool)
+
+	offset := 0
+	for offset < len(v.code) {
+		starts[offset] = true
+		op := OpCode(v.code[offset])
+
+		size, ok := operands[op]
+		if !ok {
+			return nil, corrupt(v.function, offset, "unknown opcode %d", op)
+		}
+
+		if op == OP_CLOSURE {
+			function, err := v.closure(offset)
+			if err != nil {
+				return nil, err
+			}
+			size += 2 * function.UpvalueCount
+		}
+
+		if offset+size >= len(v.code) {
+			return nil, corrupt(v.function, offset, "%s runs past the end of "+
+				"the code", op)
+		}
+
+		if err := v.operands(op, offset); err != nil {
+			return nil, err
+		}
+
+		offset += 1 + size
+	}
+
+	// The VM stops at a return, so code can't run past its end
+	if offset == 0 || OpCode(v.code[v.last(starts)]) != OP_RETURN {
+		return nil, corrupt(v.function, offset, "code doesn't end with a return")
+	}
+
+	return starts, nil
+}
+
+// last returns the offset of the last instruction.
+func (v *verifier) last(starts map[int]bool) int {
+	offset := len(v.code) - 1
+	for !starts[offset] {
+		offset--
+	}
+
+	return offset
+}
+
+func (v *verifier) short(offset int) int {
+	return int(v.code[offset])<<8 | int(v.code[offset+1])
+}
+
+// constant returns the constant the two operand bytes at offset refer to.
+func (v *verifier) constant(offset int) (interface{}, error) {
+	if offset+1 >= len(v.code) {
+		return nil, corrupt(v.function, offset, "missing constant operand")
+	}
+
+	index := v.short(offset)
+	if index >= len(v.function.Chunk.Constants) {
+		return nil, corrupt(v.function, offset, "constant %d out of range",
+			index)
+	}
+
+	return v.function.Chunk.Constants[index], nil
+}
+
+func (v *verifier) closure(offset int) (*Function, error) {
+	constant, err := v.constant(offset + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	function, ok := constant.(*Function)
+	if !ok {
+		return nil, corrupt(v.function, offset, "closure over %T", constant)
+	}
+
+	return function, nil
+}
+
+// operands checks the operands of the instruction at offset that don't depend
+// on the stack.
+func (v *verifier) operands(op OpCode, offset int) error {
+	switch op {
+	case OP_CONSTANT:
+		_, err := v.constant(offset + 1)
+		return err
+	case OP_GET_GLOBAL, OP_DEFINE_GLOBAL, OP_SET_GLOBAL, OP_GET_PROPERTY,
+		OP_SET_PROPERTY, OP_GET_SUPER, OP_CLASS, OP_METHOD, OP_INVOKE,
+		OP_SUPER_INVOKE:
+		constant, err := v.constant(offset + 1)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := constant.(string); !ok {
+			return corrupt(v.function, offset, "%s names %T", op, constant)
+		}
+	case OP_GET_UPVALUE, OP_SET_UPVALUE:
+		if index := int(v.code[offset+1]); index >= v.function.UpvalueCount {
+			return corrupt(v.function, offset, "upvalue %d out of range", index)
+		}
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_LOOP:
+		if target := v.target(op, offset); target < 0 || target >= len(v.code) {
+			return corrupt(v.function, offset, "jump to %d out of range",
+				target)
+		}
+	case OP_CLOSURE:
+		function, _ := v.closure(offset)
+		for i := 0; i < function.UpvalueCount; i++ {
+			isLocal := v.code[offset+3+2*i]
+			index := int(v.code[offset+4+2*i])
+
+			if isLocal > 1 {
+				return corrupt(v.function, offset, "invalid upvalue kind %d",
+					isLocal)
+			} else if isLocal == 0 && index >= v.function.UpvalueCount {
+				return corrupt(v.function, offset, "upvalue %d out of range",
+					index)
+			}
+		}
+	}
+
+	return nil
+}
+
+// target returns the offset a jump instruction at offset jumps to.
+func (v *verifier) target(op OpCode, offset int) int {
+	jump := v.short(offset + 1)
+	if op == OP_LOOP {
+		return offset + 3 - jump
+	}
+
+	return offset + 3 + jump
+}
+
+// stack follows every path through the code to check that each instruction
+// finds the stack slots it uses, and that paths meeting at an instruction
+// agree on the height of the stack there.
+func (v *verifier) stack(starts map[int]bool) error {
+	// Slot zero holds the function or receiver, followed by the arguments
+	v.heights[0] = v.function.Arity + 1
+	work := []int{0}
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		op := OpCode(v.code[offset])
+		height := v.heights[offset]
+
+		pops, pushes, err := v.effect(op, offset, height)
+		if err != nil {
+			return err
+		} else if height < pops+1 {
+			// Slot zero is never popped
+			return corrupt(v.function, offset, "%s underflows the stack", op)
+		}
+		height += pushes - pops
+
+		var next []int
+		switch op {
+		case OP_RETURN:
+		case OP_JUMP, OP_LOOP:
+			next = []int{v.target(op, offset)}
+		case OP_JUMP_IF_FALSE:
+			next = []int{offset + 3, v.target(op, offset)}
+		case OP_CLOSURE:
+			function, _ := v.closure(offset)
+			next = []int{offset + 3 + 2*function.UpvalueCount}
+		default:
+			next = []int{offset + 1 + operands[op]}
+		}
+
+		for _, n := range next {
+			if !starts[n] {
+				return corrupt(v.function, offset, "jump into an instruction")
+			}
+
+			if h, ok := v.heights[n]; !ok {
+				v.heights[n] = height
+				work = append(work, n)
+			} else if h != height {
+				return corrupt(v.function, n, "stack height %d or %d", h,
+					height)
+			}
+		}
+	}
+
+	return nil
+}
+
+// effect returns how many values the instruction at offset pops and pushes,
+// given the height of the stack before it runs.
+func (v *verifier) effect(op OpCode, offset, height int) (int, int, error) {
+	switch op {
+	case OP_CONSTANT, OP_NIL, OP_TRUE, OP_FALSE, OP_GET_GLOBAL,
+		OP_GET_UPVALUE, OP_CLASS:
+		return 0, 1, nil
+	case OP_GET_LOCAL, OP_SET_LOCAL:
+		if slot := int(v.code[offset+1]); slot >= height {
+			return 0, 0, corrupt(v.function, offset, "local %d out of range",
+				slot)
+		}
+
+		if op == OP_GET_LOCAL {
+			return 0, 1, nil
+		}
+		return 1, 1, nil
+	case OP_CLOSURE:
+		function, _ := v.closure(offset)
+		for i := 0; i < function.UpvalueCount; i++ {
+			isLocal := v.code[offset+3+2*i]
+			slot := int(v.code[offset+4+2*i])
+
+			// A function declared in a block may capture itself, which is
+			// on the stack once the closure is pushed
+			if isLocal == 1 && slot > height {
+				return 0, 0, corrupt(v.function, offset, "local %d out of "+
+					"range", slot)
+			}
+		}
+		return 0, 1, nil
+	case OP_SET_GLOBAL, OP_SET_UPVALUE, OP_GET_PROPERTY, OP_NOT, OP_NEGATE,
+		OP_JUMP_IF_FALSE, OP_ITERATOR:
+		return 1, 1, nil
+	case OP_POP, OP_DEFINE_GLOBAL, OP_PRINT, OP_CLOSE_UPVALUE, OP_RETURN:
+		return 1, 0, nil
+	case OP_SET_PROPERTY, OP_GET_SUPER, OP_EQUAL, OP_GREATER,
+		OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL, OP_ADD, OP_SUBTRACT,
+		OP_MULTIPLY, OP_DIVIDE, OP_INHERIT, OP_METHOD:
+		return 2, 1, nil
+	case OP_CALL:
+		// The callee and its arguments are replaced by the result
+		return int(v.code[offset+1]) + 1, 1, nil
+	case OP_INVOKE:
+		return int(v.code[offset+3]) + 1, 1, nil
+	case OP_SUPER_INVOKE:
+		// The superclass sits above the arguments
+		return int(v.code[offset+3]) + 2, 1, nil
+	}
+
+	return 0, 0, nil
+}
@@ -0,0 +1,600 @@
+package vm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/errors"
+	"github.com/mz1290/golox/internal/pkg/token"
+)
+
+// Maximum call depth supported
+const (
+	FramesMax = 1024
+	StackMax  = FramesMax * uint8Count
+)
+
+// A call frame represents a single ongoing function call
+type CallFrame struct {
+	// Closure, containing the function, being called
+	closure *Closure
+
+	// Offset into the closure's chunk of the next instruction to execute
+	ip int
+
+	// Index of the first VM stack slot this function can use
+	slots int
+}
+
+// Bytecode VM architecture for state:
+// - Local variables and temporaries are on the stack
+// - Globals are in a hash table
+// - Variables in closures use upvalues
+type VM struct {
+	runtime Reporter
+
+	frames     [FramesMax]CallFrame
+	frameCount int
+
+	// The stack is allocated once and never grows so that open upvalues can
+	// safely point into it.
+	stack    []interface{}
+	stackTop int
+
+	globals      map[string]interface{}
+	openUpvalues *Upvalue
+
+	// interruption holds the error the running code fails with at its next
+	// backward jump, if any
+	interruption atomic.Value
+}
+
+// interruption is stored in VM.interruption, which can't hold nil.
+type interruption struct {
+	err error
+}
+
+func New(runtime Reporter) *VM {
+	vm := &VM{
+		runtime: runtime,
+		stack:   make([]interface{}, StackMax),
+		globals: make(map[string]interface{}),
+	}
+
+	vm.DefineNative("clock", 0, func(args []interface{}) (interface{}, error) {
+		return float64(time.Now().Unix()), nil
+	})
+	vm.DefineNative("range", Variadic, func(args []interface{}) (interface{}, error) {
+		return common.NewRange(args)
+	})
+
+	return vm
+}
+
+// DefineNative defines a global function implemented in Go. An error it
+// returns becomes a runtime error at the call.
+func (vm *VM) DefineNative(name string, arity int, function func([]interface{}) (interface{}, error)) {
+	vm.globals[name] = &Native{Name: name, Arity: arity, Function: function}
+}
+
+// Interpret executes a compiled top-level script. Globals persist between
+// calls so the REPL can build on earlier input.
+func (vm *VM) Interpret(function *Function) {
+	closure := NewClosure(function)
+	vm.push(closure)
+
+	err := vm.call(closure, 0)
+	if err == nil {
+		err = vm.run()
+	}
+
+	if err != nil {
+		vm.runtime.RuntimeError(err)
+		vm.resetStack()
+	}
+}
+
+// Interrupt makes the code running on vm fail with err at its next backward
+// jump. It may be called from any goroutine. A nil err withdraws the
+// interruption.
+func (vm *VM) Interrupt(err error) {
+	vm.interruption.Store(interruption{err})
+}
+
+// Globals returns the global variables defined so far, by name.
+func (vm *VM) Globals() map[string]interface{} {
+	return vm.globals
+}
+
+func (vm *VM) resetStack() {
+	for i := 0; i < vm.stackTop; i++ {
+		vm.stack[i] = nil
+	}
+	vm.stackTop = 0
+	vm.frameCount = 0
+	vm.openUpvalues = nil
+}
+
+func (vm *VM) push(value interface{}) {
+	vm.stack[vm.stackTop] = value
+	vm.stackTop++
+}
+
+func (vm *VM) pop() interface{} {
+	vm.stackTop--
+	return vm.stack[vm.stackTop]
+}
+
+func (vm *VM) peek(distance int) interface{} {
+	return vm.stack[vm.stackTop-1-distance]
+}
+
+func (vm *VM) runtimeError(format string, args ...interface{}) error {
+	frame := &vm.frames[vm.frameCount-1]
+	chunk := frame.closure.Function.Chunk
+
+	t := token.New(token.EOF, "", nil, chunk.Lines[frame.ip-1])
+	t.File = chunk.file(frame.ip - 1)
+	return errors.RuntimeError.New(t, fmt.Sprintf(format, args...))
+}
+
+func (vm *VM) call(closure *Closure, argCount int) error {
+	if argCount != closure.Function.Arity {
+		return vm.runtimeError("expected %d arguments but got %d",
+			closure.Function.Arity, argCount)
+	}
+
+	if vm.frameCount == FramesMax || vm.stackTop+uint8Count > StackMax {
+		return vm.runtimeError("stack overflow")
+	}
+
+	frame := &vm.frames[vm.frameCount]
+	vm.frameCount++
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots = vm.stackTop - argCount - 1
+	return nil
+}
+
+func (vm *VM) callValue(callee interface{}, argCount int) error {
+	switch callee := callee.(type) {
+	case *BoundMethod:
+		// Slot zero of the new frame holds the receiver so "this" resolves
+		vm.stack[vm.stackTop-argCount-1] = callee.Receiver
+		return vm.call(callee.Method, argCount)
+	case *Class:
+		vm.stack[vm.stackTop-argCount-1] = NewInstance(callee)
+
+		if initializer, ok := callee.Methods["init"]; ok {
+			return vm.call(initializer, argCount)
+		} else if argCount != 0 {
+			return vm.runtimeError("expected 0 arguments but got %d", argCount)
+		}
+
+		return nil
+	case *Closure:
+		return vm.call(callee, argCount)
+	case *Native:
+		if callee.Arity != Variadic && argCount != callee.Arity {
+			return vm.runtimeError("expected %d arguments but got %d",
+				callee.Arity, argCount)
+		}
+
+		result, err := callee.Function(vm.stack[vm.stackTop-argCount : vm.stackTop])
+		if err != nil {
+			return vm.runtimeError("%s", err)
+		}
+		vm.stackTop -= argCount + 1
+		vm.push(result)
+		return nil
+	}
+
+	return vm.runtimeError("can only call functions and classes")
+}
+
+func (vm *VM) invokeFromClass(klass *Class, name string, argCount int) error {
+	method, ok := klass.Methods[name]
+	if !ok {
+		return vm.runtimeError("undefined property %q", name)
+	}
+
+	return vm.call(method, argCount)
+}
+
+func (vm *VM) invoke(name string, argCount int) error {
+	// The receiver sits on the stack just below the arguments
+	if object, ok := vm.peek(argCount).(common.Object); ok {
+		method, err := vm.objectMethod(object, name)
+		if err != nil {
+			return err
+		}
+
+		vm.stack[vm.stackTop-argCount-1] = method
+		return vm.callValue(method, argCount)
+	}
+
+	instance, ok := vm.peek(argCount).(*Instance)
+	if !ok {
+		return vm.runtimeError("only instances have properties")
+	}
+
+	// A field shadows a method of the same name
+	if value, ok := instance.Fields[name]; ok {
+		vm.stack[vm.stackTop-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+
+	return vm.invokeFromClass(instance.Klass, name, argCount)
+}
+
+// objectMethod looks up a method of a value implemented in Go.
+func (vm *VM) objectMethod(object common.Object, name string) (*Native, error) {
+	method, ok := object.Method(name)
+	if !ok {
+		return nil, vm.runtimeError("undefined property %q", name)
+	}
+
+	return &Native{Name: name, Arity: method.Arity, Function: method.Call}, nil
+}
+
+func (vm *VM) bindMethod(klass *Class, name string) error {
+	method, ok := klass.Methods[name]
+	if !ok {
+		return vm.runtimeError("undefined property %q", name)
+	}
+
+	// Replace the instance on top of the stack with the bound method
+	bound := NewBoundMethod(vm.peek(0), method)
+	vm.pop()
+	vm.push(bound)
+	return nil
+}
+
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	// Open upvalues are kept sorted by stack slot, highest first
+	var prevUpvalue *Upvalue
+	upvalue := vm.openUpvalues
+
+	for upvalue != nil && upvalue.Slot > slot {
+		prevUpvalue = upvalue
+		upvalue = upvalue.Next
+	}
+
+	if upvalue != nil && upvalue.Slot == slot {
+		return upvalue
+	}
+
+	createdUpvalue := NewUpvalue(&vm.stack[slot], slot)
+	createdUpvalue.Next = upvalue
+
+	if prevUpvalue == nil {
+		vm.openUpvalues = createdUpvalue
+	} else {
+		prevUpvalue.Next = createdUpvalue
+	}
+
+	return createdUpvalue
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.Slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.Closed = *upvalue.Location
+		upvalue.Location = &upvalue.Closed
+		vm.openUpvalues = upvalue.Next
+	}
+}
+
+func (vm *VM) defineMethod(name string) error {
+	method, ok := vm.peek(0).(*Closure)
+	klass, isClass := vm.peek(1).(*Class)
+	if !ok || !isClass {
+		return vm.runtimeError("corrupt bytecode: can only add methods to classes")
+	}
+
+	klass.Methods[name] = method
+	vm.pop()
+	return nil
+}
+
+// run is the VM's fetch/decode/execute loop.
+func (vm *VM) run() error {
+	frame := &vm.frames[vm.frameCount-1]
+	code := frame.closure.Function.Chunk.Code
+	constants := frame.closure.Function.Chunk.Constants
+
+	readByte := func() byte {
+		b := code[frame.ip]
+		frame.ip++
+		return b
+	}
+
+	readShort := func() int {
+		frame.ip += 2
+		return int(code[frame.ip-2])<<8 | int(code[frame.ip-1])
+	}
+
+	readString := func() string {
+		return constants[readShort()].(string)
+	}
+
+	// Reload the cached chunk after the active frame changes
+	loadFrame := func() {
+		frame = &vm.frames[vm.frameCount-1]
+		code = frame.closure.Function.Chunk.Code
+		constants = frame.closure.Function.Chunk.Constants
+	}
+
+	trace := vm.runtime.Debugging(common.TRACE)
+
+	for {
+		if trace {
+			vm.traceExecution(frame)
+		}
+
+		instruction := OpCode(readByte())
+		switch instruction {
+		case OP_CONSTANT:
+			vm.push(constants[readShort()])
+		case OP_NIL:
+			vm.push(nil)
+		case OP_TRUE:
+			vm.push(true)
+		case OP_FALSE:
+			vm.push(false)
+		case OP_POP:
+			vm.pop()
+		case OP_GET_LOCAL:
+			vm.push(vm.stack[frame.slots+int(readByte())])
+		case OP_SET_LOCAL:
+			// Assignment is an expression, so leave the value on the stack
+			vm.stack[frame.slots+int(readByte())] = vm.peek(0)
+		case OP_GET_GLOBAL:
+			name := readString()
+			value, ok := vm.globals[name]
+			if !ok {
+				return vm.runtimeError("undefined variable %q", name)
+			}
+			vm.push(value)
+		case OP_DEFINE_GLOBAL:
+			vm.globals[readString()] = vm.pop()
+		case OP_SET_GLOBAL:
+			name := readString()
+			if _, ok := vm.globals[name]; !ok {
+				return vm.runtimeError("undefined variable %q", name)
+			}
+			vm.globals[name] = vm.peek(0)
+		case OP_GET_UPVALUE:
+			vm.push(*frame.closure.Upvalues[readByte()].Location)
+		case OP_SET_UPVALUE:
+			*frame.closure.Upvalues[readByte()].Location = vm.peek(0)
+		case OP_GET_PROPERTY:
+			if object, ok := vm.peek(0).(common.Object); ok {
+				method, err := vm.objectMethod(object, readString())
+				if err != nil {
+					return err
+				}
+				vm.pop()
+				vm.push(method)
+				break
+			}
+
+			instance, ok := vm.peek(0).(*Instance)
+			if !ok {
+				return vm.runtimeError("only instances have properties")
+			}
+
+			name := readString()
+			if value, ok := instance.Fields[name]; ok {
+				vm.pop()
+				vm.push(value)
+				break
+			}
+
+			if err := vm.bindMethod(instance.Klass, name); err != nil {
+				return err
+			}
+		case OP_SET_PROPERTY:
+			instance, ok := vm.peek(1).(*Instance)
+			if !ok {
+				return vm.runtimeError("only instances have fields")
+			}
+
+			instance.Fields[readString()] = vm.peek(0)
+			value := vm.pop()
+			vm.pop()
+			vm.push(value)
+		case OP_GET_SUPER:
+			name := readString()
+			superclass, ok := vm.pop().(*Class)
+			if !ok {
+				return vm.runtimeError("superclass must be a class")
+			}
+
+			if err := vm.bindMethod(superclass, name); err != nil {
+				return err
+			}
+		case OP_EQUAL:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(common.IsEqual(a, b))
+		case OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL,
+			OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE:
+			b, bok := vm.peek(0).(float64)
+			a, aok := vm.peek(1).(float64)
+			if !aok || !bok {
+				return vm.runtimeError("operands must be numbers")
+			}
+
+			vm.stackTop -= 2
+			switch instruction {
+			case OP_GREATER:
+				vm.push(a > b)
+			case OP_GREATER_EQUAL:
+				vm.push(a >= b)
+			case OP_LESS:
+				vm.push(a < b)
+			case OP_LESS_EQUAL:
+				vm.push(a <= b)
+			case OP_SUBTRACT:
+				vm.push(a - b)
+			case OP_MULTIPLY:
+				vm.push(a * b)
+			case OP_DIVIDE:
+				vm.push(a / b)
+			}
+		case OP_ADD:
+			switch a := vm.peek(1).(type) {
+			case float64:
+				if b, ok := vm.peek(0).(float64); ok {
+					vm.stackTop -= 2
+					vm.push(a + b)
+					continue
+				}
+			case string:
+				if b, ok := vm.peek(0).(string); ok {
+					vm.stackTop -= 2
+					vm.push(a + b)
+					continue
+				}
+			}
+
+			return vm.runtimeError("operands must be two numbers or two strings")
+		case OP_ITERATOR:
+			// Lists, strings and ranges are iterated in Go. Other iterables
+			// are left for their iterator method to be invoked.
+			if it, ok := common.Iterate(vm.peek(0)); ok {
+				vm.pop()
+				vm.push(it)
+			} else if !iterable(vm.peek(0)) {
+				return vm.runtimeError("can only iterate over lists, strings, " +
+					"ranges and iterables")
+			}
+		case OP_NOT:
+			vm.push(!common.IsTruthy(vm.pop()))
+		case OP_NEGATE:
+			value, ok := vm.peek(0).(float64)
+			if !ok {
+				return vm.runtimeError("operand must be a number")
+			}
+			vm.stack[vm.stackTop-1] = -value
+		case OP_PRINT:
+			fmt.Fprintln(vm.runtime.Output(), common.Stringfy(vm.pop()))
+		case OP_JUMP:
+			offset := readShort()
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := readShort()
+			if !common.IsTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := readShort()
+			frame.ip -= offset
+
+			if e, _ := vm.interruption.Load().(interruption); e.err != nil {
+				return e.err
+			}
+		case OP_CALL:
+			argCount := int(readByte())
+			if err := vm.callValue(vm.peek(argCount), argCount); err != nil {
+				return err
+			}
+			loadFrame()
+		case OP_INVOKE:
+			method := readString()
+			argCount := int(readByte())
+			if err := vm.invoke(method, argCount); err != nil {
+				return err
+			}
+			loadFrame()
+		case OP_SUPER_INVOKE:
+			method := readString()
+			argCount := int(readByte())
+			superclass, ok := vm.pop().(*Class)
+			if !ok {
+				return vm.runtimeError("superclass must be a class")
+			}
+			if err := vm.invokeFromClass(superclass, method, argCount); err != nil {
+				return err
+			}
+			loadFrame()
+		case OP_CLOSURE:
+			function := constants[readShort()].(*Function)
+			closure := NewClosure(function)
+			vm.push(closure)
+
+			for i := range closure.Upvalues {
+				isLocal := readByte()
+				index := int(readByte())
+
+				if isLocal == 1 {
+					closure.Upvalues[i] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.Upvalues[i] = frame.closure.Upvalues[index]
+				}
+			}
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.stackTop - 1)
+			vm.pop()
+		case OP_RETURN:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+
+			if vm.frameCount == 0 {
+				// Pop the top-level script closure
+				vm.pop()
+				return nil
+			}
+
+			vm.stackTop = frame.slots
+			vm.push(result)
+			loadFrame()
+		case OP_CLASS:
+			vm.push(NewClass(readString()))
+		case OP_INHERIT:
+			superclass, ok := vm.peek(1).(*Class)
+			if !ok {
+				return vm.runtimeError("superclass must be a class")
+			}
+
+			// Copy-down inheritance: the subclass starts with every method of
+			// its superclass and later method definitions override them.
+			subclass, ok := vm.peek(0).(*Class)
+			if !ok {
+				return vm.runtimeError("corrupt bytecode: can only inherit " +
+					"into a class")
+			}
+			for name, method := range superclass.Methods {
+				subclass.Methods[name] = method
+			}
+			vm.pop()
+		case OP_METHOD:
+			if err := vm.defineMethod(readString()); err != nil {
+				return err
+			}
+		default:
+			// Decode rejects unknown opcodes, so this is only a safeguard
+			return vm.runtimeError("corrupt bytecode: unknown opcode %d",
+				instruction)
+		}
+	}
+}
+
+// iterable reports whether value has an iterator method.
+func iterable(value interface{}) bool {
+	switch v := value.(type) {
+	case *Instance:
+		_, isField := v.Fields["iterator"]
+		_, isMethod := v.Klass.Methods["iterator"]
+		return isField || isMethod
+	case common.Object:
+		_, ok := v.Method("iterator")
+		return ok
+	}
+
+	return false
+}
@@ -0,0 +1,197 @@
+package vm_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mz1290/golox/internal/pkg/lox"
+	"github.com/mz1290/golox/internal/pkg/vm"
+)
+
+// compile scans, parses, resolves and compiles source, reporting errors to l.
+// The compiler needs no variable bindings, but the resolver rejects programs
+// it would compile to nonsense, such as reading a local in its initializer.
+func compile(l *lox.Lox, source string) *vm.Function {
+	tokens := lox.NewScanner(l, source).ScanTokens()
+	statements := lox.NewParser(l, tokens).Parse()
+	if !l.HadError {
+		lox.NewResolver(l, l.Interpreter).Resolve(statements)
+	}
+	if l.HadError {
+		return nil
+	}
+
+	return vm.NewCompiler(l).Compile(statements)
+}
+
+// run compiles and interprets source on a fresh VM, returning its output and
+// error reports.
+func run(source string) (string, string) {
+	l := lox.New()
+
+	var out, errOut strings.Builder
+	l.SetOutput(&out)
+	l.SetErrorOutput(&errOut)
+
+	if function := compile(l, source); !l.HadError {
+		vm.New(l).Interpret(function)
+	}
+
+	l.Flush()
+	return out.String(), errOut.String()
+}
+
+func TestVM(t *testing.T) {
+	tests := []struct {
+		name   string
+		source []string
+		out    string
+		err    string
+	}{
+		{
+			name: "expressions",
+			source: []string{
+				`print 1 + 2 * 3 - -4 / 2;`,
+				`print "a" + "b";`,
+				`print !nil == true;`,
+				`print 2 >= 2 and 1 < 1 or "x";`,
+			},
+			out: "9\nab\ntrue\nx\n",
+		},
+		{
+			name: "variables",
+			source: []string{
+				`var a = "global";`,
+				`{ var a = "outer"; { var a = "inner"; print a; } print a; }`,
+				`a = a + "!";`,
+				`print a;`,
+			},
+			out: "inner\nouter\nglobal!\n",
+		},
+		{
+			name: "control flow",
+			source: []string{
+				`var s = "";`,
+				`for (var i = 0; i < 5; i = i + 1) if (i == 2) s = s + "-"; else s = s + "x";`,
+				`while (s != "") { print s; s = ""; }`,
+			},
+			out: "xx-xx\n",
+		},
+		{
+			name: "closures",
+			source: []string{
+				`fun counter() { var n = 0; fun inc() { n = n + 1; return n; } return inc; }`,
+				`var c = counter();`,
+				`c(); c();`,
+				`print c();`,
+				`var fs;`,
+				`{ var x = "closed"; fun f() { return x; } fs = f; }`,
+				`print fs();`,
+			},
+			out: "3\nclosed\n",
+		},
+		{
+			name: "classes",
+			source: []string{
+				`class A { init(n) { this.n = n; } get() { return this.n; } }`,
+				`class B < A { get() { return super.get() * 2; } }`,
+				`var b = B(21);`,
+				`print b.get();`,
+				`var get = b.get;`,
+				`b.n = 1;`,
+				`print get();`,
+				`print b;`,
+				`print B;`,
+			},
+			out: "42\n2\nB instance\nB\n",
+		},
+		{
+			name: "fields shadow methods",
+			source: []string{
+				`class A { f() { return "method"; } }`,
+				`var a = A();`,
+				`a.f = a.f;`,
+				`fun g() { return "field"; }`,
+				`print a.f();`,
+				`a.f = g;`,
+				`print a.f();`,
+			},
+			out: "method\nfield\n",
+		},
+		{
+			name: "undefined variable",
+			source: []string{
+				`print 1;`,
+				`print x;`,
+				`print 2;`,
+			},
+			out: "1\n",
+			err: "[line 2] RuntimeError: undefined variable \"x\"\n",
+		},
+		{
+			name: "operands",
+			source: []string{
+				`var a = 1;`,
+				`a + "b";`,
+			},
+			err: "[line 2] RuntimeError: operands must be two numbers or two strings\n",
+		},
+		{
+			name: "arity",
+			source: []string{
+				`fun f(a, b) {}`,
+				`f(1);`,
+			},
+			err: "[line 2] RuntimeError: expected 2 arguments but got 1\n",
+		},
+		{
+			name: "stack overflow",
+			source: []string{
+				`fun f() { f(); }`,
+				`f();`,
+			},
+			err: "[line 1] RuntimeError: stack overflow\n",
+		},
+	}
+
+	for _, test := range tests {
+		out, errOut := run(strings.Join(test.source, "\n"))
+
+		if out != test.out {
+			t.Errorf("%s: got output %q, want %q", test.name, out, test.out)
+		}
+
+		if errOut != test.err {
+			t.Errorf("%s: got errors %q, want %q", test.name, errOut, test.err)
+		}
+	}
+}
+
+// A function can close over at most 256 variables. The error is reported at
+// the variable over the limit rather than the one before it.
+func TestTooManyClosureVariables(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("fun outer() {\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "var a%d;\n", i)
+	}
+	sb.WriteString("fun middle() {\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "var b%d;\n", i)
+	}
+	sb.WriteString("fun inner() {\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "a%d; b%d;\n", i, i)
+	}
+	sb.WriteString("}\n}\n}\n")
+
+	_, errOut := run(sb.String())
+
+	// inner starts on line 403, so a128, the 257th variable it closes over,
+	// is on line 532
+	want := `[line 532] error at "a128": too many closure variables in function`
+	if !strings.HasPrefix(errOut, want) {
+		t.Errorf("got errors %q, want %q", errOut, want)
+	}
+}
@@ -1,28 +1,653 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mz1290/golox/internal/pkg/common"
+	"github.com/mz1290/golox/internal/pkg/golden"
 	"github.com/mz1290/golox/internal/pkg/lox"
 )
 
+// version is the release reported by "golox version".
+var version = "1.0.0"
+
+// options are the flags of the commands that run Lox code. They may also be
+// given before the command, as in "golox --engine=vm test-suite test/", and
+// then become the defaults of the command's own flags.
+type options struct {
+	engine     string
+	noOptimize bool
+	debug      string
+	coverage   string
+	profile    string
+	trace      string
+}
+
+// engineFlags registers the flags selecting how code is run.
+func (o *options) engineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.engine, "engine", o.engine, "execution engine: tree or vm")
+	o.optimizeFlags(fs)
+}
+
+// optimizeFlags registers the flags for the optimizer and debug output.
+func (o *options) optimizeFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.noOptimize, "O0", o.noOptimize, "disable the AST optimizer")
+	fs.StringVar(&o.debug, "debug", o.debug, "comma separated debug output: "+
+		strings.Join(common.DebugCategories, ", "))
+}
+
+// reportFlags registers the flags for the reports written after a run.
+func (o *options) reportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.coverage, "coverage", o.coverage, "write lcov line and "+
+		"branch coverage to this file")
+	fs.StringVar(&o.profile, "profile", o.profile, "write a pprof profile of "+
+		"the script's Lox functions to this file")
+	fs.StringVar(&o.trace, "trace", o.trace, "write a Chrome trace of the "+
+		"script's calls to this file")
+}
+
+// parseEngine returns the selected engine, exiting if it is unknown.
+func (o *options) parseEngine() lox.Engine {
+	engine, err := lox.ParseEngine(o.engine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(64)
+	}
+
+	return engine
+}
+
+// newLox returns a Lox running code as the options say.
+func (o *options) newLox() *lox.Lox {
+	l := lox.New()
+	l.Debug = common.ParseDebug(o.debug)
+	l.Engine = o.parseEngine()
+	l.Optimize = !o.noOptimize
+	return l
+}
+
+// A command is a subcommand of golox, such as "run".
+type command struct {
+	name    string
+	args    string // the arguments following the flags, for usage
+	summary string
+	run     func(o *options, args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[flags] script... [--] [arguments]", "run scripts or a compiled .loxc file", run},
+		{"repl", "[flags]", "start an interactive session", repl},
+		{"tokens", "script", "print the tokens of a script", tokens},
+		{"ast", "script", "print the syntax tree of a script", syntaxTree},
+		{"check", "[flags] script...", "report static errors without running", check},
+		{"fmt", "[-w] [-l] [script...]", "format scripts, or standard input", format},
+		{"test", "[flags] [file or dir...]", "run the tests in *_test.lox files", test},
+		{"bench", "[flags] [-n runs] script [arguments]", "time repeated runs of a script", bench},
+		{"build", "script [-o script.loxc]", "compile a script to bytecode", build},
+		{"test-suite", "[flags] dir", "check scripts against their // expect: comments", testSuite},
+		{"serve", "--listen=unix:path|localhost:port [flags] [script]", "serve REPL sessions", serve},
+		{"version", "", "print the version", printVersion},
+		{"help", "", "print this message", func(*options, []string) { usage() }},
+	}
+}
+
+func usage() {
+	fmt.Println("Usage: golox command [flags] [arguments]")
+	fmt.Println("       golox [flags] script... [arguments]   (same as run)")
+	fmt.Println("       golox                                 (same as repl)")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Println()
+	fmt.Println(`Run "golox command -h" for the flags of a command.`)
+}
+
 func main() {
-	nArgs := len(os.Args)
+	o := &options{
+		engine: os.Getenv("LOXENGINE"),
+		debug:  os.Getenv("DEBUGLOX"),
+	}
+
+	fs := flag.NewFlagSet("golox", flag.ContinueOnError)
+	fs.Usage = usage
+	o.engineFlags(fs)
+	o.reportFlags(fs)
+	parseFlags(fs, os.Args[1:])
+
+	args := fs.Args()
+	if len(args) == 0 {
+		repl(o, nil)
+		return
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(o, args[1:])
+			return
+		}
+	}
+
+	// Scripts on their own are run, any arguments after them passed on
+	runScript(o, args)
+}
+
+// newFlagSet returns the flag set of the command called name.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: golox %s %s\n", c.name, c.args)
+			}
+		}
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseFlags parses args, exiting with a usage error if they are invalid.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(64)
+	}
+}
+
+// usageError prints the usage of a command and exits.
+func usageError(fs *flag.FlagSet) {
+	fs.Usage()
+	os.Exit(64) // exit code standard https://www.freebsd.org/cgi/man.cgi?query=sysexits&apropos=0&sektion=0&manpath=FreeBSD+4.3-RELEASE&format=html
+}
+
+// readSource returns the contents of a script, exiting if it can't be read.
+func readSource(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(66)
+	}
+
+	return string(data)
+}
+
+// run runs a program made of one or more scripts, passing it the arguments
+// that follow them.
+func run(o *options, args []string) {
+	fs := newFlagSet("run")
+	o.engineFlags(fs)
+	o.reportFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		usageError(fs)
+	}
+
+	runScript(o, fs.Args())
+}
+
+// runScript runs the program made of the scripts args starts with, passing
+// it the arguments that follow.
+func runScript(o *options, args []string) {
+	scripts, args := splitScripts(args)
+
+	l := o.newLox()
+	l.Args = args
+
+	if o.coverage != "" {
+		if l.Engine != lox.ENGINE_TREE {
+			fmt.Fprintln(os.Stderr, "coverage requires the tree engine")
+			os.Exit(64)
+		}
+		l.Coverage = lox.NewCoverage(o.coverage)
+	}
+
+	if o.profile != "" {
+		if l.Engine != lox.ENGINE_TREE {
+			fmt.Fprintln(os.Stderr, "profiling requires the tree engine")
+			os.Exit(64)
+		}
+		l.Profile = lox.NewProfile(o.profile)
+	}
+
+	if o.trace != "" {
+		if l.Engine != lox.ENGINE_TREE {
+			fmt.Fprintln(os.Stderr, "tracing requires the tree engine")
+			os.Exit(64)
+		}
+
+		var err error
+		if l.Trace, err = lox.NewTrace(o.trace); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(73)
+		}
+	}
+
+	l.RunFiles(scripts...)
+}
+
+// splitScripts splits args into the scripts of a program, the first argument
+// and any .lox files following it, and the arguments passed to the program.
+// "--" ends the scripts early.
+func splitScripts(args []string) (scripts, rest []string) {
+	n := 1
+	for n < len(args) && strings.HasSuffix(args[n], ".lox") {
+		n++
+	}
+
+	scripts, rest = args[:n], args[n:]
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+
+	return scripts, rest
+}
+
+// repl starts an interactive session.
+func repl(o *options, args []string) {
+	fs := newFlagSet("repl")
+	o.engineFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() != 0 {
+		usageError(fs)
+	}
+
+	o.newLox().RunPrompt()
+}
+
+// tokens prints the tokens a script is scanned into.
+func tokens(o *options, args []string) {
+	fs := newFlagSet("tokens")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		usageError(fs)
+	}
+
+	l := lox.New()
+	for _, t := range lox.NewScanner(l, readSource(fs.Arg(0))).ScanTokens() {
+		fmt.Println(t)
+	}
+
+	if l.HadError {
+		os.Exit(65)
+	}
+}
+
+// syntaxTree prints the syntax tree a script is parsed into, before it is
+// resolved or optimized.
+func syntaxTree(o *options, args []string) {
+	fs := newFlagSet("ast")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		usageError(fs)
+	}
+
+	l := lox.New()
+	tokens := lox.NewScanner(l, readSource(fs.Arg(0))).ScanTokens()
+	statements := lox.NewParser(l, tokens).Parse()
+	if l.HadError {
+		os.Exit(65)
+	}
+
+	fmt.Print(lox.AstPrinter{}.Print(statements))
+}
+
+// check reports the static errors in scripts without running them.
+func check(o *options, args []string) {
+	fs := newFlagSet("check")
+	o.engineFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		usageError(fs)
+	}
 
-	common.SetDebug(os.Getenv("DEBUGLOX"))
+	failed := false
+	for _, path := range fs.Args() {
+		l := o.newLox()
+		l.Check(readSource(path))
+		if l.HadError {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(65)
+	}
+}
+
+// format prints scripts laid out in the standard style, or rewrites them.
+func format(o *options, args []string) {
+	fs := newFlagSet("fmt")
+	write := fs.Bool("w", false, "write the result to the script instead of "+
+		"printing it")
+	list := fs.Bool("l", false, "list the scripts whose formatting differs")
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		if *write || *list {
+			usageError(fs)
+		}
+
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(66)
+		}
+
+		formatted, err := lox.Format(string(source))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(65)
+		}
+		fmt.Print(formatted)
+		return
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		source := readSource(path)
+		formatted, err := lox.Format(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			failed = true
+			continue
+		}
+
+		if *list && formatted != source {
+			fmt.Println(path)
+		}
+
+		if *write {
+			if formatted != source {
+				if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(73)
+				}
+			}
+		} else if !*list {
+			fmt.Print(formatted)
+		}
+	}
+
+	if failed {
+		os.Exit(65)
+	}
+}
+
+// bench runs a script a number of times, discarding its output, and reports
+// how long the runs took.
+func bench(o *options, args []string) {
+	fs := newFlagSet("bench")
+	o.engineFlags(fs)
+	runs := fs.Int("n", 10, "number of runs")
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 || *runs < 1 {
+		usageError(fs)
+	}
+
+	source := readSource(fs.Arg(0))
+
+	times := make([]time.Duration, *runs)
+	for n := range times {
+		l := o.newLox()
+		l.Args = fs.Args()[1:]
+		l.SetOutput(io.Discard)
+
+		start := time.Now()
+		l.Run(source)
+		times[n] = time.Since(start)
+
+		if l.HadError {
+			os.Exit(65)
+		} else if l.HadRuntimeError {
+			os.Exit(70)
+		}
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	var total time.Duration
+	for _, t := range times {
+		total += t
+	}
+	mean := total / time.Duration(len(times))
+
+	var variance float64
+	for _, t := range times {
+		d := float64(t - mean)
+		variance += d * d
+	}
+	stddev := time.Duration(math.Sqrt(variance / float64(len(times))))
+
+	fmt.Printf("%s: %d runs, mean %s, median %s, min %s, max %s, stddev %s\n",
+		fs.Arg(0), len(times), mean, times[len(times)/2], times[0],
+		times[len(times)-1], stddev)
+}
+
+func printVersion(o *options, args []string) {
+	fmt.Printf("golox %s\n", version)
+}
+
+// build compiles a script to a bytecode file that can be passed to "run".
+func build(o *options, args []string) {
+	fs := newFlagSet("build")
+	o.optimizeFlags(fs)
+	output := fs.String("o", "", "output file (default: script name with "+
+		".loxc extension)")
+
+	// Allow the output flag to follow the script name
+	var script string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		script, args = args[0], args[1:]
+	}
+	parseFlags(fs, args)
+
+	if script == "" && fs.NArg() == 1 {
+		script = fs.Arg(0)
+	} else if script == "" || fs.NArg() != 0 {
+		usageError(fs)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(script, filepath.Ext(script)) + ".loxc"
+	}
 
-	if nArgs > 2 {
-		fmt.Println("Usage: golox [script]")
-		os.Exit(64) // exit code standard https://www.freebsd.org/cgi/man.cgi?query=sysexits&apropos=0&sektion=0&manpath=FreeBSD+4.3-RELEASE&format=html
+	o.newLox().BuildFile(script, *output)
+}
+
+// serve gives every connection on the listen address a REPL session,
+// attached to the script if one is given, until interrupted.
+func serve(o *options, args []string) {
+	fs := newFlagSet("serve")
+	o.engineFlags(fs)
+	listen := fs.String("listen", "", "address to listen on: unix:path or "+
+		"localhost:port")
+	isolated := fs.Bool("isolated", false, "give each session its own "+
+		"interpreter instead of sharing one")
+	jsonMode := fs.Bool("json", false, "start sessions in JSON mode")
+	timeout := fs.Duration("timeout", 10*time.Second, "longest an entry may "+
+		"run, 0 for no limit")
+	parseFlags(fs, args)
+
+	if *listen == "" || fs.NArg() > 1 {
+		usageError(fs)
+	}
+
+	var server *lox.Server
+	if *isolated {
+		server = lox.NewIsolatedServer(o.newLox)
 	} else {
-		l := lox.New()
+		server = lox.NewSharedServer(o.newLox())
+	}
+	server.JSON = *jsonMode
+	server.Timeout = *timeout
+
+	listener, err := lox.Listen(*listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(69)
+	}
+
+	if fs.NArg() == 1 && !server.Start(readSource(fs.Arg(0))) {
+		listener.Close()
+		os.Exit(65)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		listener.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *listen)
+	if err := server.Serve(listener); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(74)
+	}
+}
+
+// testSuite runs every script under a directory against the expectations in
+// its comments.
+func testSuite(o *options, args []string) {
+	fs := newFlagSet("test-suite")
+	fs.StringVar(&o.engine, "engine", o.engine, "execution engine: tree or vm")
+	timeout := fs.Duration("timeout", golden.DefaultTimeout, "longest a "+
+		"script may run, 0 for no limit")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		usageError(fs)
+	}
+
+	results, err := golden.RunSuite(fs.Arg(0), o.parseEngine(), *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(66)
+	}
+
+	if golden.Report(os.Stdout, results) > 0 {
+		os.Exit(1)
+	}
+}
+
+// test runs the test declarations in the given *_test.lox files, and in those
+// found under the given directories, reporting each test as it finishes.
+func test(o *options, args []string) {
+	fs := newFlagSet("test")
+	o.optimizeFlags(fs)
+	fs.StringVar(&o.coverage, "coverage", o.coverage, "write lcov line and "+
+		"branch coverage to this file")
+	parseFlags(fs, args)
+
+	args = fs.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	var coverage *lox.Coverage
+	if o.coverage != "" {
+		coverage = lox.NewCoverage(o.coverage)
+	}
+
+	var paths []string
+	for _, arg := range args {
+		found, err := discoverTests(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(66)
+		}
+		paths = append(paths, found...)
+	}
+
+	passed, failed := 0, 0
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(66)
+		}
+
+		l := o.newLox()
+		l.Engine = lox.ENGINE_TREE
+		l.Coverage = coverage
+
+		fmt.Fprintf(l.Output(), "=== %s\n", path)
+		l.RunTests(path, string(source), func(r lox.TestResult) {
+			if r.Passed() {
+				fmt.Fprintf(l.Output(), "PASS  %s (%s)\n", r.Name, r.Elapsed)
+				passed++
+			} else {
+				fmt.Fprintf(l.Output(), "FAIL  %s (%s)\n      %s\n", r.Name,
+					r.Elapsed, lox.RuntimeErrorMessage(r.Err))
+				failed++
+			}
+		})
+
+		if l.HadError {
+			fmt.Fprintf(l.Output(), "FAIL  %s does not compile\n", path)
+			failed++
+		}
+		l.Flush()
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
 
-		if nArgs == 2 {
-			l.RunFile(os.Args[1])
-		} else {
-			l.RunPrompt()
+	if coverage != nil {
+		if err := coverage.Write(os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(74)
 		}
 	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// discoverTests returns path if it is a file, or else every *_test.lox file
+// under it.
+func discoverTests(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var paths []string
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && strings.HasSuffix(path, "_test.lox") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths, err
 }
@@ -40,12 +40,16 @@ func main() {
 		"Block      : Statements []Stmt",
 		"Class      : Name *token.Token, Superclass *Variable, Methods []*Function",
 		"Expression : Expression Expr",
-		"Function   : Name *token.Token, Params []*token.Token, Body []Stmt",
+		"ForIn      : Keyword *token.Token, Name *token.Token, Iterable Expr, Body Stmt",
+		"Function   : Name *token.Token, Params []*token.Token, Body []Stmt, Generator bool",
 		"If         : Condition Expr, ThenBranch Stmt, ElseBranch Stmt",
-		"Print      : Expression Expr",
+		"Print      : Keyword *token.Token, Expression Expr",
 		"Return     : Keyword *token.Token, Value Expr",
+		"Spawn      : Keyword *token.Token, Call *Call",
+		"Test       : Keyword *token.Token, Name *token.Token, Body []Stmt",
 		"Var        : Name *token.Token, Initializer Expr",
 		"While      : Condition Expr, Body Stmt",
+		"Yield      : Keyword *token.Token, Value Expr",
 	})
 }
 
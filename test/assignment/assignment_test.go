@@ -1,9 +1,8 @@
 package assignment
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,136 +15,69 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestAssociativity(t *testing.T) {
 	file := "associativity.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "c\nc\nc\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestGlobal(t *testing.T) {
 	file := "global.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "before\nafter\narg\narg\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestGrouping(t *testing.T) {
 	file := "grouping.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 2] error at "=": invalid assignment target`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestInfixOperator(t *testing.T) {
 	file := "infix_operator.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] error at "=": invalid assignment target`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestLocal(t *testing.T) {
 	file := "local.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "before\nafter\narg\narg\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestPrefixOperator(t *testing.T) {
 	file := "prefix_operator.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 2] error at "=": invalid assignment target`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSyntax(t *testing.T) {
 	file := "syntax.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "var\nvar\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestToThis(t *testing.T) {
 	file := "to_this.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] error at "=": invalid assignment target`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestUndefined(t *testing.T) {
 	file := "undefined.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: undefined variable "unknown"`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
@@ -0,0 +1,420 @@
+// Bench runs the programs in benchmark/ several times on each interpreter and
+// reports the mean, median and standard deviation of every benchmark. Results
+// can be saved as a JSON baseline and later runs compared against it, failing
+// when a benchmark got slower by more than the regression threshold.
+//
+// Most benchmarks are measured by wall-clock time. Batch benchmarks, such as
+// zoo_batch, run for a fixed time and print how many batches they completed,
+// so their score is that count and higher is better.
+//
+// A benchmark with a Go or C file of the same name is also built and run as a
+// native baseline, reported as the go and c interpreters.
+//
+// A benchmark that fails on an interpreter, such as one clox can't compile,
+// is marked as failed in the report and left out of the comparison with the
+// baseline. The other benchmarks still run.
+//
+// From the test directory:
+//
+//	go run ./bench -n 10 -save baseline.json
+//	go run ./bench -n 10 -baseline baseline.json -threshold 5
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	dir          = flag.String("dir", "../benchmark", "directory holding the benchmarks")
+	runs         = flag.Int("n", 5, "number of runs per benchmark and interpreter")
+	interpreters = flag.String("interpreters", "golox,golox-vm,clox,go,c",
+		"comma separated interpreters to run")
+	golox     = flag.String("golox", "../golox/golox", "path to the golox binary")
+	clox      = flag.String("clox", "../clox/build/clox", "path to the clox binary")
+	filter    = flag.String("bench", "", "only run benchmarks matching this regexp")
+	save      = flag.String("save", "", "write the results to a JSON baseline")
+	baseline  = flag.String("baseline", "", "compare the results with a JSON baseline")
+	threshold = flag.Float64("threshold", 10, "percent a benchmark may get worse "+
+		"than the baseline before it counts as a regression")
+	timeout = flag.Duration("timeout", 5*time.Minute, "time allowed per run")
+)
+
+// Benchmarks that run for a fixed time and print the number of batches they
+// completed on the given line of their output.
+var batchLine = map[string]int{
+	"zoo_batch": 1,
+}
+
+// Metrics
+const (
+	seconds = "seconds"
+	batches = "batches"
+)
+
+// errorLines is the number of lines of a failed run's stderr that are kept.
+const errorLines = 5
+
+// Baseline is the JSON document written by -save.
+type Baseline struct {
+	Date    time.Time `json:"date"`
+	Results []Result  `json:"results"`
+}
+
+// Result holds every run of one benchmark on one interpreter.
+type Result struct {
+	Benchmark   string    `json:"benchmark"`
+	Interpreter string    `json:"interpreter"`
+	Metric      string    `json:"metric"`
+	Runs        []float64 `json:"runs"`
+	Mean        float64   `json:"mean"`
+	Median      float64   `json:"median"`
+	Stddev      float64   `json:"stddev"`
+
+	// Error is why a run failed, in which case there are no statistics
+	Error string `json:"error,omitempty"`
+}
+
+func (r Result) key() string {
+	return r.Benchmark + "/" + r.Interpreter
+}
+
+// change returns how much worse r is than old in percent. Negative values are
+// improvements.
+func (r Result) change(old Result) float64 {
+	if old.Mean == 0 {
+		return 0
+	}
+
+	change := (r.Mean - old.Mean) / old.Mean * 100
+	if r.Metric == batches {
+		change = -change
+	}
+
+	return change
+}
+
+// command starts a single run of a benchmark.
+type command func(ctx context.Context, script string) *exec.Cmd
+
+func main() {
+	flag.Parse()
+
+	var pattern *regexp.Regexp
+	if *filter != "" {
+		var err error
+		if pattern, err = regexp.Compile(*filter); err != nil {
+			fatal(err)
+		}
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(*dir, "*.lox"))
+	if err != nil {
+		fatal(err)
+	}
+	sort.Strings(scripts)
+
+	var old map[string]Result
+	if *baseline != "" {
+		if old, err = load(*baseline); err != nil {
+			fatal(err)
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "loxbench")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var results []Result
+	failed := 0
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".lox")
+		if pattern != nil && !pattern.MatchString(name) {
+			continue
+		}
+
+		for _, interpreter := range strings.Split(*interpreters, ",") {
+			run, err := commandFor(interpreter, script, tmp)
+			if err != nil {
+				fatal(err)
+			}
+
+			// Not every benchmark has a native version
+			if run == nil {
+				continue
+			}
+
+			result, err := measure(name, interpreter, script, run)
+			if err != nil {
+				result.Runs, result.Error = nil, err.Error()
+				failed++
+				fmt.Fprintf(os.Stderr, "%s on %s: failed: %v\n", name,
+					interpreter, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s on %s: %.4g %s\n", name,
+					interpreter, result.Mean, result.Metric)
+			}
+			results = append(results, result)
+		}
+	}
+
+	regressions := report(results, old)
+	if failed > 0 {
+		fmt.Printf("%d of %d results failed\n", failed, len(results))
+	}
+
+	if *save != "" {
+		if err := write(*save, results); err != nil {
+			fatal(err)
+		}
+	}
+
+	if regressions > 0 {
+		fmt.Printf("%d regressions over %.1f%%\n", regressions, *threshold)
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
+
+// commandFor returns how to run the script on the interpreter. It returns nil
+// for a native language the benchmark has no version in.
+func commandFor(interpreter, script, tmp string) (command, error) {
+	lox := func(binary string, env ...string) (command, error) {
+		binary, err := filepath.Abs(binary)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(binary); err != nil {
+			return nil, fmt.Errorf("%s not found, build it first", binary)
+		}
+
+		return func(ctx context.Context, script string) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, binary, script)
+			cmd.Env = append(os.Environ(), env...)
+			return cmd
+		}, nil
+	}
+
+	switch interpreter {
+	case "golox":
+		return lox(*golox, "LOXENGINE=tree")
+	case "golox-vm":
+		return lox(*golox, "LOXENGINE=vm")
+	case "clox":
+		return lox(*clox)
+	case "go", "c":
+		return native(script, interpreter, tmp)
+	}
+
+	return nil, fmt.Errorf("unknown interpreter %q", interpreter)
+}
+
+// native builds the Go or C version of a benchmark. Like the README results,
+// compiler optimizations are disabled so the comparison stays about the
+// interpreters.
+func native(script, language, tmp string) (command, error) {
+	source := strings.TrimSuffix(script, ".lox") + "." + language
+	if _, err := os.Stat(source); err != nil {
+		return nil, nil
+	}
+
+	binary := filepath.Join(tmp, filepath.Base(source)+".bin")
+
+	var build *exec.Cmd
+	if language == "go" {
+		build = exec.Command("go", "build", "-gcflags", "-N -l", "-o", binary,
+			source)
+	} else {
+		build = exec.Command("cc", "-O0", "-o", binary, source)
+	}
+
+	if output, err := build.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("building %s: %v\n%s", source, err, output)
+	}
+
+	return func(ctx context.Context, _ string) *exec.Cmd {
+		return exec.CommandContext(ctx, binary)
+	}, nil
+}
+
+func measure(name, interpreter, script string, run command) (Result, error) {
+	result := Result{
+		Benchmark:   name,
+		Interpreter: interpreter,
+		Metric:      seconds,
+	}
+
+	line, isBatch := batchLine[name]
+	if isBatch {
+		result.Metric = batches
+	}
+
+	for i := 0; i < *runs; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+
+		var stdout, stderr bytes.Buffer
+		cmd := run(ctx, script)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		start := time.Now()
+		err := cmd.Run()
+		elapsed := time.Since(start)
+		timedOut := ctx.Err() != nil
+		cancel()
+
+		if timedOut {
+			return result, fmt.Errorf("timed out after %s", *timeout)
+		} else if err != nil {
+			return result, fmt.Errorf("%v\n%s", err, firstLines(stderr.String(),
+				errorLines))
+		}
+
+		value := elapsed.Seconds()
+		if isBatch {
+			lines := strings.Split(stdout.String(), "\n")
+			if line >= len(lines) {
+				return result, fmt.Errorf("missing batch count in output")
+			}
+
+			if value, err = strconv.ParseFloat(strings.TrimSpace(lines[line]),
+				64); err != nil {
+				return result, fmt.Errorf("reading batch count: %v", err)
+			}
+		}
+
+		result.Runs = append(result.Runs, value)
+	}
+
+	result.Mean, result.Median, result.Stddev = statistics(result.Runs)
+	return result, nil
+}
+
+// firstLines returns the first n lines of s, noting how many more there are.
+func firstLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) <= n {
+		return strings.Join(lines, "\n")
+	}
+
+	return fmt.Sprintf("%s\n... %d more lines", strings.Join(lines[:n], "\n"),
+		len(lines)-n)
+}
+
+func statistics(values []float64) (mean, median, stddev float64) {
+	if len(values) == 0 {
+		return 0, 0, 0
+	}
+
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 1 {
+		median = sorted[n/2]
+	} else {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	// Sample standard deviation, since the runs are a sample of all the
+	// runs we could have made.
+	if len(values) > 1 {
+		for _, v := range values {
+			stddev += (v - mean) * (v - mean)
+		}
+		stddev = math.Sqrt(stddev / float64(len(values)-1))
+	}
+
+	return mean, median, stddev
+}
+
+// report prints the results, compared with the baseline when there is one,
+// and returns the number of regressions.
+func report(results []Result, old map[string]Result) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	header := "benchmark\tinterpreter\tmetric\tmean\tmedian\tstddev\t"
+	if old != nil {
+		header += "baseline\tchange\t"
+	}
+	fmt.Fprintln(w, header)
+
+	regressions := 0
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\tFAILED\t-\t-\t", r.Benchmark,
+				r.Interpreter, r.Metric)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.4f\t%.4f\t%.4f\t", r.Benchmark,
+				r.Interpreter, r.Metric, r.Mean, r.Median, r.Stddev)
+		}
+
+		// Failed runs have nothing to compare
+		if o, ok := old[r.key()]; ok && r.Error == "" && o.Error == "" {
+			change := r.change(o)
+			fmt.Fprintf(w, "%.4f\t%+.1f%%\t", o.Mean, change)
+			if change > *threshold {
+				fmt.Fprint(w, "REGRESSION")
+				regressions++
+			}
+		} else if old != nil {
+			fmt.Fprint(w, "-\t-\t")
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	return regressions
+}
+
+func load(path string) (map[string]Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	results := make(map[string]Result)
+	for _, r := range b.Results {
+		results[r.key()] = r
+	}
+
+	return results, nil
+}
+
+func write(path string, results []Result) error {
+	data, err := json.MarshalIndent(Baseline{time.Now().UTC(), results}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
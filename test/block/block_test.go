@@ -2,7 +2,7 @@ package block
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -15,24 +15,20 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestEmpty(t *testing.T) {
 	file := "empty.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "ok\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestScope(t *testing.T) {
 	file := "scope.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "inner\nouter\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
@@ -2,7 +2,7 @@ package bool
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -15,27 +15,23 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestEquality(t *testing.T) {
 	file := "equality.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "true\nfalse\nfalse\ntrue\n" +
 		"false\nfalse\nfalse\nfalse\nfalse\n" +
 		"false\ntrue\ntrue\nfalse\n" +
 		"true\ntrue\ntrue\ntrue\ntrue\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestNot(t *testing.T) {
 	file := "not.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "false\ntrue\ntrue\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
@@ -1,9 +1,8 @@
 package call
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,92 +15,41 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestBool(t *testing.T) {
 	file := "bool.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestNil(t *testing.T) {
 	file := "nil.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestNum(t *testing.T) {
 	file := "num.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestObject(t *testing.T) {
 	file := "object.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 4] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestString(t *testing.T) {
 	file := "string.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
@@ -1,9 +1,8 @@
 package class
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,93 +15,55 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestEmpty(t *testing.T) {
 	file := "empty.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "Foo\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestInheritSelf(t *testing.T) {
 	file := "inherit_self.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] error at "Foo": a class can't inherit from itself`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestInheritedMethod(t *testing.T) {
 	file := "inherited_method.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "in foo\nin bar\nin baz\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestLocalInheritOther(t *testing.T) {
 	file := "local_inherit_other.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "B\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestLocalInheritSelf(t *testing.T) {
 	file := "local_inherit_self.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 2] error at "Foo": a class can't inherit from itself`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestLocalReferenceSelf(t *testing.T) {
 	file := "local_reference_self.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "Foo\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestReferenceSelf(t *testing.T) {
 	file := "reference_self.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "Foo\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
@@ -2,7 +2,7 @@ package closure
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -15,156 +15,104 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestAssignToClosure(t *testing.T) {
 	file := "assign_to_closure.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "local\nafter f\nafter f\nafter g\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestAssignToShadowedLater(t *testing.T) {
 	file := "assign_to_shadowed_later.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "inner\nassigned\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCloseOverFunctionParameter(t *testing.T) {
 	file := "close_over_function_parameter.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "param\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCloseOverLaterVariable(t *testing.T) {
 	file := "close_over_later_variable.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "b\na\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCloseOverMethodParameter(t *testing.T) {
 	file := "close_over_method_parameter.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "param\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestClosedClosureInFunction(t *testing.T) {
 	file := "closed_closure_in_function.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "local\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestNestedClosure(t *testing.T) {
 	file := "nested_closure.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "a\nb\nc\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestOpenClosureInFunction(t *testing.T) {
 	file := "open_closure_in_function.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "local\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestReferenceClosureMultipleTimes(t *testing.T) {
 	file := "reference_closure_multiple_times.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "a\na\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestReuseClosureSlot(t *testing.T) {
 	file := "reuse_closure_slot.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "a\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestShadowClosureWithLocal(t *testing.T) {
 	file := "shadow_closure_with_local.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "closure\nshadow\nclosure\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestUnusedClosure(t *testing.T) {
 	file := "unused_closure.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "ok\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestUnusedLaterClosure(t *testing.T) {
 	file := "unused_later_closure.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "a\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestChpaterExample(t *testing.T) {
 	file := "chapter_example.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "global\nglobal\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
-}
\ No newline at end of file
+	common.ExpectOutput(t, file, expected)
+}
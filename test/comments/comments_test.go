@@ -2,7 +2,7 @@ package comments
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -15,46 +15,34 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestLineAtEOF(t *testing.T) {
 	file := "line_at_eof.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "ok\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestOnlyLineCommentAndLine(t *testing.T) {
 	file := "only_line_comment_and_line.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := ""
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %q got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestOnlyLineComment(t *testing.T) {
 	file := "only_line_comment.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := ""
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %q got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestUnicode(t *testing.T) {
 	file := "unicode.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "ok\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
-}
\ No newline at end of file
+	common.ExpectOutput(t, file, expected)
+}
@@ -9,24 +9,38 @@ import (
 )
 
 // interpreter=golox go test -v -count=1 ./...
-var interpreter = GetInterpreter()
-
 func GetInterpreter() string {
 	chk := os.Getenv("interpreter")
 
-	if chk != "golox" && chk != "clox" {
+	if chk != "golox" && chk != "golox-vm" && chk != "clox" {
 		log.Println("ERROR: interpreter not specified")
 		chk = "golox"
 		//chk = "clox"
 	}
 
-	if chk == "golox" {
+	// golox-vm runs the same golox binary with its bytecode engine selected,
+	// see GetEngine
+	if chk == "golox" || chk == "golox-vm" {
 		return "../../golox/golox"
 	} else {
 		return "../../clox/build/clox"
 	}
 }
 
+// GetEngine returns the golox engine the interpreter setting selects, or "" for
+// clox. The engine is passed to each run through its environment since every
+// test invokes the interpreter with only the script as an argument.
+func GetEngine() string {
+	switch os.Getenv("interpreter") {
+	case "clox":
+		return ""
+	case "golox-vm":
+		return "vm"
+	}
+
+	return "tree"
+}
+
 func GetStdOutLines(output []byte) [][]byte {
 	return bytes.Split(output, []byte{'\n'})
 }
@@ -42,7 +56,7 @@ func GetTokenInfo(output []byte) TokenInfo {
 
 	tokenLine := strings.Fields(string(output))
 
-	if strings.HasSuffix(interpreter, "clox") {
+	if strings.HasSuffix(GetInterpreter(), "clox") {
 		val, _ := strconv.Atoi(tokenLine[1])
 		token.Type = TokenType(val).String()
 	} else {
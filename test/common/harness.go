@@ -0,0 +1,373 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The harness is configured through the environment like the interpreter:
+//
+//	timeout=30s                    time allowed per script, 10s by default
+//	junit=/tmp/reports             write a JUnit XML report per package
+//	tap=/tmp/reports               write a TAP report per package
+//
+// Reports are named after the package, such as assignment.xml.
+const defaultTimeout = 10 * time.Second
+
+var (
+	setup       sync.Once
+	interpreter string
+	engine      string
+	timeout     time.Duration
+
+	mu    sync.Mutex
+	cases []testCase
+
+	// current maps a running test to its report entry so comparisons can
+	// attach a diff to it.
+	current sync.Map
+)
+
+// testCase is a finished test as it appears in the reports.
+type testCase struct {
+	name    string
+	file    string
+	elapsed time.Duration
+	failed  bool
+
+	// Set when a comparison made through the harness failed
+	message string
+	diff    string
+}
+
+func configure() {
+	setup.Do(func() {
+		interpreter = GetInterpreter()
+		engine = GetEngine()
+
+		timeout = defaultTimeout
+		if value := os.Getenv("timeout"); value != "" {
+			var err error
+			if timeout, err = time.ParseDuration(value); err != nil {
+				log.Fatalf("invalid timeout %q: %v", value, err)
+			}
+		}
+	})
+}
+
+// Result is what a script printed and how it exited.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Errors returns the lines the script wrote to stderr.
+func (r *Result) Errors() []string {
+	if r.Stderr == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(r.Stderr, "\n"), "\n")
+}
+
+// RequireEngine skips the test unless the interpreter runs one of the golox
+// engines, for the scripts of features that clox and some engines lack.
+func RequireEngine(t *testing.T, engines ...string) {
+	t.Helper()
+	configure()
+
+	for _, e := range engines {
+		if e == engine {
+			return
+		}
+	}
+
+	t.Skip("the interpreter doesn't support this feature")
+}
+
+// Run executes a script from the current test's directory. The test runs in
+// parallel with the other tests of its package, and the interpreter is killed
+// if it runs longer than the timeout, which fails the test.
+func Run(t *testing.T, file string) *Result {
+	t.Helper()
+	t.Parallel()
+	configure()
+
+	start := time.Now()
+	tc := &testCase{name: t.Name(), file: file}
+	current.Store(t, tc)
+	t.Cleanup(func() {
+		current.Delete(t)
+		tc.elapsed = time.Since(start)
+		tc.failed = t.Failed()
+
+		mu.Lock()
+		cases = append(cases, *tc)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, interpreter, file)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if engine != "" {
+		cmd.Env = append(os.Environ(), "LOXENGINE="+engine)
+	}
+
+	err := cmd.Run()
+	result := &Result{stdout.String(), stderr.String(), 0}
+
+	var exitErr *exec.ExitError
+	if ctx.Err() != nil {
+		tc.message = fmt.Sprintf("killed after running for %s", timeout)
+		t.Fatalf("%s: %s", file, tc.message)
+	} else if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		tc.message = err.Error()
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// ExpectOutput runs the script and fails the test unless its stdout is
+// exactly expected.
+func ExpectOutput(t *testing.T, file, expected string) {
+	t.Helper()
+	result := Run(t, file)
+	Compare(t, "output", expected, result.Stdout)
+}
+
+// ExpectError runs the script and fails the test unless the first lines it
+// writes to stderr are the expected ones.
+func ExpectError(t *testing.T, file string, expected ...string) {
+	t.Helper()
+	result := Run(t, file)
+
+	actual := result.Errors()
+	if len(actual) > len(expected) {
+		actual = actual[:len(expected)]
+	}
+
+	Compare(t, "error", strings.Join(expected, "\n"),
+		strings.Join(actual, "\n"))
+}
+
+// Compare fails the test with a line diff unless actual equals expected.
+func Compare(t *testing.T, what, expected, actual string) {
+	t.Helper()
+	if expected == actual {
+		return
+	}
+
+	diff := Diff(expected, actual)
+	if v, ok := current.Load(t); ok {
+		tc := v.(*testCase)
+		tc.message = "unexpected " + what
+		tc.diff = diff
+	}
+
+	t.Fatalf("unexpected %s (-expected +actual):\n%s", what, diff)
+}
+
+// Diff returns a line by line comparison of expected and actual. Lines only
+// in expected are prefixed with "-" and lines only in actual with "+".
+func Diff(expected, actual string) string {
+	a := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+
+	return sb.String()
+}
+
+// Main runs the tests of a package and then writes the reports requested in
+// the environment. Use it from TestMain:
+//
+//	func TestMain(m *testing.M) { os.Exit(common.Main(m)) }
+func Main(m *testing.M) int {
+	code := m.Run()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	suite := filepath.Base(wd)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Parallel tests finish in any order
+	sort.Slice(cases, func(i, j int) bool { return cases[i].name < cases[j].name })
+
+	if dir := os.Getenv("junit"); dir != "" {
+		if err := writeReport(dir, suite+".xml", junit(suite, cases)); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	if dir := os.Getenv("tap"); dir != "" {
+		if err := writeReport(dir, suite+".tap", tap(cases)); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	return code
+}
+
+func writeReport(dir, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, name), data, 0644)
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Diff    string `xml:",cdata"`
+}
+
+func junit(suite string, cases []testCase) []byte {
+	s := junitSuite{Name: suite, Tests: len(cases)}
+
+	var total time.Duration
+	for _, tc := range cases {
+		c := junitCase{
+			Name:      tc.name,
+			Classname: suite,
+			File:      filepath.Join(suite, tc.file),
+			Time:      seconds(tc.elapsed),
+		}
+
+		if tc.failed {
+			s.Failures++
+			c.Failure = &junitFailure{Message: failureMessage(tc), Diff: tc.diff}
+		}
+
+		total += tc.elapsed
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(total)
+
+	data, _ := xml.MarshalIndent(junitSuites{Suites: []junitSuite{s}}, "", "  ")
+	return append([]byte(xml.Header), append(data, '\n')...)
+}
+
+func tap(cases []testCase) []byte {
+	var sb strings.Builder
+	sb.WriteString("TAP version 13\n")
+	fmt.Fprintf(&sb, "1..%d\n", len(cases))
+
+	for i, tc := range cases {
+		status := "ok"
+		if tc.failed {
+			status = "not ok"
+		}
+		fmt.Fprintf(&sb, "%s %d - %s (%s)\n", status, i+1, tc.name, tc.file)
+
+		if !tc.failed {
+			continue
+		}
+
+		// Failure details go in a YAML block
+		sb.WriteString("  ---\n")
+		fmt.Fprintf(&sb, "  message: %q\n", failureMessage(tc))
+		fmt.Fprintf(&sb, "  duration_ms: %d\n", tc.elapsed.Milliseconds())
+		if tc.diff != "" {
+			sb.WriteString("  diff: |\n")
+			for _, line := range strings.Split(strings.TrimSuffix(tc.diff,
+				"\n"), "\n") {
+				sb.WriteString("    " + line + "\n")
+			}
+		}
+		sb.WriteString("  ...\n")
+	}
+
+	return []byte(sb.String())
+}
+
+func failureMessage(tc testCase) string {
+	if tc.message == "" {
+		return "test failed"
+	}
+
+	return tc.message
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
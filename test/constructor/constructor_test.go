@@ -1,9 +1,8 @@
 package constructor
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,151 +15,83 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestArguments(t *testing.T) {
 	file := "arguments.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "init\n1\n2\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCallInitEarlyReturn(t *testing.T) {
 	file := "call_init_early_return.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "init\ninit\nFoo instance\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCallInitExplicitly(t *testing.T) {
 	file := "call_init_explicitly.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "Foo.init(one)\nFoo.init(two)\nFoo instance\ninit\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestDefaultArguments(t *testing.T) {
 	file := "default_arguments.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] RuntimeError: expected 0 arguments but got 3`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestDefault(t *testing.T) {
 	file := "default.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "Foo instance\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestEarlyReturn(t *testing.T) {
 	file := "early_return.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "init\nFoo instance\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestExtraArguments(t *testing.T) {
 	file := "extra_arguments.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 8] RuntimeError: expected 2 arguments but got 4`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestInitNotMethod(t *testing.T) {
 	file := "init_not_method.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "not initializer\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestMissingArguments(t *testing.T) {
 	file := "missing_arguments.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 5] RuntimeError: expected 2 arguments but got 1`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestReturnInNestedFunction(t *testing.T) {
 	file := "return_in_nested_function.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "bar\nFoo instance\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestReturnValue(t *testing.T) {
 	file := "return_value.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] error at "return": can't return a value from an initializer`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
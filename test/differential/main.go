@@ -0,0 +1,332 @@
+// Differential runs every script under test/ and benchmark/ through both golox
+// and clox and reports each script whose stdout, stderr or exit code differs
+// between the two.
+//
+// Known differences between the implementations are normalized away before
+// comparing:
+//   - token dumps print clox token types as numbers and include its
+//     ERROR tokens, golox prints type names and has no ERROR token
+//   - golox quotes the lexeme of a string token in errors with %q
+//   - the golox parser keeps reporting errors for the rest of a statement
+//     after the first one, so only the first syntax error is compared
+//   - benchmarks print timings and throughput, so numbers in their output
+//     are not compared
+//
+// From the test directory:
+//
+//	go run ./differential
+//	go run ./differential -engine=vm -report=divergences.txt
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mz1290/craftinginterpreters/test/common"
+)
+
+var (
+	golox   = flag.String("golox", "../golox/golox", "path to the golox binary")
+	clox    = flag.String("clox", "../clox/build/clox", "path to the clox binary")
+	engine  = flag.String("engine", "tree", "golox execution engine: tree or vm")
+	timeout = flag.Duration("timeout", 2*time.Minute, "time allowed per script")
+	report  = flag.String("report", "", "write the report to a file instead "+
+		"of stdout")
+	jobs = flag.Int("j", runtime.NumCPU(), "number of scripts to run at once")
+)
+
+// Directories, relative to the test directory, holding the scripts to run.
+// Scripts in the scanning directory are run with the token dump enabled, like
+// the scanning tests do.
+var suites = []string{".", "../benchmark"}
+
+// Directories holding the scripts of golox extensions, which clox doesn't
+// implement and so are not compared.
+var extensions = []string{"for_in", "generator", "spawn"}
+
+// result is everything a script produced that is compared.
+type result struct {
+	stdout string
+	stderr string
+	exit   int
+}
+
+// divergence records the normalized results of a script that differed.
+type divergence struct {
+	path        string
+	golox, clox result
+	differences []string
+}
+
+func main() {
+	flag.Parse()
+
+	for _, binary := range []string{*golox, *clox} {
+		if _, err := os.Stat(binary); err != nil {
+			fmt.Fprintf(os.Stderr, "%s not found, build both interpreters "+
+				"first\n", binary)
+			os.Exit(2)
+		}
+	}
+
+	var paths []string
+	for _, suite := range suites {
+		found, err := discover(suite)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		paths = append(paths, found...)
+	}
+
+	divergences := compareAll(paths)
+
+	if *report == "" {
+		writeReport(os.Stdout, len(paths), divergences)
+	} else {
+		f, err := os.Create(*report)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		writeReport(f, len(paths), divergences)
+		f.Close()
+	}
+
+	if len(divergences) > 0 {
+		os.Exit(1)
+	}
+}
+
+func discover(dir string) ([]string, error) {
+	var paths []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			for _, name := range extensions {
+				if info.Name() == name {
+					return filepath.SkipDir
+				}
+			}
+		}
+
+		if !info.IsDir() && filepath.Ext(path) == ".lox" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths, err
+}
+
+// compareAll runs the scripts in parallel and returns the divergences sorted
+// by path.
+func compareAll(paths []string) []divergence {
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		divergences []divergence
+	)
+
+	queue := make(chan string)
+	for i := 0; i < *jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				if d, ok := compare(path); !ok {
+					mu.Lock()
+					divergences = append(divergences, d)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.Slice(divergences, func(i, j int) bool {
+		return divergences[i].path < divergences[j].path
+	})
+
+	return divergences
+}
+
+func compare(path string) (divergence, bool) {
+	env := []string{"DEBUGLOX="}
+	if filepath.Base(filepath.Dir(path)) == "scanning" {
+		env = []string{"DEBUGLOX=scanning"}
+	}
+
+	benchmark := strings.HasPrefix(filepath.ToSlash(path), "../benchmark/")
+	d := divergence{
+		path: path,
+		golox: normalize(run(path, append(env, "LOXENGINE="+*engine), *golox),
+			benchmark),
+		clox: normalize(run(path, env, *clox), benchmark),
+	}
+
+	if d.golox.stdout != d.clox.stdout {
+		d.differences = append(d.differences, "stdout")
+	}
+	if d.golox.stderr != d.clox.stderr {
+		d.differences = append(d.differences, "stderr")
+	}
+	if d.golox.exit != d.clox.exit {
+		d.differences = append(d.differences, "exit code")
+	}
+
+	return d, len(d.differences) == 0
+}
+
+func run(path string, env []string, binary string) result {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Scripts are run from their own directory, like the Go tests do
+	if !filepath.IsAbs(binary) {
+		abs, _ := filepath.Abs(binary)
+		cmd.Path = abs
+	}
+
+	err := cmd.Run()
+
+	exit := 0
+	var exitErr *exec.ExitError
+	if ctx.Err() != nil {
+		exit = -1
+		stderr.WriteString("timed out after " + timeout.String() + "\n")
+	} else if errors.As(err, &exitErr) {
+		exit = exitErr.ExitCode()
+	} else if err != nil {
+		exit = -1
+		stderr.WriteString(err.Error() + "\n")
+	}
+
+	return result{stdout.String(), stderr.String(), exit}
+}
+
+var (
+	tokenPattern       = regexp.MustCompile(`^type: (\S+)\s+lexeme: (.*?)\s+line: (\d+)$`)
+	syntaxErrorPattern = regexp.MustCompile(`^\[line \d+\] error`)
+	numberPattern      = regexp.MustCompile(`^-?\d+(\.\d+)?(e[+-]?\d+)?$`)
+)
+
+func normalize(r result, benchmark bool) result {
+	var stdout []string
+	for _, line := range strings.Split(r.stdout, "\n") {
+		if m := tokenPattern.FindStringSubmatch(line); m != nil {
+			tokenType := m[1]
+			if n, err := strconv.Atoi(tokenType); err == nil {
+				tokenType = common.TokenType(n).String()
+			}
+
+			if tokenType == common.ERROR.String() {
+				continue
+			}
+			line = fmt.Sprintf("type: %s lexeme: %s line: %s", tokenType,
+				m[2], m[3])
+		} else if benchmark && numberPattern.MatchString(line) {
+			line = "<number>"
+		}
+		stdout = append(stdout, line)
+	}
+	r.stdout = strings.Join(stdout, "\n")
+
+	var stderr []string
+	seenSyntaxError := false
+	for _, line := range strings.Split(r.stderr, "\n") {
+		if syntaxErrorPattern.MatchString(line) {
+			if seenSyntaxError {
+				continue
+			}
+			seenSyntaxError = true
+		}
+		stderr = append(stderr, strings.ReplaceAll(line, `\"`, `"`))
+	}
+	r.stderr = strings.Join(stderr, "\n")
+
+	return r
+}
+
+func writeReport(w io.Writer, total int, divergences []divergence) {
+	for _, d := range divergences {
+		fmt.Fprintf(w, "DIVERGED %s (%s)\n", d.path,
+			strings.Join(d.differences, ", "))
+
+		for _, difference := range d.differences {
+			switch difference {
+			case "stdout":
+				writeDiff(w, "stdout", d.golox.stdout, d.clox.stdout)
+			case "stderr":
+				writeDiff(w, "stderr", d.golox.stderr, d.clox.stderr)
+			case "exit code":
+				fmt.Fprintf(w, "  exit code: golox %d, clox %d\n",
+					d.golox.exit, d.clox.exit)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%d scripts, %d diverged\n", total, len(divergences))
+}
+
+// writeDiff shows the first line that differs along with the two lines that
+// follow it.
+func writeDiff(w io.Writer, name, golox, clox string) {
+	g := strings.Split(strings.TrimSuffix(golox, "\n"), "\n")
+	c := strings.Split(strings.TrimSuffix(clox, "\n"), "\n")
+
+	first := 0
+	for first < len(g) && first < len(c) && g[first] == c[first] {
+		first++
+	}
+
+	fmt.Fprintf(w, "  %s differs at line %d:\n", name, first+1)
+	for _, side := range []struct {
+		name  string
+		lines []string
+	}{{"golox", g}, {"clox", c}} {
+		end := first + 3
+		if end > len(side.lines) {
+			end = len(side.lines)
+		}
+
+		start := first
+		if start > len(side.lines) {
+			start = len(side.lines)
+		}
+
+		for _, line := range side.lines[start:end] {
+			fmt.Fprintf(w, "    %-5s | %s\n", side.name, line)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package expressions
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -18,13 +17,13 @@ func init() {
 	interpreter = common.GetInterpreter()
 	fmt.Printf("USING: %s\n", interpreter)
 }
+
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
 func TestExpressions(t *testing.T) {
 	file := "expressions.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "2\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %x got %x", expected, stdout)
-	}
+	common.ExpectOutput(t, file, expected)
 }
@@ -1,9 +1,8 @@
 package field
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,158 +15,75 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestCallFunctionField(t *testing.T) {
 	file := "call_function_field.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "bar\n1\n2\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestCallNonFunctionField(t *testing.T) {
 	file := "call_nonfunction_field.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 6] RuntimeError: can only call functions and classes`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetAndSetMethod(t *testing.T) {
 	file := "get_and_set_method.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "other\n1\nmethod\n2\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestGetOnBool(t *testing.T) {
 	file := "get_on_bool.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetOnClass(t *testing.T) {
 	file := "get_on_class.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 2] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetOnFunction(t *testing.T) {
 	file := "get_on_function.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetOnNil(t *testing.T) {
 	file := "get_on_nil.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetOnNum(t *testing.T) {
 	file := "get_on_num.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestGetOnString(t *testing.T) {
 	file := "get_on_string.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have properties`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestMany(t *testing.T) {
 	file := "many.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected :=
 		"apple\n" +
 			"apricot\n" +
@@ -249,184 +165,82 @@ func TestMany(t *testing.T) {
 			"watermelon\n" +
 			"yuzu\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestMethodBindsThis(t *testing.T) {
 	file := "method_binds_this.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "foo1\n1\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestMethod(t *testing.T) {
 	file := "method.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "got method\narg\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestOnInstance(t *testing.T) {
 	file := "on_instance.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected := "bar value\nbaz value\nbar value\nbaz value\n"
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
 
 func TestSetEvaluationOrder(t *testing.T) {
 	file := "set_evaluation_order.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: undefined variable "undefined1"`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnBool(t *testing.T) {
 	file := "set_on_bool.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnClass(t *testing.T) {
 	file := "set_on_class.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 2] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnFunction(t *testing.T) {
 	file := "set_on_function.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 3] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnNil(t *testing.T) {
 	file := "set_on_nil.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnNum(t *testing.T) {
 	file := "set_on_num.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestSetOnString(t *testing.T) {
 	file := "set_on_string.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 1] RuntimeError: only instances have fields`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
 
 func TestUndefined(t *testing.T) {
 	file := "undefined.lox"
-	cmd := exec.Command(interpreter, file)
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		t.Fatal(err)
-	}
-
 	expected := `[line 4] RuntimeError: undefined property "bar"`
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Scan()
-	actualErr := scanner.Text()
-	if actualErr != expected {
-		t.Fatalf("expected error (%s) got %s", expected, actualErr)
-	}
+	common.ExpectError(t, file, expected)
 }
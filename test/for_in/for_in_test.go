@@ -0,0 +1,94 @@
+package forin
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mz1290/craftinginterpreters/test/common"
+)
+
+var interpreter = ""
+
+func init() {
+	interpreter = common.GetInterpreter()
+	fmt.Printf("USING: %s\n", interpreter)
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
+// For-in loops are a golox extension, which clox doesn't implement
+
+func TestClosureInBody(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "closure_in_body.lox"
+	expected := "0\n1\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestIterableInstance(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "iterable_instance.lox"
+	expected := "2\n1\n2\n1\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestNotIterable(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "not_iterable.lox"
+	expected := "[line 1] RuntimeError: can only iterate over lists, strings, ranges and iterables"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestRange(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "range.lox"
+	expected := "0\n1\n2\n2\n3\n6\n3\nrange(1, 2, 1)\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestRangeInfiniteEnd(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "range_infinite_end.lox"
+	expected := "[line 1] RuntimeError: range bounds must be finite"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestRangeNanStep(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "range_nan_step.lox"
+	expected := "[line 1] RuntimeError: range bounds must be finite"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestRangeZeroStep(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "range_zero_step.lox"
+	expected := "[line 1] RuntimeError: range step can't be zero"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestReturnInside(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "return_inside.lox"
+	expected := "1\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestString(t *testing.T) {
+	common.RequireEngine(t, "tree", "vm")
+	file := "string.lox"
+	expected := "h\né\n!\n"
+
+	common.ExpectOutput(t, file, expected)
+}
@@ -0,0 +1,142 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mz1290/craftinginterpreters/test/common"
+)
+
+var interpreter = ""
+
+func init() {
+	interpreter = common.GetInterpreter()
+	fmt.Printf("USING: %s\n", interpreter)
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
+// Generators are only implemented by golox's tree-walk interpreter
+
+func TestAlreadyRunning(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "already_running.lox"
+	expected := "[line 2] RuntimeError: generator g is already running"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestChannel(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "channel.lox"
+	expected := "a\nb\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestErrorInBody(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "error_in_body.lox"
+	expected := "[line 2] RuntimeError: operand must be a number"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestExhausted(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "exhausted.lox"
+	expected := "[line 2] RuntimeError: generator g has no values left"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestForIn(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "for_in.lox"
+	expected := "1\n4\n9\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestFunStar(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "fun_star.lox"
+	expected := "0\n1\n2\nfalse\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestInitializer(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "initializer.lox"
+	expected := `[line 2] error at "init": an initializer can't be a generator`
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestInstanceIterator(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "instance_iterator.lox"
+	expected := "4\n2\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestLazy(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "lazy.lox"
+	expected := "called\nstarted\n1\nresumed\nfalse\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestMethod(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "method.lox"
+	expected := "3\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestReturnEnds(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "return_ends.lox"
+	expected := "1\nfalse\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestReturnValue(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "return_value.lox"
+	expected := `[line 2] error at "return": can't return a value from a generator`
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestSharedByGoroutines(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "shared_by_goroutines.lox"
+	expected := "5000\nfalse\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestYieldAtTopLevel(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "yield_at_top_level.lox"
+	expected := `[line 1] error at "yield": can't yield from top-level code`
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestYieldMakesGenerator(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "yield_makes_generator.lox"
+	expected := "<generator pair>\nxxx\n"
+
+	common.ExpectOutput(t, file, expected)
+}
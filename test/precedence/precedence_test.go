@@ -1,9 +1,9 @@
 package precedence
 
 import (
+	"os"
 	//"bufio"
 	"fmt"
-	"os/exec"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -16,10 +16,12 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestPrecedence(t *testing.T) {
 	file := "precedence.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
 	expected :=
 		`14
 8
@@ -36,7 +38,5 @@ true
 4
 `
 
-	if string(stdout) != expected {
-		t.Fatalf("expected %s got %s", expected, string(stdout))
-	}
+	common.ExpectOutput(t, file, expected)
 }
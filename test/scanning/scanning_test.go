@@ -3,7 +3,6 @@ package scanning
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"testing"
 
 	"github.com/mz1290/craftinginterpreters/test/common"
@@ -19,10 +18,13 @@ func init() {
 	fmt.Printf("USING: %s\n", interpreter)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
 func TestIdentifiers(t *testing.T) {
 	file := "identifiers.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"IDENTIFIER", "andy", "1"},
@@ -53,8 +55,7 @@ func TestIdentifiers(t *testing.T) {
 
 func TestKeywords(t *testing.T) {
 	file := "keywords.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"AND", "and", "1"},
@@ -92,8 +93,7 @@ func TestKeywords(t *testing.T) {
 
 func TestNumbers(t *testing.T) {
 	file := "numbers.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"NUMBER", "123", "1"},
@@ -122,8 +122,7 @@ func TestNumbers(t *testing.T) {
 
 func TestPunctuators(t *testing.T) {
 	file := "punctuators.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"LEFT_PAREN", "(", "1"},
@@ -164,8 +163,7 @@ func TestPunctuators(t *testing.T) {
 
 func TestStrings(t *testing.T) {
 	file := "strings.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"STRING", `""`, "1"},
@@ -190,8 +188,7 @@ func TestStrings(t *testing.T) {
 
 func TestWhitespace(t *testing.T) {
 	file := "whitespace.lox"
-	cmd := exec.Command(interpreter, file)
-	stdout, _ := cmd.Output()
+	stdout := []byte(common.Run(t, file).Stdout)
 
 	expected := []common.TokenInfo{
 		{"IDENTIFIER", "space", "2"},
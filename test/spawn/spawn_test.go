@@ -0,0 +1,102 @@
+package spawn
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mz1290/craftinginterpreters/test/common"
+)
+
+var interpreter = ""
+
+func init() {
+	interpreter = common.GetInterpreter()
+	fmt.Printf("USING: %s\n", interpreter)
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(common.Main(m))
+}
+
+// Goroutines are only implemented by golox's tree-walk interpreter
+
+func TestDeadlock(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "deadlock.lox"
+	expected := "[line 2] RuntimeError: all goroutines are asleep - deadlock"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestFanOut(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "fan_out.lox"
+	expected := "14\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestGoroutineError(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "goroutine_error.lox"
+	expected := "[line 1] RuntimeError: operand must be a number"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestMethod(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "method.lox"
+	expected := "hi lox\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestMutex(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "mutex.lox"
+	expected := "9000\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestScriptEnds(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "script_ends.lox"
+	expected := "done\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestSelect(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "select.lox"
+	expected := "[1, b]\n"
+
+	common.ExpectOutput(t, file, expected)
+}
+
+func TestSelectNonChannel(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "select_non_channel.lox"
+	expected := "[line 1] RuntimeError: can only select channels"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestSendOnClosedChannel(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "send_on_closed_channel.lox"
+	expected := "[line 3] RuntimeError: send on closed channel"
+
+	common.ExpectError(t, file, expected)
+}
+
+func TestUnbuffered(t *testing.T) {
+	common.RequireEngine(t, "tree")
+	file := "unbuffered.lox"
+	expected := "0\n1\n2\n"
+
+	common.ExpectOutput(t, file, expected)
+}